@@ -0,0 +1,200 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+)
+
+// ToStreaming returns StreamingStorage that performs all operations using the provided Storage.
+//
+// Storage returned by FromStreaming() is unwrapped, other implementations are adapted by buffering
+// block contents in memory and checking for cancellation before each call.
+func ToStreaming(s Storage) StreamingStorage {
+	switch s := s.(type) {
+	case *storageAdapter:
+		return s.base
+	case *storageAdapterWithConnectionInfo:
+		return s.base
+	}
+
+	a := &streamingAdapter{base: s}
+	if cip, ok := s.(ConnectionInfoProvider); ok {
+		return &streamingAdapterWithConnectionInfo{a, cip}
+	}
+
+	return a
+}
+
+// FromStreaming returns Storage that performs all operations using the provided StreamingStorage.
+//
+// StreamingStorage returned by ToStreaming() is unwrapped, other implementations are adapted by
+// reading block contents into memory using background context.
+func FromStreaming(s StreamingStorage) Storage {
+	switch s := s.(type) {
+	case *streamingAdapter:
+		return s.base
+	case *streamingAdapterWithConnectionInfo:
+		return s.base
+	}
+
+	a := &storageAdapter{base: s}
+	if cip, ok := s.(ConnectionInfoProvider); ok {
+		return &storageAdapterWithConnectionInfo{a, cip}
+	}
+
+	return a
+}
+
+// GetBlockBytes reads the specified section of a block from StreamingStorage into memory.
+func GetBlockBytes(ctx context.Context, s StreamingStorage, id string, offset, length int64) ([]byte, error) {
+	r, err := s.GetBlock(ctx, id, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+type streamingAdapter struct {
+	base Storage
+}
+
+type streamingAdapterWithConnectionInfo struct {
+	*streamingAdapter
+	ConnectionInfoProvider
+}
+
+func (s *streamingAdapter) BlockSize(ctx context.Context, id string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return s.base.BlockSize(id)
+}
+
+func (s *streamingAdapter) PutBlock(ctx context.Context, id string, data io.Reader) error {
+	b, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.base.PutBlock(id, b)
+}
+
+func (s *streamingAdapter) DeleteBlock(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.base.DeleteBlock(id)
+}
+
+func (s *streamingAdapter) GetBlock(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	b, err := s.base.GetBlock(id, offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (s *streamingAdapter) ListBlocks(ctx context.Context, prefix string) (chan BlockMetadata, CancelFunc) {
+	ch, cancel := s.base.ListBlocks(prefix)
+	result := make(chan BlockMetadata)
+	cancelled := make(chan bool)
+
+	go func() {
+		defer close(result)
+		defer cancel()
+
+		for {
+			if ctx.Err() != nil {
+				sendCancellationError(ctx, result, cancelled)
+				return
+			}
+
+			select {
+			case bm, ok := <-ch:
+				if !ok {
+					return
+				}
+
+				select {
+				case result <- bm:
+				case <-cancelled:
+					return
+				case <-ctx.Done():
+				}
+
+			case <-cancelled:
+				return
+
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return result, func() {
+		close(cancelled)
+	}
+}
+
+func (s *streamingAdapter) Close() error {
+	return s.base.Close()
+}
+
+// sendCancellationError notifies the consumer of ListBlocks() results that the listing is incomplete
+// because the context has been cancelled.
+func sendCancellationError(ctx context.Context, ch chan BlockMetadata, cancelled chan bool) {
+	select {
+	case ch <- BlockMetadata{Error: ctx.Err()}:
+	case <-cancelled:
+	}
+}
+
+type storageAdapter struct {
+	base StreamingStorage
+}
+
+type storageAdapterWithConnectionInfo struct {
+	*storageAdapter
+	ConnectionInfoProvider
+}
+
+func (s *storageAdapter) BlockSize(id string) (int64, error) {
+	return s.base.BlockSize(context.Background(), id)
+}
+
+func (s *storageAdapter) PutBlock(id string, data []byte) error {
+	return s.base.PutBlock(context.Background(), id, bytes.NewReader(data))
+}
+
+func (s *storageAdapter) DeleteBlock(id string) error {
+	return s.base.DeleteBlock(context.Background(), id)
+}
+
+func (s *storageAdapter) GetBlock(id string, offset, length int64) ([]byte, error) {
+	return GetBlockBytes(context.Background(), s.base, id, offset, length)
+}
+
+func (s *storageAdapter) ListBlocks(prefix string) (chan BlockMetadata, CancelFunc) {
+	return s.base.ListBlocks(context.Background(), prefix)
+}
+
+func (s *storageAdapter) Close() error {
+	return s.base.Close()
+}
+
+var _ StreamingStorage = &streamingAdapter{}
+var _ Storage = &storageAdapter{}
@@ -0,0 +1,47 @@
+package blob_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kopia/kopia/blob"
+	"github.com/kopia/kopia/internal/storagetesting"
+)
+
+func TestAdapters(t *testing.T) {
+	data := map[string][]byte{}
+	st := storagetesting.NewMapStorage(data)
+
+	ss := blob.ToStreaming(st)
+	if got := blob.FromStreaming(ss); got != st {
+		t.Errorf("FromStreaming() did not unwrap the adapter: %v", got)
+	}
+
+	storagetesting.VerifyStorage(t, blob.FromStreaming(ss))
+}
+
+func TestAdapterCancellation(t *testing.T) {
+	data := map[string][]byte{"a": []byte{1}, "b": []byte{2}}
+	ss := blob.ToStreaming(storagetesting.NewMapStorage(data))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := ss.GetBlock(ctx, "a", 0, -1); err != context.Canceled {
+		t.Errorf("unexpected GetBlock() error: %v", err)
+	}
+
+	ch, cancelList := ss.ListBlocks(ctx, "")
+	defer cancelList()
+
+	var gotError bool
+	for bm := range ch {
+		if bm.Error != nil {
+			gotError = true
+		}
+	}
+
+	if !gotError {
+		t.Errorf("cancelled ListBlocks() did not report an error")
+	}
+}
@@ -2,11 +2,17 @@
 package caching
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -16,22 +22,40 @@ import (
 
 var (
 	dbBucketBlocks = []byte("Blocks")
-	currentTime    func() int64
 )
 
 const (
-	defaultCacheSizeBytes = 100000000
+	defaultCacheSizeBytes     = 100000000
+	defaultEvictionInterval   = 1 * time.Minute
+	evictionTargetSizePercent = 90
 )
 
 type cachingStorage struct {
-	master    blob.Storage
-	cache     blob.Storage
-	db        *bolt.DB
-	sizeBytes int64
+	// Keep int64 fields first to ensure they get aligned to at least 64-bit boundaries
+	// which is required for atomic access on ARM and x86-32.
+	hits      int64
+	misses    int64
+	evictions int64
+
+	master      blob.StreamingStorage
+	cache       blob.StreamingStorage
+	db          *bolt.DB
+	sizeBytes   int64
+	shouldCache func(blockID string) bool
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	evictorWG sync.WaitGroup
 
 	*lockMap
 }
 
+// cachingStorageWithConnectionInfo is returned when the master storage can persist its connection info.
+type cachingStorageWithConnectionInfo struct {
+	*cachingStorage
+	blob.ConnectionInfoProvider
+}
+
 func defaultGetCurrentTime() int64 {
 	return time.Now().UnixNano()
 }
@@ -97,7 +121,11 @@ func (c *cachingStorage) removeCacheEntry(block string) {
 	})
 }
 
-func (c *cachingStorage) BlockSize(id string) (int64, error) {
+func (c *cachingStorage) BlockSize(ctx context.Context, id string) (int64, error) {
+	if !c.shouldCache(id) {
+		return c.master.BlockSize(ctx, id)
+	}
+
 	if entry, ok := c.getCacheEntry(id); ok {
 		if entry.exists() {
 			return entry.size, nil
@@ -109,7 +137,7 @@ func (c *cachingStorage) BlockSize(id string) (int64, error) {
 	c.Lock(id)
 	defer c.Unlock(id)
 
-	l, err := c.master.BlockSize(id)
+	l, err := c.master.BlockSize(ctx, id)
 	if err != nil {
 		if err == blob.ErrBlockNotFound {
 			c.setCacheEntrySize(id, sizeDoesNotExists)
@@ -121,14 +149,18 @@ func (c *cachingStorage) BlockSize(id string) (int64, error) {
 	return l, nil
 }
 
-func (c *cachingStorage) DeleteBlock(id string) error {
+func (c *cachingStorage) DeleteBlock(ctx context.Context, id string) error {
+	if !c.shouldCache(id) {
+		return c.master.DeleteBlock(ctx, id)
+	}
+
 	c.Lock(id)
 	defer c.Unlock(id)
 
 	// Remove from cache first.
-	c.cache.DeleteBlock(id)
+	c.cache.DeleteBlock(ctx, id)
 
-	if err := c.master.DeleteBlock(id); err != nil {
+	if err := c.master.DeleteBlock(ctx, id); err != nil {
 		return err
 	}
 	c.setCacheEntrySize(id, sizeDoesNotExists)
@@ -136,65 +168,203 @@ func (c *cachingStorage) DeleteBlock(id string) error {
 	return nil
 }
 
-func (c *cachingStorage) GetBlock(id string, offset, length int64) ([]byte, error) {
+func (c *cachingStorage) GetBlock(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error) {
+	if !c.shouldCache(id) {
+		return c.master.GetBlock(ctx, id, offset, length)
+	}
+
 	c.Lock(id)
 	defer c.Unlock(id)
 
 	if blockCacheEntry, ok := c.getCacheEntry(id); ok {
 		if !blockCacheEntry.exists() {
+			atomic.AddInt64(&c.hits, 1)
 			return nil, blob.ErrBlockNotFound
 		}
 
-		v, err := c.cache.GetBlock(id, offset, length)
+		v, err := c.cache.GetBlock(ctx, id, offset, length)
 		if err == nil {
+			atomic.AddInt64(&c.hits, 1)
 			return v, nil
 		}
 	}
 
-	// Download from master
-	b, err := c.master.GetBlock(id, 0, -1)
+	atomic.AddInt64(&c.misses, 1)
 
-	if err == nil {
-		l := int64(len(b))
-		c.cache.PutBlock(id, b)
-		c.setCacheEntrySize(id, l)
-	} else if err == blob.ErrBlockNotFound {
+	// Download the entire block from master.
+	b, err := blob.GetBlockBytes(ctx, c.master, id, 0, -1)
+	if err == blob.ErrBlockNotFound {
 		c.setCacheEntrySize(id, sizeDoesNotExists)
 	}
 
-	return b, err
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.cache.PutBlock(ctx, id, bytes.NewReader(b)); err == nil {
+		c.setCacheEntrySize(id, int64(len(b)))
+	} else {
+		log.Printf("warning: unable to add block %q to cache: %v", id, err)
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(blockSection(b, offset, length))), nil
 }
 
-func (c *cachingStorage) PutBlock(id string, data []byte) error {
+func blockSection(b []byte, offset, length int64) []byte {
+	if offset > int64(len(b)) {
+		return nil
+	}
+
+	b = b[offset:]
+	if length >= 0 && length < int64(len(b)) {
+		b = b[0:length]
+	}
+
+	return b
+}
+
+func (c *cachingStorage) PutBlock(ctx context.Context, id string, data io.Reader) error {
+	if !c.shouldCache(id) {
+		return c.master.PutBlock(ctx, id, data)
+	}
+
 	c.Lock(id)
 	defer c.Unlock(id)
 
 	// Remove from cache first.
-	c.cache.DeleteBlock(id)
+	c.cache.DeleteBlock(ctx, id)
 	c.removeCacheEntry(id)
 
-	return c.master.PutBlock(id, data)
+	return c.master.PutBlock(ctx, id, data)
 }
 
-func (c *cachingStorage) ListBlocks(prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
-	return c.master.ListBlocks(prefix)
+func (c *cachingStorage) ListBlocks(ctx context.Context, prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
+	return c.master.ListBlocks(ctx, prefix)
 }
 
-func (c *cachingStorage) Close() error {
-	if c.db != nil {
-		c.db.Close()
-		c.db = nil
+// Stats contains statistics of the cache.
+type Stats struct {
+	Hits         int64 `json:"hits"`
+	Misses       int64 `json:"misses"`
+	Evictions    int64 `json:"evictions"`
+	Entries      int   `json:"entries"`
+	SizeBytes    int64 `json:"sizeBytes"`
+	MaxSizeBytes int64 `json:"maxSizeBytes"`
+}
+
+// StatsProvider is implemented by storage that can report its cache statistics.
+type StatsProvider interface {
+	CacheStats() Stats
+}
+
+func (c *cachingStorage) CacheStats() Stats {
+	s := Stats{
+		Hits:         atomic.LoadInt64(&c.hits),
+		Misses:       atomic.LoadInt64(&c.misses),
+		Evictions:    atomic.LoadInt64(&c.evictions),
+		MaxSizeBytes: c.sizeBytes,
 	}
 
-	if c.cache != nil {
-		c.cache.Close()
-		c.cache = nil
+	c.db.View(func(t *bolt.Tx) error {
+		b := t.Bucket(dbBucketBlocks)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var e blockCacheEntry
+			if e.deserialize(v) == nil && e.exists() {
+				s.Entries++
+				s.SizeBytes += e.size
+			}
+			return nil
+		})
+	})
+
+	return s
+}
+
+type evictionCandidate struct {
+	blockID string
+	blockCacheEntry
+}
+
+// evict removes least recently used blocks from the cache until its size drops below
+// the target size, which is slightly lower than the limit to avoid evicting on every pass.
+func (c *cachingStorage) evict(ctx context.Context) {
+	var candidates []evictionCandidate
+	var totalSize int64
+
+	c.db.View(func(t *bolt.Tx) error {
+		b := t.Bucket(dbBucketBlocks)
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var e blockCacheEntry
+			if e.deserialize(v) != nil {
+				return nil
+			}
+
+			candidates = append(candidates, evictionCandidate{string(k), e})
+			if e.exists() {
+				totalSize += e.size
+			}
+			return nil
+		})
+	})
+
+	if totalSize <= c.sizeBytes {
+		return
 	}
 
-	if c.master != nil {
-		c.master.Close()
-		c.master = nil
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].accessTime < candidates[j].accessTime
+	})
+
+	targetSize := c.sizeBytes * evictionTargetSizePercent / 100
+	for _, e := range candidates {
+		if totalSize <= targetSize {
+			break
+		}
+
+		c.Lock(e.blockID)
+		c.cache.DeleteBlock(ctx, e.blockID)
+		c.removeCacheEntry(e.blockID)
+		c.Unlock(e.blockID)
+
+		if e.exists() {
+			totalSize -= e.size
+		}
+		atomic.AddInt64(&c.evictions, 1)
+	}
+}
+
+func (c *cachingStorage) runEvictor(ctx context.Context, interval time.Duration) {
+	defer c.evictorWG.Done()
+
+	for {
+		c.evict(ctx)
+
+		select {
+		case <-c.closed:
+			return
+		case <-time.After(interval):
+		}
 	}
+}
+
+func (c *cachingStorage) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.evictorWG.Wait()
+
+		c.db.Close()
+		c.cache.Close()
+		c.master.Close()
+	})
+
 	return nil
 }
 
@@ -202,10 +372,16 @@ func (c *cachingStorage) Close() error {
 type Options struct {
 	CacheDir       string `json:"cacheDir"`
 	CacheSizeBytes int64  `json:"cacheSizeBytes"`
+
+	// ShouldCache determines whether the block with a given ID should be cached, all blocks are cached if nil.
+	ShouldCache func(blockID string) bool `json:"-"`
+
+	// EvictionInterval specifies how often cache size limit is enforced in the background.
+	EvictionInterval time.Duration `json:"-"`
 }
 
 // NewWrapper creates new caching storage wrapper.
-func NewWrapper(ctx context.Context, master blob.Storage, options *Options) (blob.Storage, error) {
+func NewWrapper(ctx context.Context, master blob.StreamingStorage, options *Options) (blob.StreamingStorage, error) {
 	if options.CacheDir == "" {
 		return nil, fmt.Errorf("Cache directory must be specified")
 	}
@@ -224,6 +400,7 @@ func NewWrapper(ctx context.Context, master blob.Storage, options *Options) (blo
 	})
 
 	if err != nil {
+		db.Close()
 		return nil, fmt.Errorf("cannot open cache directory: %v", err)
 	}
 
@@ -232,15 +409,35 @@ func NewWrapper(ctx context.Context, master blob.Storage, options *Options) (blo
 		sizeBytes = defaultCacheSizeBytes
 	}
 
+	shouldCache := options.ShouldCache
+	if shouldCache == nil {
+		shouldCache = func(string) bool { return true }
+	}
+
+	evictionInterval := options.EvictionInterval
+	if evictionInterval == 0 {
+		evictionInterval = defaultEvictionInterval
+	}
+
 	s := &cachingStorage{
-		master:    master,
-		cache:     cs,
-		db:        db,
-		sizeBytes: sizeBytes,
-		lockMap:   newLockMap(),
+		master:      master,
+		cache:       blob.ToStreaming(cs),
+		db:          db,
+		sizeBytes:   sizeBytes,
+		shouldCache: shouldCache,
+		closed:      make(chan struct{}),
+		lockMap:     newLockMap(),
+	}
+
+	s.evictorWG.Add(1)
+	go s.runEvictor(ctx, evictionInterval)
+
+	if cip, ok := master.(blob.ConnectionInfoProvider); ok {
+		return &cachingStorageWithConnectionInfo{s, cip}, nil
 	}
 
 	return s, nil
 }
 
-var _ blob.Storage = &cachingStorage{}
+var _ blob.StreamingStorage = &cachingStorage{}
+var _ StatsProvider = &cachingStorage{}
@@ -7,7 +7,9 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/kopia/kopia/blob"
 	"github.com/kopia/kopia/blob/logging"
 	"github.com/kopia/kopia/internal/storagetesting"
 
@@ -74,17 +76,18 @@ func TestCache(t *testing.T) {
 	// os.RemoveAll(tmpdir)
 
 	masterData := map[string][]byte{}
-	master := storagetesting.NewMapStorage(masterData)
+	master := blob.ToStreaming(storagetesting.NewMapStorage(masterData))
 
 	var tr tracer
 	master = logging.NewWrapper(master, logging.Output(tr.Printf))
 
-	cache, err := NewWrapper(context.Background(), master, &Options{CacheDir: tmpdir})
-	defer cache.Close()
+	cs, err := NewWrapper(context.Background(), master, &Options{CacheDir: tmpdir})
 	if err != nil {
 		t.Errorf("cannot create cache: %v", err)
 		return
 	}
+	cache := blob.FromStreaming(cs)
+	defer cache.Close()
 
 	data1 := []byte("foo-bar")
 	data2 := []byte("baz-qux")
@@ -145,3 +148,66 @@ func TestCache(t *testing.T) {
 	cache.Close()
 	tr.assertActivityAndClear(t, "Close")
 }
+
+func TestCacheEviction(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "kopia-cache")
+	if err != nil {
+		t.Fatalf("cannot create temp directory for testing: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	masterData := map[string][]byte{}
+	master := blob.ToStreaming(storagetesting.NewMapStorage(masterData))
+
+	cs, err := NewWrapper(context.Background(), master, &Options{
+		CacheDir:       tmpdir,
+		CacheSizeBytes: 1000,
+		ShouldCache: func(blockID string) bool {
+			return !strings.HasPrefix(blockID, "nocache")
+		},
+		EvictionInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("cannot create cache: %v", err)
+	}
+	cache := blob.FromStreaming(cs)
+	defer cache.Close()
+
+	for i := 0; i < 5; i++ {
+		masterData[fmt.Sprintf("block%v", i)] = make([]byte, 300)
+	}
+	masterData["nocache"] = make([]byte, 300)
+
+	var now int64
+	getCurrentTime = func() int64 {
+		now++
+		return now
+	}
+	defer func() { getCurrentTime = defaultGetCurrentTime }()
+
+	for i := 0; i < 5; i++ {
+		storagetesting.AssertGetBlock(t, cache, fmt.Sprintf("block%v", i), make([]byte, 300))
+	}
+	storagetesting.AssertGetBlock(t, cache, "nocache", make([]byte, 300))
+
+	c := cs.(StatsProvider)
+	if s := c.CacheStats(); s.Entries != 5 || s.SizeBytes != 1500 || s.Misses != 5 {
+		t.Errorf("unexpected cache stats before eviction: %+v", s)
+	}
+
+	// access block0 again to make it most recently used
+	storagetesting.AssertGetBlock(t, cache, "block0", make([]byte, 300))
+
+	cs.(*cachingStorage).evict(context.Background())
+
+	s := c.CacheStats()
+	if s.Entries != 3 || s.SizeBytes != 900 || s.Evictions != 2 || s.Hits != 1 {
+		t.Errorf("unexpected cache stats after eviction: %+v", s)
+	}
+
+	for _, evicted := range []string{"block1", "block2"} {
+		if _, ok := cs.(*cachingStorage).getCacheEntry(evicted); ok {
+			t.Errorf("block %v was not evicted", evicted)
+		}
+	}
+}
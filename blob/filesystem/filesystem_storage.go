@@ -30,7 +30,11 @@ type fsStorage struct {
 	Options
 }
 
-func (fs *fsStorage) BlockSize(blockID string) (int64, error) {
+func (fs *fsStorage) BlockSize(ctx context.Context, blockID string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	_, path := fs.getShardedPathAndFilePath(blockID)
 	s, err := os.Stat(path)
 	if err == nil {
@@ -44,7 +48,11 @@ func (fs *fsStorage) BlockSize(blockID string) (int64, error) {
 	return 0, err
 }
 
-func (fs *fsStorage) GetBlock(blockID string, offset, length int64) ([]byte, error) {
+func (fs *fsStorage) GetBlock(ctx context.Context, blockID string, offset, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	_, path := fs.getShardedPathAndFilePath(blockID)
 
 	f, err := os.Open(path)
@@ -56,12 +64,22 @@ func (fs *fsStorage) GetBlock(blockID string, offset, length int64) ([]byte, err
 		return nil, err
 	}
 
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
 	if length < 0 {
-		return ioutil.ReadAll(f)
+		return f, nil
 	}
 
-	f.Seek(offset, os.SEEK_SET)
-	return ioutil.ReadAll(io.LimitReader(f, length))
+	return &limitedFileReader{io.LimitReader(f, length), f}, nil
+}
+
+// limitedFileReader reads a section of a file and closes the file when done.
+type limitedFileReader struct {
+	io.Reader
+	io.Closer
 }
 
 func getstringFromFileName(name string) (string, bool) {
@@ -76,15 +94,16 @@ func makeFileName(blockID string) string {
 	return string(blockID) + fsStorageChunkSuffix
 }
 
-func (fs *fsStorage) ListBlocks(prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
+func (fs *fsStorage) ListBlocks(ctx context.Context, prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
 	result := make(chan blob.BlockMetadata)
 	cancelled := make(chan bool)
 
 	prefixString := string(prefix)
 
-	var walkDir func(string, string)
+	var walkDir func(string, string) bool
 
-	walkDir = func(directory string, currentPrefix string) {
+	// walkDir returns false when listing has been cancelled.
+	walkDir = func(directory string, currentPrefix string) bool {
 		if entries, err := ioutil.ReadDir(directory); err == nil {
 			//log.Println("Walking", directory, "looking for", prefix)
 
@@ -99,14 +118,20 @@ func (fs *fsStorage) ListBlocks(prefix string) (chan blob.BlockMetadata, blob.Ca
 						match = strings.HasPrefix(newPrefix, prefixString)
 					}
 
-					if match {
-						walkDir(directory+"/"+e.Name(), currentPrefix+e.Name())
+					if match && !walkDir(directory+"/"+e.Name(), currentPrefix+e.Name()) {
+						return false
 					}
 				} else if fullID, ok := getstringFromFileName(currentPrefix + e.Name()); ok {
 					if strings.HasPrefix(string(fullID), prefixString) {
 						select {
 						case <-cancelled:
-							return
+							return false
+						case <-ctx.Done():
+							select {
+							case result <- blob.BlockMetadata{Error: ctx.Err()}:
+							case <-cancelled:
+							}
+							return false
 						case result <- blob.BlockMetadata{
 							BlockID:   fullID,
 							Length:    e.Size(),
@@ -117,6 +142,8 @@ func (fs *fsStorage) ListBlocks(prefix string) (chan blob.BlockMetadata, blob.Ca
 				}
 			}
 		}
+
+		return true
 	}
 
 	walkDirAndClose := func(directory string) {
@@ -130,7 +157,11 @@ func (fs *fsStorage) ListBlocks(prefix string) (chan blob.BlockMetadata, blob.Ca
 	}
 }
 
-func (fs *fsStorage) PutBlock(blockID string, data []byte) error {
+func (fs *fsStorage) PutBlock(ctx context.Context, blockID string, data io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	shardPath, path := fs.getShardedPathAndFilePath(blockID)
 
 	// Open temporary file, create dir if required.
@@ -148,8 +179,15 @@ func (fs *fsStorage) PutBlock(blockID string, data []byte) error {
 		return fmt.Errorf("cannot create temporary file: %v", err)
 	}
 
-	f.Write(data)
-	f.Close()
+	_, err = io.Copy(f, contextReader{ctx, data})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("cannot write temporary file: %v", err)
+	}
 
 	err = os.Rename(tempFile, path)
 	if err != nil {
@@ -164,7 +202,25 @@ func (fs *fsStorage) PutBlock(blockID string, data []byte) error {
 	return nil
 }
 
-func (fs *fsStorage) DeleteBlock(blockID string) error {
+// contextReader wraps io.Reader and fails reads after the context has been cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr contextReader) Read(b []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return cr.r.Read(b)
+}
+
+func (fs *fsStorage) DeleteBlock(ctx context.Context, blockID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	_, path := fs.getShardedPathAndFilePath(blockID)
 	err := os.Remove(path)
 	if err == nil || os.IsNotExist(err) {
@@ -234,9 +290,11 @@ func New(ctx context.Context, opts *Options) (blob.Storage, error) {
 		Options: *opts,
 	}
 
-	return r, nil
+	return blob.FromStreaming(r), nil
 }
 
+var _ blob.StreamingStorage = &fsStorage{}
+
 func init() {
 	blob.AddSupportedStorage(
 		fsStorageType,
@@ -2,9 +2,11 @@
 package gcs
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 
 	"github.com/kopia/kopia/internal/retry"
@@ -29,7 +31,6 @@ const (
 type gcsStorage struct {
 	Options
 
-	ctx           context.Context
 	storageClient *storage.Client
 	bucket        *storage.BucketHandle
 
@@ -37,10 +38,10 @@ type gcsStorage struct {
 	uploadThrottler   *iothrottler.IOThrottlerPool
 }
 
-func (gcs *gcsStorage) BlockSize(b string) (int64, error) {
+func (gcs *gcsStorage) BlockSize(ctx context.Context, b string) (int64, error) {
 	attempt := func() (interface{}, error) {
 		oh := gcs.bucket.Object(gcs.getObjectNameString(b))
-		a, err := oh.Attrs(context.Background())
+		a, err := oh.Attrs(ctx)
 		if err != nil {
 			return 0, err
 		}
@@ -48,7 +49,7 @@ func (gcs *gcsStorage) BlockSize(b string) (int64, error) {
 		return a.Size, nil
 	}
 
-	v, err := exponentialBackoff(fmt.Sprintf("BlockSize(%q)", b), attempt)
+	v, err := exponentialBackoff(ctx, fmt.Sprintf("BlockSize(%q)", b), attempt)
 	if err != nil {
 		return 0, translateError(err)
 	}
@@ -56,28 +57,21 @@ func (gcs *gcsStorage) BlockSize(b string) (int64, error) {
 	return v.(int64), nil
 }
 
-func (gcs *gcsStorage) GetBlock(b string, offset, length int64) ([]byte, error) {
+func (gcs *gcsStorage) GetBlock(ctx context.Context, b string, offset, length int64) (io.ReadCloser, error) {
 	attempt := func() (interface{}, error) {
-		reader, err := gcs.bucket.Object(gcs.getObjectNameString(b)).NewRangeReader(gcs.ctx, offset, length)
-		if err != nil {
-			return nil, err
-		}
-		defer reader.Close()
-
-		v, err := ioutil.ReadAll(reader)
-		return v, err
+		return gcs.bucket.Object(gcs.getObjectNameString(b)).NewRangeReader(ctx, offset, length)
 	}
 
-	v, err := exponentialBackoff(fmt.Sprintf("GetBlock(%q,%v,%v)", b, offset, length), attempt)
+	v, err := exponentialBackoff(ctx, fmt.Sprintf("GetBlock(%q,%v,%v)", b, offset, length), attempt)
 	if err != nil {
 		return nil, translateError(err)
 	}
 
-	return v.([]byte), nil
+	return v.(io.ReadCloser), nil
 }
 
-func exponentialBackoff(desc string, att retry.AttemptFunc) (interface{}, error) {
-	return retry.WithExponentialBackoff(desc, att, isRetriableError)
+func exponentialBackoff(ctx context.Context, desc string, att retry.AttemptFunc) (interface{}, error) {
+	return retry.WithExponentialBackoff(ctx, desc, att, isRetriableError)
 }
 
 func isRetriableError(err error) bool {
@@ -88,6 +82,8 @@ func isRetriableError(err error) bool {
 		return false
 	case storage.ErrBucketNotExist:
 		return false
+	case context.Canceled, context.DeadlineExceeded:
+		return false
 	default:
 		return true
 	}
@@ -101,36 +97,54 @@ func translateError(err error) error {
 		return blob.ErrBlockNotFound
 	case storage.ErrBucketNotExist:
 		return blob.ErrBlockNotFound
+	case context.Canceled, context.DeadlineExceeded:
+		return err
 	default:
 		return fmt.Errorf("unexpected GCS error: %v", err)
 	}
 }
 
-func (gcs *gcsStorage) PutBlock(b string, data []byte) error {
-	attempt := func() (interface{}, error) {
-		writer := gcs.bucket.Object(gcs.getObjectNameString(b)).NewWriter(gcs.ctx)
-		n, err := writer.Write(data)
+func (gcs *gcsStorage) PutBlock(ctx context.Context, b string, data io.Reader) error {
+	// Uploads are retried from the beginning, which requires seekable input.
+	rs, ok := data.(io.ReadSeeker)
+	if !ok {
+		buf, err := ioutil.ReadAll(data)
 		if err != nil {
+			return err
+		}
+
+		rs = bytes.NewReader(buf)
+	}
+
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	attempt := func() (interface{}, error) {
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
 			return nil, err
 		}
-		if n != len(data) {
-			writer.CloseWithError(errors.New("truncated write"))
-			return nil, fmt.Errorf("truncated write %v of %v bytes", n, len(data))
+
+		writer := gcs.bucket.Object(gcs.getObjectNameString(b)).NewWriter(ctx)
+		if _, err := io.Copy(writer, rs); err != nil {
+			writer.CloseWithError(err)
+			return nil, err
 		}
 
 		return nil, writer.Close()
 	}
 
-	_, err := exponentialBackoff(fmt.Sprintf("PutBlock(%q)", b), attempt)
+	_, err = exponentialBackoff(ctx, fmt.Sprintf("PutBlock(%q)", b), attempt)
 	return translateError(err)
 }
 
-func (gcs *gcsStorage) DeleteBlock(b string) error {
+func (gcs *gcsStorage) DeleteBlock(ctx context.Context, b string) error {
 	attempt := func() (interface{}, error) {
-		return nil, gcs.bucket.Object(gcs.getObjectNameString(b)).Delete(gcs.ctx)
+		return nil, gcs.bucket.Object(gcs.getObjectNameString(b)).Delete(ctx)
 	}
 
-	_, err := exponentialBackoff(fmt.Sprintf("DeleteBlock(%q)", b), attempt)
+	_, err := exponentialBackoff(ctx, fmt.Sprintf("DeleteBlock(%q)", b), attempt)
 	return translateError(err)
 }
 
@@ -138,14 +152,14 @@ func (gcs *gcsStorage) getObjectNameString(b string) string {
 	return gcs.Prefix + string(b)
 }
 
-func (gcs *gcsStorage) ListBlocks(prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
+func (gcs *gcsStorage) ListBlocks(ctx context.Context, prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
 	ch := make(chan blob.BlockMetadata, 100)
 	cancelled := make(chan bool)
 
 	go func() {
 		defer close(ch)
 
-		lst := gcs.bucket.Objects(gcs.ctx, &storage.Query{
+		lst := gcs.bucket.Objects(ctx, &storage.Query{
 			Prefix: gcs.getObjectNameString(prefix),
 		})
 
@@ -262,14 +276,13 @@ func New(ctx context.Context, opt *Options) (blob.Storage, error) {
 		return nil, errors.New("bucket name must be specified")
 	}
 
-	return &gcsStorage{
+	return blob.FromStreaming(&gcsStorage{
 		Options:           *opt,
-		ctx:               ctx,
 		storageClient:     cli,
 		bucket:            cli.Bucket(opt.BucketName),
 		downloadThrottler: downloadThrottler,
 		uploadThrottler:   uploadThrottler,
-	}, nil
+	}), nil
 }
 
 func init() {
@@ -283,4 +296,5 @@ func init() {
 		})
 }
 
+var _ blob.StreamingStorage = &gcsStorage{}
 var _ blob.ConnectionInfoProvider = &gcsStorage{}
@@ -2,6 +2,8 @@
 package logging
 
 import (
+	"context"
+	"io"
 	"log"
 	"time"
 
@@ -9,50 +11,47 @@ import (
 )
 
 type loggingStorage struct {
-	base   blob.Storage
+	base   blob.StreamingStorage
 	printf func(string, ...interface{})
 	prefix string
 }
 
-func (s *loggingStorage) BlockSize(id string) (int64, error) {
+func (s *loggingStorage) BlockSize(ctx context.Context, id string) (int64, error) {
 	t0 := time.Now()
-	result, err := s.base.BlockSize(id)
+	result, err := s.base.BlockSize(ctx, id)
 	dt := time.Since(t0)
 	s.printf(s.prefix+"BlockSize(%q)=%#v,%#v took %v", id, result, err, dt)
 	return result, err
 }
 
-func (s *loggingStorage) GetBlock(id string, offset, length int64) ([]byte, error) {
+func (s *loggingStorage) GetBlock(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error) {
 	t0 := time.Now()
-	result, err := s.base.GetBlock(id, offset, length)
+	result, err := s.base.GetBlock(ctx, id, offset, length)
 	dt := time.Since(t0)
-	if len(result) < 20 {
-		s.printf(s.prefix+"GetBlock(%q,%v,%v)=(%#v, %#v) took %v", id, offset, length, result, err, dt)
-	} else {
-		s.printf(s.prefix+"GetBlock(%q,%v,%v)=({%#v bytes}, %#v) took %v", id, offset, length, len(result), err, dt)
-	}
+	s.printf(s.prefix+"GetBlock(%q,%v,%v)=%#v took %v", id, offset, length, err, dt)
 	return result, err
 }
 
-func (s *loggingStorage) PutBlock(id string, data []byte) error {
+func (s *loggingStorage) PutBlock(ctx context.Context, id string, data io.Reader) error {
 	t0 := time.Now()
-	err := s.base.PutBlock(id, data)
+	cr := &countingReader{Reader: data}
+	err := s.base.PutBlock(ctx, id, cr)
 	dt := time.Since(t0)
-	s.printf(s.prefix+"PutBlock(%q, len=%v)=%#v took %v", id, len(data), err, dt)
+	s.printf(s.prefix+"PutBlock(%q, len=%v)=%#v took %v", id, cr.n, err, dt)
 	return err
 }
 
-func (s *loggingStorage) DeleteBlock(id string) error {
+func (s *loggingStorage) DeleteBlock(ctx context.Context, id string) error {
 	t0 := time.Now()
-	err := s.base.DeleteBlock(id)
+	err := s.base.DeleteBlock(ctx, id)
 	dt := time.Since(t0)
 	s.printf(s.prefix+"DeleteBlock(%q)=%#v took %v", id, err, dt)
 	return err
 }
 
-func (s *loggingStorage) ListBlocks(prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
+func (s *loggingStorage) ListBlocks(ctx context.Context, prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
 	t0 := time.Now()
-	ch, cf := s.base.ListBlocks(prefix)
+	ch, cf := s.base.ListBlocks(ctx, prefix)
 	s.printf(s.prefix+"ListBlocks(%q) took %v", prefix, time.Since(t0))
 	return ch, func() {
 		s.printf(s.prefix+"Cancelled ListBlocks(%q)after %v", prefix, time.Since(t0))
@@ -68,11 +67,23 @@ func (s *loggingStorage) Close() error {
 	return err
 }
 
+// countingReader counts the number of bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.n += int64(n)
+	return n, err
+}
+
 // Option modifies the behavior of logging storage wrapper.
 type Option func(s *loggingStorage)
 
 // NewWrapper returns a Storage wrapper that logs all storage commands.
-func NewWrapper(wrapped blob.Storage, options ...Option) blob.Storage {
+func NewWrapper(wrapped blob.StreamingStorage, options ...Option) blob.StreamingStorage {
 	s := &loggingStorage{base: wrapped, printf: log.Printf}
 	for _, o := range options {
 		o(s)
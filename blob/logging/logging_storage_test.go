@@ -3,14 +3,15 @@ package logging
 import (
 	"testing"
 
+	"github.com/kopia/kopia/blob"
 	"github.com/kopia/kopia/internal/storagetesting"
 )
 
 func TestLoggingStorage(t *testing.T) {
 	data := map[string][]byte{}
-	r := NewWrapper(storagetesting.NewMapStorage(data))
+	r := NewWrapper(blob.ToStreaming(storagetesting.NewMapStorage(data)))
 	if r == nil {
 		t.Errorf("unexpected result: %v", r)
 	}
-	storagetesting.VerifyStorage(t, r)
+	storagetesting.VerifyStorage(t, blob.FromStreaming(r))
 }
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are upper bounds (in seconds) of latency histogram buckets.
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// OperationMetrics holds metrics of a single type of storage operation.
+type OperationMetrics struct {
+	// Keep int64 fields first to ensure they get aligned to at least 64-bit boundaries
+	// which is required for atomic access on ARM and x86-32.
+	Count           int64
+	Errors          int64
+	Bytes           int64
+	LatencyNanos    int64
+	latencyOverflow int64
+
+	LatencyBuckets []int64 // number of operations with latency <= latencyBuckets[i], not cumulative
+}
+
+func newOperationMetrics() *OperationMetrics {
+	return &OperationMetrics{
+		LatencyBuckets: make([]int64, len(latencyBuckets)),
+	}
+}
+
+func (om *OperationMetrics) record(dt time.Duration, bytes int64, err error) {
+	atomic.AddInt64(&om.Count, 1)
+	atomic.AddInt64(&om.Bytes, bytes)
+	atomic.AddInt64(&om.LatencyNanos, int64(dt))
+	if err != nil {
+		atomic.AddInt64(&om.Errors, 1)
+	}
+
+	seconds := dt.Seconds()
+	for i, b := range latencyBuckets {
+		if seconds <= b {
+			atomic.AddInt64(&om.LatencyBuckets[i], 1)
+			return
+		}
+	}
+
+	atomic.AddInt64(&om.latencyOverflow, 1)
+}
+
+// Collector accumulates metrics of storage operations.
+type Collector struct {
+	mu         sync.Mutex
+	operations map[string]*OperationMetrics
+}
+
+func (c *Collector) operation(name string) *OperationMetrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	om := c.operations[name]
+	if om == nil {
+		om = newOperationMetrics()
+		c.operations[name] = om
+	}
+
+	return om
+}
+
+func (c *Collector) record(op string, t0 time.Time, bytes int64, err error) {
+	c.operation(op).record(time.Since(t0), bytes, err)
+}
+
+// Operations returns the names of operations that have been recorded, in sorted order.
+func (c *Collector) Operations() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var result []string
+	for k := range c.operations {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Operation returns a snapshot of metrics for the specified operation.
+func (c *Collector) Operation(name string) OperationMetrics {
+	om := c.operation(name)
+	result := OperationMetrics{
+		Count:           atomic.LoadInt64(&om.Count),
+		Errors:          atomic.LoadInt64(&om.Errors),
+		Bytes:           atomic.LoadInt64(&om.Bytes),
+		LatencyNanos:    atomic.LoadInt64(&om.LatencyNanos),
+		LatencyBuckets:  make([]int64, len(om.LatencyBuckets)),
+		latencyOverflow: atomic.LoadInt64(&om.latencyOverflow),
+	}
+
+	for i := range om.LatencyBuckets {
+		result.LatencyBuckets[i] = atomic.LoadInt64(&om.LatencyBuckets[i])
+	}
+
+	return result
+}
+
+// WritePrometheus writes all metrics in Prometheus text exposition format using the provided metric name prefix.
+func (c *Collector) WritePrometheus(w io.Writer, prefix string) {
+	ops := c.Operations()
+
+	fmt.Fprintf(w, "# HELP %v_operations_total Number of storage operations.\n", prefix)
+	fmt.Fprintf(w, "# TYPE %v_operations_total counter\n", prefix)
+	for _, op := range ops {
+		fmt.Fprintf(w, "%v_operations_total{operation=%q} %v\n", prefix, op, c.Operation(op).Count)
+	}
+
+	fmt.Fprintf(w, "# HELP %v_errors_total Number of failed storage operations.\n", prefix)
+	fmt.Fprintf(w, "# TYPE %v_errors_total counter\n", prefix)
+	for _, op := range ops {
+		fmt.Fprintf(w, "%v_errors_total{operation=%q} %v\n", prefix, op, c.Operation(op).Errors)
+	}
+
+	fmt.Fprintf(w, "# HELP %v_bytes_total Number of bytes transferred by storage operations.\n", prefix)
+	fmt.Fprintf(w, "# TYPE %v_bytes_total counter\n", prefix)
+	for _, op := range ops {
+		fmt.Fprintf(w, "%v_bytes_total{operation=%q} %v\n", prefix, op, c.Operation(op).Bytes)
+	}
+
+	fmt.Fprintf(w, "# HELP %v_latency_seconds Latency of storage operations.\n", prefix)
+	fmt.Fprintf(w, "# TYPE %v_latency_seconds histogram\n", prefix)
+	for _, op := range ops {
+		om := c.Operation(op)
+
+		var cumulative int64
+		for i, b := range latencyBuckets {
+			cumulative += om.LatencyBuckets[i]
+			fmt.Fprintf(w, "%v_latency_seconds_bucket{operation=%q,le=\"%v\"} %v\n", prefix, op, b, cumulative)
+		}
+		count := cumulative + om.latencyOverflow
+		fmt.Fprintf(w, "%v_latency_seconds_bucket{operation=%q,le=\"+Inf\"} %v\n", prefix, op, count)
+		fmt.Fprintf(w, "%v_latency_seconds_sum{operation=%q} %v\n", prefix, op, time.Duration(om.LatencyNanos).Seconds())
+		fmt.Fprintf(w, "%v_latency_seconds_count{operation=%q} %v\n", prefix, op, count)
+	}
+}
+
+// NewCollector creates a new metrics Collector.
+func NewCollector() *Collector {
+	return &Collector{
+		operations: map[string]*OperationMetrics{},
+	}
+}
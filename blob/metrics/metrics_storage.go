@@ -0,0 +1,148 @@
+// Package metrics implements wrapper around Storage that collects operation metrics.
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/kopia/kopia/blob"
+)
+
+type metricsStorage struct {
+	base blob.StreamingStorage
+	c    *Collector
+}
+
+// metricsStorageWithConnectionInfo is returned when the wrapped storage can persist its connection info.
+type metricsStorageWithConnectionInfo struct {
+	*metricsStorage
+	blob.ConnectionInfoProvider
+}
+
+func (s *metricsStorage) BlockSize(ctx context.Context, id string) (int64, error) {
+	t0 := time.Now()
+	result, err := s.base.BlockSize(ctx, id)
+	s.c.record("BlockSize", t0, 0, ignoreNotFound(err))
+	return result, err
+}
+
+// GetBlock records the latency of the entire transfer, which completes when the returned reader is closed.
+func (s *metricsStorage) GetBlock(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error) {
+	t0 := time.Now()
+	r, err := s.base.GetBlock(ctx, id, offset, length)
+	if err != nil {
+		s.c.record("GetBlock", t0, 0, ignoreNotFound(err))
+		return nil, err
+	}
+
+	return &countingReadCloser{ReadCloser: r, c: s.c, t0: t0}, nil
+}
+
+func (s *metricsStorage) PutBlock(ctx context.Context, id string, data io.Reader) error {
+	t0 := time.Now()
+	cr := &countingReader{Reader: data}
+	err := s.base.PutBlock(ctx, id, cr)
+	s.c.record("PutBlock", t0, cr.n, err)
+	return err
+}
+
+func (s *metricsStorage) DeleteBlock(ctx context.Context, id string) error {
+	t0 := time.Now()
+	err := s.base.DeleteBlock(ctx, id)
+	s.c.record("DeleteBlock", t0, 0, err)
+	return err
+}
+
+// ListBlocks records the latency of the entire listing, which completes when the returned channel is closed.
+func (s *metricsStorage) ListBlocks(ctx context.Context, prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
+	t0 := time.Now()
+	ch, cancel := s.base.ListBlocks(ctx, prefix)
+	result := make(chan blob.BlockMetadata)
+
+	go func() {
+		defer close(result)
+
+		var err error
+		for bm := range ch {
+			if bm.Error != nil {
+				err = bm.Error
+			}
+			result <- bm
+		}
+
+		s.c.record("ListBlocks", t0, 0, err)
+	}()
+
+	return result, func() {
+		cancel()
+
+		// drain remaining results so that the forwarding goroutine can exit
+		go func() {
+			for range result {
+			}
+		}()
+	}
+}
+
+func (s *metricsStorage) Close() error {
+	return s.base.Close()
+}
+
+func ignoreNotFound(err error) error {
+	if err == blob.ErrBlockNotFound {
+		return nil
+	}
+
+	return err
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.n += int64(n)
+	return n, err
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	c      *Collector
+	t0     time.Time
+	n      int64
+	err    error
+	closed bool
+}
+
+func (r *countingReadCloser) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.n += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *countingReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	if !r.closed {
+		r.closed = true
+		r.c.record("GetBlock", r.t0, r.n, r.err)
+	}
+	return err
+}
+
+// NewWrapper returns a Storage wrapper that records metrics of all storage operations in the provided Collector.
+func NewWrapper(wrapped blob.StreamingStorage, c *Collector) blob.StreamingStorage {
+	s := &metricsStorage{base: wrapped, c: c}
+	if cip, ok := wrapped.(blob.ConnectionInfoProvider); ok {
+		return &metricsStorageWithConnectionInfo{s, cip}
+	}
+
+	return s
+}
+
+var _ blob.StreamingStorage = &metricsStorage{}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kopia/kopia/blob"
+	"github.com/kopia/kopia/internal/storagetesting"
+)
+
+func TestMetricsStorage(t *testing.T) {
+	data := map[string][]byte{}
+	c := NewCollector()
+	r := blob.FromStreaming(NewWrapper(blob.ToStreaming(storagetesting.NewMapStorage(data)), c))
+
+	storagetesting.VerifyStorage(t, r)
+
+	before := c.Operation("PutBlock")
+	if err := r.PutBlock("foo", []byte{1, 2, 3}); err != nil {
+		t.Fatalf("PutBlock() failed: %v", err)
+	}
+	storagetesting.AssertGetBlock(t, r, "foo", []byte{1, 2, 3})
+	storagetesting.AssertGetBlockNotFound(t, r, "no-such-block")
+	if err := r.DeleteBlock("foo"); err != nil {
+		t.Fatalf("DeleteBlock() failed: %v", err)
+	}
+
+	if got, want := c.Operations(), []string{"BlockSize", "DeleteBlock", "GetBlock", "ListBlocks", "PutBlock"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("unexpected operations: %v, want %v", got, want)
+	}
+
+	put := c.Operation("PutBlock")
+	if put.Count != before.Count+1 || put.Bytes != before.Bytes+3 {
+		t.Errorf("unexpected PutBlock metrics: %+v (before %+v)", put, before)
+	}
+
+	if get := c.Operation("GetBlock"); get.Errors != 0 {
+		t.Errorf("block not found should not be counted as an error: %+v", get)
+	}
+
+	var buf bytes.Buffer
+	c.WritePrometheus(&buf, "test")
+	for _, want := range []string{
+		"# TYPE test_operations_total counter\n",
+		"test_bytes_total{operation=\"PutBlock\"} ",
+		"test_latency_seconds_bucket{operation=\"GetBlock\",le=\"+Inf\"} ",
+		"test_latency_seconds_count{operation=\"ListBlocks\"} ",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics output does not contain %q:\n%v", want, buf.String())
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package blob
 
 import (
+	"context"
 	"io"
 	"time"
 )
@@ -8,7 +9,10 @@ import (
 // CancelFunc requests cancellation of a storage operation.
 type CancelFunc func()
 
-// Storage encapsulates API for connecting to blob storage
+// Storage encapsulates API for connecting to blob storage.
+//
+// Storage is kept for compatibility with existing callers, new code should use StreamingStorage
+// which supports cancellation and does not require whole blocks to be held in memory.
 type Storage interface {
 	io.Closer
 
@@ -19,6 +23,20 @@ type Storage interface {
 	ListBlocks(prefix string) (chan (BlockMetadata), CancelFunc)
 }
 
+// StreamingStorage is a context-aware version of Storage, which transfers block contents as streams.
+//
+// GetBlock() with negative length returns the remainder of the block starting at the given offset.
+// The caller must close the reader returned by GetBlock().
+type StreamingStorage interface {
+	io.Closer
+
+	BlockSize(ctx context.Context, id string) (int64, error)
+	PutBlock(ctx context.Context, id string, data io.Reader) error
+	DeleteBlock(ctx context.Context, id string) error
+	GetBlock(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error)
+	ListBlocks(ctx context.Context, prefix string) (chan (BlockMetadata), CancelFunc)
+}
+
 // ConnectionInfoProvider exposes persistent ConnectionInfo for connecting to the Storage.
 type ConnectionInfoProvider interface {
 	ConnectionInfo() ConnectionInfo
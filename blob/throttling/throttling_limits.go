@@ -0,0 +1,112 @@
+package throttling
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits specifies maximum upload and download speeds, optionally varying by time of day.
+// Zero or negative speed means unlimited.
+type Limits struct {
+	MaxUploadSpeedBytesPerSecond   int `json:"maxUploadSpeedBytesPerSecond,omitempty"`
+	MaxDownloadSpeedBytesPerSecond int `json:"maxDownloadSpeedBytesPerSecond,omitempty"`
+
+	// Schedule overrides the limits above during specified times of day.
+	// When time windows overlap, the first matching entry wins.
+	Schedule []ScheduledLimits `json:"schedule,omitempty"`
+}
+
+// ScheduledLimits specifies upload and download speeds applicable during a time window.
+// Start and End are local times of day in HH:MM format. Windows where End is before Start
+// span midnight.
+type ScheduledLimits struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	MaxUploadSpeedBytesPerSecond   int `json:"maxUploadSpeedBytesPerSecond,omitempty"`
+	MaxDownloadSpeedBytesPerSecond int `json:"maxDownloadSpeedBytesPerSecond,omitempty"`
+}
+
+// String returns the representation of scheduled limits accepted by ParseScheduledLimits().
+func (sl ScheduledLimits) String() string {
+	return fmt.Sprintf("%v-%v=%v/%v", sl.Start, sl.End, sl.MaxDownloadSpeedBytesPerSecond, sl.MaxUploadSpeedBytesPerSecond)
+}
+
+// ParseScheduledLimits parses scheduled limits in the format 'HH:MM-HH:MM=DOWNLOAD/UPLOAD', where
+// DOWNLOAD and UPLOAD are speeds in bytes per second (0 means unlimited).
+func ParseScheduledLimits(s string) (ScheduledLimits, error) {
+	var sl ScheduledLimits
+
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return sl, fmt.Errorf("invalid throttling schedule %q, expected HH:MM-HH:MM=DOWNLOAD/UPLOAD", s)
+	}
+
+	window := strings.SplitN(parts[0], "-", 2)
+	if len(window) != 2 {
+		return sl, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", parts[0])
+	}
+
+	speeds := strings.SplitN(parts[1], "/", 2)
+	if len(speeds) != 2 {
+		return sl, fmt.Errorf("invalid speeds %q, expected DOWNLOAD/UPLOAD", parts[1])
+	}
+
+	sl.Start = strings.TrimSpace(window[0])
+	sl.End = strings.TrimSpace(window[1])
+
+	var err error
+	if sl.MaxDownloadSpeedBytesPerSecond, err = strconv.Atoi(strings.TrimSpace(speeds[0])); err != nil {
+		return sl, fmt.Errorf("invalid download speed %q: %v", speeds[0], err)
+	}
+
+	if sl.MaxUploadSpeedBytesPerSecond, err = strconv.Atoi(strings.TrimSpace(speeds[1])); err != nil {
+		return sl, fmt.Errorf("invalid upload speed %q: %v", speeds[1], err)
+	}
+
+	if _, err := sl.window(); err != nil {
+		return sl, err
+	}
+
+	return sl, nil
+}
+
+// timeWindow represents a time window as minutes since midnight.
+type timeWindow struct {
+	start, end int
+}
+
+func (w timeWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return m >= w.start && m < w.end
+	}
+
+	// window spans midnight
+	return m >= w.start || m < w.end
+}
+
+func (sl ScheduledLimits) window() (timeWindow, error) {
+	start, err := parseTimeOfDay(sl.Start)
+	if err != nil {
+		return timeWindow{}, err
+	}
+
+	end, err := parseTimeOfDay(sl.End)
+	if err != nil {
+		return timeWindow{}, err
+	}
+
+	return timeWindow{start, end}, nil
+}
+
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,176 @@
+// Package throttling implements wrapper around Storage that limits upload and download speeds.
+package throttling
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/kopia/kopia/blob"
+)
+
+// maxChunkSize is the maximum number of bytes transferred between throttling decisions,
+// so that limits are enforced smoothly and schedule changes take effect quickly.
+const maxChunkSize = 32 * 1024
+
+type scheduleEntry struct {
+	window timeWindow
+	ScheduledLimits
+}
+
+type throttlingStorage struct {
+	base blob.StreamingStorage
+
+	limits   Limits
+	schedule []scheduleEntry
+	now      func() time.Time
+
+	downloads limiter
+	uploads   limiter
+}
+
+// throttlingStorageWithConnectionInfo is returned when the wrapped storage can persist its connection info.
+type throttlingStorageWithConnectionInfo struct {
+	*throttlingStorage
+	blob.ConnectionInfoProvider
+}
+
+func (s *throttlingStorage) BlockSize(ctx context.Context, id string) (int64, error) {
+	return s.base.BlockSize(ctx, id)
+}
+
+func (s *throttlingStorage) GetBlock(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error) {
+	r, err := s.base.GetBlock(ctx, id, offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	return &throttledReadCloser{
+		throttledReader{ctx, r, &s.downloads, s.downloadSpeed},
+		r,
+	}, nil
+}
+
+func (s *throttlingStorage) PutBlock(ctx context.Context, id string, data io.Reader) error {
+	return s.base.PutBlock(ctx, id, &throttledReader{ctx, data, &s.uploads, s.uploadSpeed})
+}
+
+func (s *throttlingStorage) DeleteBlock(ctx context.Context, id string) error {
+	return s.base.DeleteBlock(ctx, id)
+}
+
+func (s *throttlingStorage) ListBlocks(ctx context.Context, prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
+	return s.base.ListBlocks(ctx, prefix)
+}
+
+func (s *throttlingStorage) Close() error {
+	return s.base.Close()
+}
+
+// currentLimits returns the limits applicable at the current time of day.
+func (s *throttlingStorage) currentLimits() (download, upload int) {
+	now := s.now()
+	for _, e := range s.schedule {
+		if e.window.contains(now) {
+			return e.MaxDownloadSpeedBytesPerSecond, e.MaxUploadSpeedBytesPerSecond
+		}
+	}
+
+	return s.limits.MaxDownloadSpeedBytesPerSecond, s.limits.MaxUploadSpeedBytesPerSecond
+}
+
+func (s *throttlingStorage) downloadSpeed() int {
+	d, _ := s.currentLimits()
+	return d
+}
+
+func (s *throttlingStorage) uploadSpeed() int {
+	_, u := s.currentLimits()
+	return u
+}
+
+// limiter enforces the maximum aggregate transfer speed of all concurrent transfers in one direction.
+type limiter struct {
+	mu   sync.Mutex
+	next time.Time // time at which the next transfer may proceed
+}
+
+// wait blocks until it is permissible to transfer n more bytes at the specified speed.
+func (l *limiter) wait(ctx context.Context, n int, bytesPerSecond int) error {
+	if bytesPerSecond <= 0 || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(bytesPerSecond))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+type throttledReader struct {
+	ctx   context.Context
+	r     io.Reader
+	lim   *limiter
+	speed func() int
+}
+
+func (tr *throttledReader) Read(b []byte) (int, error) {
+	speed := tr.speed()
+	if speed > 0 && len(b) > maxChunkSize {
+		b = b[0:maxChunkSize]
+	}
+
+	n, err := tr.r.Read(b)
+	if werr := tr.lim.wait(tr.ctx, n, speed); werr != nil {
+		return n, werr
+	}
+
+	return n, err
+}
+
+type throttledReadCloser struct {
+	throttledReader
+	io.Closer
+}
+
+// NewWrapper returns a Storage wrapper that limits upload and download speeds according to the provided limits.
+func NewWrapper(wrapped blob.StreamingStorage, limits Limits) (blob.StreamingStorage, error) {
+	s := &throttlingStorage{
+		base:   wrapped,
+		limits: limits,
+		now:    time.Now,
+	}
+
+	for _, sl := range limits.Schedule {
+		w, err := sl.window()
+		if err != nil {
+			return nil, err
+		}
+
+		s.schedule = append(s.schedule, scheduleEntry{w, sl})
+	}
+
+	if cip, ok := wrapped.(blob.ConnectionInfoProvider); ok {
+		return &throttlingStorageWithConnectionInfo{s, cip}, nil
+	}
+
+	return s, nil
+}
+
+var _ blob.StreamingStorage = &throttlingStorage{}
@@ -0,0 +1,115 @@
+package throttling
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/blob"
+	"github.com/kopia/kopia/internal/storagetesting"
+)
+
+func TestThrottlingStorage(t *testing.T) {
+	data := map[string][]byte{}
+	r, err := NewWrapper(blob.ToStreaming(storagetesting.NewMapStorage(data)), Limits{
+		MaxDownloadSpeedBytesPerSecond: 1000000,
+		MaxUploadSpeedBytesPerSecond:   1000000,
+	})
+	if err != nil {
+		t.Fatalf("unable to create wrapper: %v", err)
+	}
+
+	storagetesting.VerifyStorage(t, blob.FromStreaming(r))
+}
+
+func TestThrottlingSpeed(t *testing.T) {
+	data := map[string][]byte{}
+	r, err := NewWrapper(blob.ToStreaming(storagetesting.NewMapStorage(data)), Limits{
+		MaxDownloadSpeedBytesPerSecond: 200000,
+	})
+	if err != nil {
+		t.Fatalf("unable to create wrapper: %v", err)
+	}
+
+	ctx := context.Background()
+	block := make([]byte, 100000)
+
+	t0 := time.Now()
+	if err := r.PutBlock(ctx, "x", bytes.NewReader(block)); err != nil {
+		t.Fatalf("PutBlock() failed: %v", err)
+	}
+	if dt := time.Since(t0); dt > 200*time.Millisecond {
+		t.Errorf("unlimited upload took too long: %v", dt)
+	}
+
+	t0 = time.Now()
+	rc, err := r.GetBlock(ctx, "x", 0, -1)
+	if err != nil {
+		t.Fatalf("GetBlock() failed: %v", err)
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(b, block) {
+		t.Fatalf("invalid block contents: %v", err)
+	}
+
+	// 100000 bytes at 200000 bytes/sec, the first chunk is not delayed.
+	if dt := time.Since(t0); dt < 300*time.Millisecond {
+		t.Errorf("throttled download was too fast: %v", dt)
+	}
+}
+
+func TestThrottlingSchedule(t *testing.T) {
+	var sched []ScheduledLimits
+	for _, s := range []string{"09:00-17:00=1000/2000", "22:00-06:00=0/5"} {
+		sl, err := ParseScheduledLimits(s)
+		if err != nil {
+			t.Fatalf("unable to parse %q: %v", s, err)
+		}
+		if sl.String() != s {
+			t.Errorf("invalid string representation %q, expected %q", sl.String(), s)
+		}
+		sched = append(sched, sl)
+	}
+
+	w, err := NewWrapper(blob.ToStreaming(storagetesting.NewMapStorage(map[string][]byte{})), Limits{
+		MaxDownloadSpeedBytesPerSecond: 10,
+		MaxUploadSpeedBytesPerSecond:   20,
+		Schedule:                       sched,
+	})
+	if err != nil {
+		t.Fatalf("unable to create wrapper: %v", err)
+	}
+
+	s := w.(*throttlingStorage)
+	cases := []struct {
+		hour, minute     int
+		download, upload int
+	}{
+		{8, 59, 10, 20},
+		{9, 0, 1000, 2000},
+		{16, 59, 1000, 2000},
+		{17, 0, 10, 20},
+		{23, 30, 0, 5},
+		{3, 0, 0, 5},
+		{6, 0, 10, 20},
+	}
+
+	for _, c := range cases {
+		s.now = func() time.Time {
+			return time.Date(2017, 1, 1, c.hour, c.minute, 0, 0, time.Local)
+		}
+
+		if d, u := s.currentLimits(); d != c.download || u != c.upload {
+			t.Errorf("invalid limits at %02v:%02v: %v/%v, expected %v/%v", c.hour, c.minute, d, u, c.download, c.upload)
+		}
+	}
+
+	for _, s := range []string{"", "09:00-17:00", "9-17=1/2", "09:00-17:00=1", "09:00-25:00=1/2", "09:00-17:00=a/2"} {
+		if _, err := ParseScheduledLimits(s); err == nil {
+			t.Errorf("unexpected success parsing %q", s)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package webdav
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"net/http"
@@ -58,13 +59,13 @@ type webdavDirEntry struct {
 	isCollection bool
 }
 
-func (d *davStorage) propFindChildren(urlStr string) ([]webdavDirEntry, error) {
+func (d *davStorage) propFindChildren(ctx context.Context, urlStr string) ([]webdavDirEntry, error) {
 	req, err := d.propFindRequest(urlStr, "1")
 	if err != nil {
 		return nil, fmt.Errorf("can't create PROPFIND request: %v", err)
 	}
 
-	resp, err := d.executeRequest(req, blockInfoRequest)
+	resp, err := d.executeRequest(ctx, req, blockInfoRequest)
 	if err != nil {
 		return nil, fmt.Errorf("unable to execute webdav request: %v", err)
 	}
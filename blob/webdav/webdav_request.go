@@ -2,6 +2,7 @@ package webdav
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/rand"
 	"encoding/hex"
@@ -23,8 +24,9 @@ func (e *retriableError) Error() string {
 	return fmt.Sprintf("retriable: %v", e.inner)
 }
 
-func (d *davStorage) executeRequest(req *http.Request, body []byte) (*http.Response, error) {
-	v, err := retry.WithExponentialBackoff(fmt.Sprintf("%v %v", req.Method, req.URL.RequestURI()), func() (interface{}, error) {
+func (d *davStorage) executeRequest(ctx context.Context, req *http.Request, body []byte) (*http.Response, error) {
+	req = req.WithContext(ctx)
+	v, err := retry.WithExponentialBackoff(ctx, fmt.Sprintf("%v %v", req.Method, req.URL.RequestURI()), func() (interface{}, error) {
 		resp, err := d.executeRequestInternal(req, body)
 		if err != nil {
 			// Failed to receive response.
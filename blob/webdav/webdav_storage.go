@@ -4,9 +4,9 @@ package webdav
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 
@@ -22,7 +22,7 @@ var (
 	fsDefaultShards = []int{3, 3}
 )
 
-// davStorage implements blob.Storage on top of remove WebDAV repository.
+// davStorage implements blob.StreamingStorage on top of remove WebDAV repository.
 // It is very similar to File storage, except uses HTTP URLs instead of local files.
 // Storage formats are compatible (both use sharded directory structure), so a repository
 // may be accessed using WebDAV or File interchangeably.
@@ -33,14 +33,14 @@ type davStorage struct {
 	Client *http.Client // HTTP client used when making all calls, may be overridden to use custom auth
 }
 
-func (d *davStorage) BlockSize(blockID string) (int64, error) {
+func (d *davStorage) BlockSize(ctx context.Context, blockID string) (int64, error) {
 	_, urlStr := d.getCollectionAndFileURL(blockID)
 	req, err := http.NewRequest("HEAD", urlStr, nil)
 	if err != nil {
 		return 0, err
 	}
 
-	resp, err := d.executeRequest(req, nil)
+	resp, err := d.executeRequest(ctx, req, nil)
 	if err != nil {
 		return 0, err
 	}
@@ -57,7 +57,7 @@ func (d *davStorage) BlockSize(blockID string) (int64, error) {
 	}
 }
 
-func (d *davStorage) GetBlock(blockID string, offset, length int64) ([]byte, error) {
+func (d *davStorage) GetBlock(ctx context.Context, blockID string, offset, length int64) (io.ReadCloser, error) {
 	_, urlStr := d.getCollectionAndFileURL(blockID)
 
 	req, err := http.NewRequest("GET", urlStr, nil)
@@ -67,20 +67,25 @@ func (d *davStorage) GetBlock(blockID string, offset, length int64) ([]byte, err
 
 	if length > 0 {
 		req.Header.Set("Range", fmt.Sprintf("bytes=%v-%v", offset, offset+length-1))
+	} else if length < 0 && offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
 	}
 
-	resp, err := d.executeRequest(req, blockInfoRequest)
+	resp, err := d.executeRequest(ctx, req, blockInfoRequest)
 	if err != nil {
 		return nil, err
 	}
 
-	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp.Body, nil
+	}
+
+	resp.Body.Close()
 
 	switch resp.StatusCode {
 	case http.StatusNotFound:
 		return nil, blob.ErrBlockNotFound
-	case http.StatusOK, http.StatusPartialContent:
-		return ioutil.ReadAll(resp.Body)
 	default:
 		return nil, fmt.Errorf("unsupported response code %v during GET %q", resp.StatusCode, urlStr)
 	}
@@ -98,16 +103,17 @@ func makeFileName(blockID string) string {
 	return string(blockID) + fsStorageChunkSuffix
 }
 
-func (d *davStorage) ListBlocks(prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
+func (d *davStorage) ListBlocks(ctx context.Context, prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
 	result := make(chan blob.BlockMetadata)
 	cancelled := make(chan bool)
 
 	prefixString := string(prefix)
 
-	var walkDir func(string, string)
+	var walkDir func(string, string) bool
 
-	walkDir = func(urlStr string, currentPrefix string) {
-		if entries, err := d.propFindChildren(urlStr); err == nil {
+	// walkDir returns false when listing has been cancelled.
+	walkDir = func(urlStr string, currentPrefix string) bool {
+		if entries, err := d.propFindChildren(ctx, urlStr); err == nil {
 			for _, e := range entries {
 				if e.isCollection {
 					newPrefix := currentPrefix + e.name
@@ -119,14 +125,20 @@ func (d *davStorage) ListBlocks(prefix string) (chan blob.BlockMetadata, blob.Ca
 						match = strings.HasPrefix(newPrefix, prefixString)
 					}
 
-					if match {
-						walkDir(urlStr+"/"+e.name, currentPrefix+e.name)
+					if match && !walkDir(urlStr+"/"+e.name, currentPrefix+e.name) {
+						return false
 					}
 				} else if fullID, ok := getstringFromFileName(currentPrefix + e.name); ok {
 					if strings.HasPrefix(string(fullID), prefixString) {
 						select {
 						case <-cancelled:
-							return
+							return false
+						case <-ctx.Done():
+							select {
+							case result <- blob.BlockMetadata{Error: ctx.Err()}:
+							case <-cancelled:
+							}
+							return false
 						case result <- blob.BlockMetadata{
 							BlockID:   fullID,
 							Length:    e.length,
@@ -137,6 +149,8 @@ func (d *davStorage) ListBlocks(prefix string) (chan blob.BlockMetadata, blob.Ca
 				}
 			}
 		}
+
+		return true
 	}
 
 	walkDirAndClose := func(urlStr string) {
@@ -150,8 +164,8 @@ func (d *davStorage) ListBlocks(prefix string) (chan blob.BlockMetadata, blob.Ca
 	}
 }
 
-func (d *davStorage) makeCollectionAll(urlStr string) error {
-	err := d.makeCollection(urlStr)
+func (d *davStorage) makeCollectionAll(ctx context.Context, urlStr string) error {
+	err := d.makeCollection(ctx, urlStr)
 	switch err {
 	case nil:
 		return nil
@@ -161,24 +175,24 @@ func (d *davStorage) makeCollectionAll(urlStr string) error {
 		if parent == "" {
 			return fmt.Errorf("can't create %q", urlStr)
 		}
-		if err := d.makeCollectionAll(parent); err != nil {
+		if err := d.makeCollectionAll(ctx, parent); err != nil {
 			return err
 		}
 
-		return d.makeCollection(urlStr)
+		return d.makeCollection(ctx, urlStr)
 
 	default:
 		return err
 	}
 }
 
-func (d *davStorage) makeCollection(urlStr string) error {
+func (d *davStorage) makeCollection(ctx context.Context, urlStr string) error {
 	req, err := http.NewRequest("MKCOL", urlStr, nil)
 	if err != nil {
 		return err
 	}
 
-	resp, err := d.executeRequest(req, nil)
+	resp, err := d.executeRequest(ctx, req, nil)
 	if err != nil {
 		return err
 	}
@@ -203,27 +217,27 @@ func getParentURL(u string) string {
 	return ""
 }
 
-func (d *davStorage) delete(urlStr string) error {
+func (d *davStorage) delete(ctx context.Context, urlStr string) error {
 	req, err := http.NewRequest("DELETE", urlStr, nil)
 	if err != nil {
 		return err
 	}
 
-	resp, err := d.executeRequest(req, nil)
+	resp, err := d.executeRequest(ctx, req, nil)
 	if err != nil {
 		return err
 	}
 
 	defer resp.Body.Close()
 	switch resp.StatusCode {
-	case http.StatusOK, http.StatusNotFound:
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
 		return nil
 	default:
 		return fmt.Errorf("unhandled status code %v during DELETE %q", resp.StatusCode, urlStr)
 	}
 }
 
-func (d *davStorage) move(urlOld, urlNew string) error {
+func (d *davStorage) move(ctx context.Context, urlOld, urlNew string) error {
 	req, err := http.NewRequest("MOVE", urlOld, nil)
 	if err != nil {
 		return err
@@ -231,7 +245,7 @@ func (d *davStorage) move(urlOld, urlNew string) error {
 	req.Header.Add("Destination", urlNew)
 	req.Header.Add("Overwrite", "T")
 
-	resp, err := d.executeRequest(req, nil)
+	resp, err := d.executeRequest(ctx, req, nil)
 	if err != nil {
 		return err
 	}
@@ -246,13 +260,13 @@ func (d *davStorage) move(urlOld, urlNew string) error {
 	}
 }
 
-func (d *davStorage) putBlockInternal(urlStr string, data []byte) error {
+func (d *davStorage) putBlockInternal(ctx context.Context, urlStr string, data []byte) error {
 	req, err := http.NewRequest("PUT", urlStr, nil)
 	if err != nil {
 		return err
 	}
 
-	resp, err := d.executeRequest(req, data)
+	resp, err := d.executeRequest(ctx, req, data)
 	if err != nil {
 		return err
 	}
@@ -270,40 +284,42 @@ func (d *davStorage) putBlockInternal(urlStr string, data []byte) error {
 	}
 }
 
-func (d *davStorage) PutBlock(blockID string, data []byte) error {
+func (d *davStorage) PutBlock(ctx context.Context, blockID string, r io.Reader) error {
+	// Block contents are buffered, since requests may need to be replayed after authentication
+	// challenges or retriable errors.
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
 	shardPath, url := d.getCollectionAndFileURL(blockID)
 
 	tmpURL := url + "-" + makeClientNonce()
-	err := d.putBlockInternal(tmpURL, data)
+	err = d.putBlockInternal(ctx, tmpURL, data)
 
 	if err == blob.ErrBlockNotFound {
-		if err := d.makeCollectionAll(shardPath); err != nil {
+		if err := d.makeCollectionAll(ctx, shardPath); err != nil {
 			return err
 		}
 
-		err = d.putBlockInternal(tmpURL, data)
+		err = d.putBlockInternal(ctx, tmpURL, data)
 	}
 
 	if err != nil {
 		return err
 	}
 
-	if err := d.move(tmpURL, url); err != nil {
-		d.delete(tmpURL)
+	if err := d.move(ctx, tmpURL, url); err != nil {
+		d.delete(ctx, tmpURL)
 		return err
 	}
 
 	return nil
 }
 
-func (d *davStorage) DeleteBlock(blockID string) error {
+func (d *davStorage) DeleteBlock(ctx context.Context, blockID string) error {
 	_, url := d.getCollectionAndFileURL(blockID)
-	err := os.Remove(url)
-	if err == nil || os.IsNotExist(err) {
-		return nil
-	}
-
-	return err
+	return d.delete(ctx, url)
 }
 
 func (d *davStorage) getCollectionURL(blockID string) (string, string) {
@@ -368,9 +384,11 @@ func New(ctx context.Context, opts *Options) (blob.Storage, error) {
 	}
 
 	r.Options.URL = strings.TrimSuffix(r.Options.URL, "/")
-	return r, nil
+	return blob.FromStreaming(r), nil
 }
 
+var _ blob.StreamingStorage = &davStorage{}
+
 func init() {
 	blob.AddSupportedStorage(
 		davStorageType,
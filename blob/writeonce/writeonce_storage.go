@@ -0,0 +1,142 @@
+// Package writeonce implements wrapper around Storage that prevents modification and premature deletion of blocks.
+//
+// The wrapper protects the repository against accidental destruction of existing backups by clients using it.
+// Restrictions are enforced only by clients, which choose to use the wrapper, so to be effective against
+// malicious or compromised clients they must be backed by equivalent restrictions enforced by the storage provider itself.
+package writeonce
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/kopia/kopia/blob"
+)
+
+var (
+	// ErrBlockExists is returned when attempting to overwrite a block that already exists.
+	ErrBlockExists = errors.New("block already exists in write-once storage")
+
+	// ErrDeleteNotAllowed is returned when attempting to delete a block before its minimum retention period has elapsed.
+	ErrDeleteNotAllowed = errors.New("block cannot be deleted from write-once storage before its minimum retention period")
+)
+
+// Options specifies the behavior of write-once storage.
+type Options struct {
+	// MinRetentionPeriod specifies how long blocks must be kept before they can be deleted.
+	// When zero, blocks can never be deleted.
+	MinRetentionPeriod time.Duration
+
+	// IsMutable reports whether the block holds data, which changes over time, such as metadata, and can be overwritten
+	// and deleted at any time. When nil, all blocks are immutable, which suits blocks named after hashes of their contents.
+	IsMutable func(id string) bool
+}
+
+type writeOnceStorage struct {
+	base    blob.StreamingStorage
+	options Options
+	now     func() time.Time
+}
+
+// writeOnceStorageWithConnectionInfo is returned when the wrapped storage can persist its connection info.
+type writeOnceStorageWithConnectionInfo struct {
+	*writeOnceStorage
+	blob.ConnectionInfoProvider
+}
+
+func (s *writeOnceStorage) BlockSize(ctx context.Context, id string) (int64, error) {
+	return s.base.BlockSize(ctx, id)
+}
+
+func (s *writeOnceStorage) GetBlock(ctx context.Context, id string, offset, length int64) (io.ReadCloser, error) {
+	return s.base.GetBlock(ctx, id, offset, length)
+}
+
+func (s *writeOnceStorage) isMutable(id string) bool {
+	return s.options.IsMutable != nil && s.options.IsMutable(id)
+}
+
+// PutBlock writes the block only if it does not already exist or is mutable. Note that the check is not atomic,
+// so concurrent writers of the same block may both succeed.
+func (s *writeOnceStorage) PutBlock(ctx context.Context, id string, data io.Reader) error {
+	if s.isMutable(id) {
+		return s.base.PutBlock(ctx, id, data)
+	}
+
+	switch _, err := s.base.BlockSize(ctx, id); err {
+	case nil:
+		return ErrBlockExists
+
+	case blob.ErrBlockNotFound:
+		return s.base.PutBlock(ctx, id, data)
+
+	default:
+		return err
+	}
+}
+
+// DeleteBlock deletes the block only if it's mutable or older than the minimum retention period.
+func (s *writeOnceStorage) DeleteBlock(ctx context.Context, id string) error {
+	if s.isMutable(id) {
+		return s.base.DeleteBlock(ctx, id)
+	}
+
+	if s.options.MinRetentionPeriod <= 0 {
+		return ErrDeleteNotAllowed
+	}
+
+	ts, err := s.blockTimestamp(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if s.now().Sub(ts) < s.options.MinRetentionPeriod {
+		return ErrDeleteNotAllowed
+	}
+
+	return s.base.DeleteBlock(ctx, id)
+}
+
+func (s *writeOnceStorage) blockTimestamp(ctx context.Context, id string) (time.Time, error) {
+	ch, cancel := s.base.ListBlocks(ctx, id)
+	defer cancel()
+
+	for bm := range ch {
+		if bm.Error != nil {
+			return time.Time{}, bm.Error
+		}
+
+		if bm.BlockID == id {
+			return bm.TimeStamp, nil
+		}
+	}
+
+	return time.Time{}, blob.ErrBlockNotFound
+}
+
+func (s *writeOnceStorage) ListBlocks(ctx context.Context, prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
+	return s.base.ListBlocks(ctx, prefix)
+}
+
+func (s *writeOnceStorage) Close() error {
+	return s.base.Close()
+}
+
+// NewWrapper returns a Storage wrapper that refuses to overwrite existing immutable blocks and to delete
+// immutable blocks younger than the minimum retention period.
+func NewWrapper(wrapped blob.StreamingStorage, options Options) blob.StreamingStorage {
+	s := &writeOnceStorage{
+		base:    wrapped,
+		options: options,
+		now:     time.Now,
+	}
+
+	if cip, ok := wrapped.(blob.ConnectionInfoProvider); ok {
+		return &writeOnceStorageWithConnectionInfo{s, cip}
+	}
+
+	return s
+}
+
+var _ blob.StreamingStorage = &writeOnceStorage{}
@@ -0,0 +1,99 @@
+package writeonce
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/blob"
+	"github.com/kopia/kopia/internal/storagetesting"
+)
+
+func TestWriteOnceStorage(t *testing.T) {
+	data := map[string][]byte{}
+	r := NewWrapper(blob.ToStreaming(storagetesting.NewMapStorage(data)), Options{})
+
+	storagetesting.VerifyStorage(t, blob.FromStreaming(r))
+}
+
+func TestWriteOnceOverwriteAndDelete(t *testing.T) {
+	ctx := context.Background()
+	data := map[string][]byte{}
+	r := NewWrapper(blob.ToStreaming(storagetesting.NewMapStorage(data)), Options{}).(*writeOnceStorage)
+
+	if err := r.PutBlock(ctx, "x", bytes.NewReader([]byte{1, 2, 3})); err != nil {
+		t.Fatalf("PutBlock() failed: %v", err)
+	}
+
+	if err := r.PutBlock(ctx, "x", bytes.NewReader([]byte{4, 5, 6})); err != ErrBlockExists {
+		t.Errorf("unexpected error when overwriting block: %v", err)
+	}
+
+	if err := r.DeleteBlock(ctx, "x"); err != ErrDeleteNotAllowed {
+		t.Errorf("unexpected error when deleting block: %v", err)
+	}
+
+	storagetesting.AssertGetBlock(t, blob.FromStreaming(r), "x", []byte{1, 2, 3})
+}
+
+func TestWriteOnceMutableBlocks(t *testing.T) {
+	ctx := context.Background()
+	data := map[string][]byte{}
+	r := NewWrapper(blob.ToStreaming(storagetesting.NewMapStorage(data)), Options{
+		IsMutable: func(id string) bool { return id == "m" },
+	}).(*writeOnceStorage)
+
+	for _, id := range []string{"m", "x"} {
+		if err := r.PutBlock(ctx, id, bytes.NewReader([]byte{1, 2, 3})); err != nil {
+			t.Fatalf("PutBlock() failed: %v", err)
+		}
+	}
+
+	if err := r.PutBlock(ctx, "m", bytes.NewReader([]byte{4, 5, 6})); err != nil {
+		t.Errorf("unable to overwrite mutable block: %v", err)
+	}
+
+	if err := r.PutBlock(ctx, "x", bytes.NewReader([]byte{4, 5, 6})); err != ErrBlockExists {
+		t.Errorf("unexpected error when overwriting immutable block: %v", err)
+	}
+
+	storagetesting.AssertGetBlock(t, blob.FromStreaming(r), "x", []byte{1, 2, 3})
+
+	if err := r.DeleteBlock(ctx, "m"); err != nil {
+		t.Errorf("unable to delete mutable block: %v", err)
+	}
+
+	if err := r.DeleteBlock(ctx, "x"); err != ErrDeleteNotAllowed {
+		t.Errorf("unexpected error when deleting immutable block: %v", err)
+	}
+
+	storagetesting.AssertGetBlockNotFound(t, blob.FromStreaming(r), "m")
+}
+
+func TestWriteOnceRetention(t *testing.T) {
+	ctx := context.Background()
+	data := map[string][]byte{}
+	r := NewWrapper(blob.ToStreaming(storagetesting.NewMapStorage(data)), Options{
+		MinRetentionPeriod: 24 * time.Hour,
+	}).(*writeOnceStorage)
+
+	if err := r.PutBlock(ctx, "x", bytes.NewReader([]byte{1, 2, 3})); err != nil {
+		t.Fatalf("PutBlock() failed: %v", err)
+	}
+
+	if err := r.DeleteBlock(ctx, "x"); err != ErrDeleteNotAllowed {
+		t.Errorf("unexpected error when deleting recent block: %v", err)
+	}
+
+	if err := r.DeleteBlock(ctx, "no-such-block"); err != blob.ErrBlockNotFound {
+		t.Errorf("unexpected error when deleting non-existent block: %v", err)
+	}
+
+	r.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	if err := r.DeleteBlock(ctx, "x"); err != nil {
+		t.Errorf("unable to delete block after retention period: %v", err)
+	}
+
+	storagetesting.AssertGetBlockNotFound(t, blob.FromStreaming(r), "x")
+}
@@ -15,6 +15,7 @@ var (
 	policyCommands     = app.Command("policy", "Commands to manipulate snapshotting policies.").Alias("policies")
 	metadataCommands   = app.Command("metadata", "Low-level commands to manipulate metadata items.").Alias("md")
 	objectCommands     = app.Command("object", "Commands to manipulate objects in repository.").Alias("obj")
+	benchmarkCommands  = app.Command("benchmark", "Commands to measure performance.")
 )
 
 func init() {
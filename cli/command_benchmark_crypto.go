@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	benchmarkCryptoCommand   = benchmarkCommands.Command("crypto", "Measure hashing and encryption performance of object formats.")
+	benchmarkCryptoBlockSize = benchmarkCryptoCommand.Flag("block-size", "Size of a data block.").PlaceHolder("KB").Default("1024").Int()
+	benchmarkCryptoRepeat    = benchmarkCryptoCommand.Flag("repeat", "Number of times each block is processed.").Default("100").Int()
+)
+
+func init() {
+	benchmarkCryptoCommand.Action(runBenchmarkCryptoCommand)
+}
+
+func runBenchmarkCryptoCommand(_ *kingpin.ParseContext) error {
+	res, err := repo.BenchmarkObjectFormats(*benchmarkCryptoBlockSize*1024, *benchmarkCryptoRepeat)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Hashing+res[i].Encryption > res[j].Hashing+res[j].Encryption
+	})
+
+	fmt.Printf("%-40v %14v %14v %14v\n", "OBJECT FORMAT", "HASHING", "ENCRYPTION", "DECRYPTION")
+	for _, b := range res {
+		fmt.Printf("%-40v %14v %14v %14v\n", b.ObjectFormat, bytesPerSecondString(b.Hashing), bytesPerSecondString(b.Encryption), bytesPerSecondString(b.Decryption))
+	}
+
+	return nil
+}
+
+func bytesPerSecondString(bps float64) string {
+	if bps == 0 {
+		return "-"
+	}
+
+	return units.BytesStringBase10(int64(bps)) + "/s"
+}
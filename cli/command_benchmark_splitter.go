@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	benchmarkSplitterCommand      = benchmarkCommands.Command("splitter", "Measure performance of object splitters and sizes of blocks they produce.")
+	benchmarkSplitterDataSize     = benchmarkSplitterCommand.Flag("data-size", "Amount of data to split.").PlaceHolder("MB").Default("256").Int()
+	benchmarkSplitterMinBlockSize = benchmarkSplitterCommand.Flag("min-block-size", "Minimum size of a data block.").PlaceHolder("KB").Default("1024").Int()
+	benchmarkSplitterAvgBlockSize = benchmarkSplitterCommand.Flag("avg-block-size", "Average size of a data block.").PlaceHolder("KB").Default("10240").Int()
+	benchmarkSplitterMaxBlockSize = benchmarkSplitterCommand.Flag("max-block-size", "Maximum size of a data block.").PlaceHolder("KB").Default("20480").Int()
+)
+
+func init() {
+	benchmarkSplitterCommand.Action(runBenchmarkSplitterCommand)
+}
+
+func runBenchmarkSplitterCommand(_ *kingpin.ParseContext) error {
+	res, err := repo.BenchmarkObjectSplitters(&repo.NewRepositoryOptions{
+		MinBlockSize: *benchmarkSplitterMinBlockSize * 1024,
+		AvgBlockSize: *benchmarkSplitterAvgBlockSize * 1024,
+		MaxBlockSize: *benchmarkSplitterMaxBlockSize * 1024,
+	}, *benchmarkSplitterDataSize<<20)
+	if err != nil {
+		return err
+	}
+
+	percentiles := []int{0, 10, 25, 50, 75, 90, 100}
+
+	fmt.Printf("%-10v %14v %8v", "SPLITTER", "THROUGHPUT", "BLOCKS")
+	for _, p := range percentiles {
+		fmt.Printf(" %10v", fmt.Sprintf("P%v", p))
+	}
+	fmt.Println()
+
+	for _, b := range res {
+		fmt.Printf("%-10v %14v %8v", b.Splitter, bytesPerSecondString(b.BytesPerSecond), len(b.BlockSizes))
+		for _, p := range percentiles {
+			fmt.Printf(" %10v", units.BytesStringBase2(int64(b.BlockSizePercentile(p))))
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kopia/kopia/internal/benchmark"
+	"github.com/kopia/kopia/internal/units"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	benchmarkStorageCommand     = benchmarkCommands.Command("storage", "Measure performance of a storage location.")
+	benchmarkStorageLocation    = benchmarkStorageCommand.Arg("location", "Storage location to benchmark").Required().String()
+	benchmarkStorageBlockSizes  = benchmarkStorageCommand.Flag("block-size", "Size of written blocks (can be repeated)").PlaceHolder("BYTES").Default("16384", "1048576", "4194304").Ints()
+	benchmarkStorageParallelism = benchmarkStorageCommand.Flag("parallel", "Number of concurrent operations").Default("4").Int()
+	benchmarkStorageDuration    = benchmarkStorageCommand.Flag("duration", "Duration of the benchmark").Default("30s").Duration()
+	benchmarkStorageWrites      = benchmarkStorageCommand.Flag("write-weight", "Relative frequency of writes").Default("1").Int()
+	benchmarkStorageReads       = benchmarkStorageCommand.Flag("read-weight", "Relative frequency of reads").Default("1").Int()
+	benchmarkStorageLists       = benchmarkStorageCommand.Flag("list-weight", "Relative frequency of listing blocks").Default("0").Int()
+)
+
+func init() {
+	benchmarkStorageCommand.Action(runBenchmarkStorageCommand)
+}
+
+func runBenchmarkStorageCommand(_ *kingpin.ParseContext) error {
+	ctx, cancel := context.WithCancel(getContext())
+	defer cancel()
+
+	st, err := newStorageFromURL(ctx, *benchmarkStorageLocation)
+	if err != nil {
+		return fmt.Errorf("unable to open storage: %v", err)
+	}
+	defer st.Close()
+
+	// Stop the workload on Ctrl-C, blocks written so far will still be deleted.
+	onCtrlC(cancel)
+
+	log.Printf("Benchmarking %v for %v using %v workers...", *benchmarkStorageLocation, *benchmarkStorageDuration, *benchmarkStorageParallelism)
+
+	res, err := benchmark.Storage(ctx, st, benchmark.StorageOptions{
+		BlockSizes:  *benchmarkStorageBlockSizes,
+		Parallelism: *benchmarkStorageParallelism,
+		Duration:    *benchmarkStorageDuration,
+		WriteWeight: *benchmarkStorageWrites,
+		ReadWeight:  *benchmarkStorageReads,
+		ListWeight:  *benchmarkStorageLists,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-8v %10v %8v %8v %14v %10v %10v %10v %10v\n", "OPER", "BLOCK", "COUNT", "ERRORS", "THROUGHPUT", "P50", "P90", "P99", "MAX")
+	for _, s := range res.Operations {
+		blockSize := "-"
+		if s.BlockSize > 0 {
+			blockSize = units.BytesStringBase2(int64(s.BlockSize))
+		}
+
+		throughput := "-"
+		if s.Bytes > 0 {
+			throughput = units.BytesStringBase10(int64(res.Throughput(s))) + "/s"
+		}
+
+		fmt.Printf("%-8v %10v %8v %7.1f%% %14v %10v %10v %10v %10v\n",
+			s.Operation, blockSize, s.Count, 100*s.ErrorRate(), throughput,
+			roundLatency(s.P50), roundLatency(s.P90), roundLatency(s.P99), roundLatency(s.Max))
+	}
+
+	return nil
+}
+
+func roundLatency(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Round(time.Millisecond)
+	}
+
+	return d.Round(10 * time.Microsecond)
+}
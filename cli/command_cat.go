@@ -4,6 +4,10 @@ import (
 	"io"
 	"os"
 
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/fs/repofs"
+	"github.com/kopia/kopia/repo"
+
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
@@ -16,16 +20,39 @@ func runCatCommand(context *kingpin.ParseContext) error {
 	rep := mustOpenRepository(nil)
 	defer rep.Close()
 
-	oid, err := parseObjectID(*catCommandPath, rep)
+	r, err := openCatObject(rep, *catCommandPath)
 	if err != nil {
 		return err
 	}
-	r, err := rep.Open(oid)
+	defer r.Close()
+
+	_, err = io.Copy(os.Stdout, r)
+	return err
+}
+
+// openCatObject opens the object with the specified ID, optionally followed by a path within the directory.
+// Files found by path are read through their entries, so that holes of sparse files are filled with zeros.
+func openCatObject(rep *repo.Repository, id string) (io.ReadCloser, error) {
+	head, tail := splitHeadTail(id)
+	oid, err := parseObjectID(head, rep)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if tail == "" {
+		return rep.Open(oid)
 	}
-	io.Copy(os.Stdout, r)
-	return nil
+
+	e, err := findNestedEntry(repofs.Directory(rep, oid), tail)
+	if err != nil {
+		return nil, err
+	}
+
+	if f, ok := e.(fs.File); ok {
+		return f.Open()
+	}
+
+	return rep.Open(e.(repo.HasObjectID).ObjectID())
 }
 
 func init() {
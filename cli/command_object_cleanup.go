@@ -248,7 +248,7 @@ func runCleanupCommand(context *kingpin.ParseContext) error {
 	var unreferencedBlocks int
 	var unreferencedBytes int64
 
-	blocks, cancel := rep.Storage.ListBlocks("")
+	blocks, cancel := rep.Storage.ListBlocks(getContext(), "")
 	defer cancel()
 	for b := range blocks {
 		totalBlocks++
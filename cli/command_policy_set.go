@@ -5,6 +5,8 @@ import (
 	"log"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/kopia/kopia/snapshot"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
@@ -15,8 +17,14 @@ var (
 	policySetTargets = policySetCommand.Arg("target", "Target of a policy ('global','user@host','@host') or a path").Strings()
 	policySetGlobal  = policySetCommand.Flag("global", "Set global policy").Bool()
 
-	// Frequency
-	policySetFrequency = policySetCommand.Flag("min-duration-between-backups", "Minimum duration between snapshots").Duration()
+	// Scheduling
+	policySetSnapshotInterval     = policySetCommand.Flag("snapshot-interval", "Interval between scheduled snapshots (or 'inherit')").PlaceHolder("DURATION").String()
+	policySetAddSnapshotTime      = policySetCommand.Flag("add-snapshot-time", "List of times of day to take scheduled snapshots at").PlaceHolder("HH:MM").Strings()
+	policySetRemoveSnapshotTime   = policySetCommand.Flag("remove-snapshot-time", "List of times of day to remove from the schedule").PlaceHolder("HH:MM").Strings()
+	policySetClearSnapshotTime    = policySetCommand.Flag("clear-snapshot-time", "Clear list of times of day to take scheduled snapshots at").Bool()
+	policySetAddSnapshotWindow    = policySetCommand.Flag("add-snapshot-window", "List of daily windows, in which scheduled snapshots are allowed").PlaceHolder("HH:MM-HH:MM").Strings()
+	policySetRemoveSnapshotWindow = policySetCommand.Flag("remove-snapshot-window", "List of daily windows to remove").PlaceHolder("HH:MM-HH:MM").Strings()
+	policySetClearSnapshotWindow  = policySetCommand.Flag("clear-snapshot-window", "Clear list of daily windows, in which scheduled snapshots are allowed").Bool()
 
 	// Expiration policies.
 	policySetKeepLatest  = policySetCommand.Flag("keep-latest", "Number of most recent backups to keep per source (or 'inherit')").PlaceHolder("N").String()
@@ -36,6 +44,37 @@ var (
 	policySetRemoveExclude = policySetCommand.Flag("remove-exclude", "List of paths to remove from the exclude list").PlaceHolder("PATTERN").Strings()
 	policySetClearExclude  = policySetCommand.Flag("clear-exclude", "Clear list of paths in the exclude list").Bool()
 
+	// Relative paths to exclude.
+	policySetAddExcludePath    = policySetCommand.Flag("add-exclude-path", "List of relative path patterns to add to the exclude list").PlaceHolder("PATTERN").Strings()
+	policySetRemoveExcludePath = policySetCommand.Flag("remove-exclude-path", "List of relative path patterns to remove from the exclude list").PlaceHolder("PATTERN").Strings()
+	policySetClearExcludePath  = policySetCommand.Flag("clear-exclude-path", "Clear list of relative path patterns in the exclude list").Bool()
+
+	// Directories to skip.
+	policySetAddMarkerFile    = policySetCommand.Flag("add-marker-file", "List of file names, which exclude contents of directories containing them").PlaceHolder("NAME").Strings()
+	policySetRemoveMarkerFile = policySetCommand.Flag("remove-marker-file", "List of file names to remove from the list of marker files").PlaceHolder("NAME").Strings()
+	policySetClearMarkerFile  = policySetCommand.Flag("clear-marker-file", "Clear list of marker files").Bool()
+	policySetExcludeCaches    = policySetCommand.Flag("exclude-caches", "Exclude contents of directories containing CACHEDIR.TAG (true, false or 'inherit')").PlaceHolder("BOOL").String()
+	policySetOneFileSystem    = policySetCommand.Flag("one-file-system", "Do not cross filesystem boundaries (true, false or 'inherit')").PlaceHolder("BOOL").String()
+
+	// Sizes and ages of files to exclude.
+	policySetMaxSize = policySetCommand.Flag("max-size", "Exclude files larger than the specified number of bytes (or 'inherit')").PlaceHolder("N").String()
+	policySetMinSize = policySetCommand.Flag("min-size", "Exclude files smaller than the specified number of bytes (or 'inherit')").PlaceHolder("N").String()
+	policySetMinAge  = policySetCommand.Flag("min-age", "Exclude files modified more recently than the specified duration ago (or 'inherit')").PlaceHolder("DURATION").String()
+	policySetMaxAge  = policySetCommand.Flag("max-age", "Exclude files modified earlier than the specified duration ago (or 'inherit')").PlaceHolder("DURATION").String()
+
+	// Hooks.
+	policySetAddBeforeSnapshotHook   = policySetCommand.Flag("add-before-snapshot-hook", "Command with whitespace-separated arguments to run before taking a snapshot").PlaceHolder("COMMAND").Strings()
+	policySetClearBeforeSnapshotHook = policySetCommand.Flag("clear-before-snapshot-hooks", "Clear list of commands to run before taking a snapshot").Bool()
+	policySetAddAfterSnapshotHook    = policySetCommand.Flag("add-after-snapshot-hook", "Command with whitespace-separated arguments to run after taking a snapshot").PlaceHolder("COMMAND").Strings()
+	policySetClearAfterSnapshotHook  = policySetCommand.Flag("clear-after-snapshot-hooks", "Clear list of commands to run after taking a snapshot").Bool()
+	policySetHookTimeout             = policySetCommand.Flag("hook-timeout", "Timeout of added hook commands").Default(snapshot.DefaultHookTimeout.String()).Duration()
+	policySetHookAbortOnFailure      = policySetCommand.Flag("hook-abort-on-failure", "Failure of added hook commands aborts the snapshot instead of only logging a warning").Bool()
+
+	// Error handling.
+	policySetFailOnPermissionDenied = policySetCommand.Flag("fail-on-permission-denied", "Fail snapshots when files or directories can't be read due to insufficient permissions (true, false or 'inherit')").PlaceHolder("BOOL").String()
+	policySetFailOnVanished         = policySetCommand.Flag("fail-on-vanished", "Fail snapshots when files or directories are removed while taking the snapshot (true, false or 'inherit')").PlaceHolder("BOOL").String()
+	policySetFailOnIOError          = policySetCommand.Flag("fail-on-io-error", "Fail snapshots when files or directories can't be read due to other errors (true, false or 'inherit')").PlaceHolder("BOOL").String()
+
 	// General policy.
 	policySetInherit = policySetCommand.Flag("inherit", "Enable or disable inheriting policies from the parent").BoolList()
 )
@@ -103,6 +142,74 @@ func setPolicy(context *kingpin.ParseContext) error {
 			p.FilesPolicy.Exclude = nil
 		}
 
+		for _, path := range *policySetAddExcludePath {
+			p.FilesPolicy.ExcludePaths = addString(p.FilesPolicy.ExcludePaths, path)
+		}
+
+		for _, path := range *policySetRemoveExcludePath {
+			p.FilesPolicy.ExcludePaths = removeString(p.FilesPolicy.ExcludePaths, path)
+		}
+
+		if *policySetClearExcludePath {
+			p.FilesPolicy.ExcludePaths = nil
+		}
+
+		for _, name := range *policySetAddMarkerFile {
+			p.FilesPolicy.MarkerFiles = addString(p.FilesPolicy.MarkerFiles, name)
+		}
+
+		for _, name := range *policySetRemoveMarkerFile {
+			p.FilesPolicy.MarkerFiles = removeString(p.FilesPolicy.MarkerFiles, name)
+		}
+
+		if *policySetClearMarkerFile {
+			p.FilesPolicy.MarkerFiles = nil
+		}
+
+		if err := applyPolicyBool(target, "excluding cache directories", &p.FilesPolicy.ExcludeCaches, *policySetExcludeCaches); err != nil {
+			return err
+		}
+
+		if err := applyPolicyBool(target, "staying on one filesystem", &p.FilesPolicy.OneFileSystem, *policySetOneFileSystem); err != nil {
+			return err
+		}
+
+		if err := applyPolicyNumber(target, "maximum file size", &p.FilesPolicy.MaxSize, *policySetMaxSize); err != nil {
+			return err
+		}
+
+		if err := applyPolicyNumber(target, "minimum file size", &p.FilesPolicy.MinSize, *policySetMinSize); err != nil {
+			return err
+		}
+
+		if err := applyPolicyDuration(target, "minimum file age", &p.FilesPolicy.MinAge, *policySetMinAge); err != nil {
+			return err
+		}
+
+		if err := applyPolicyDuration(target, "maximum file age", &p.FilesPolicy.MaxAge, *policySetMaxAge); err != nil {
+			return err
+		}
+
+		if err := applyPolicyBool(target, "failing on permission denied errors", &p.ErrorHandlingPolicy.FailOnPermissionDenied, *policySetFailOnPermissionDenied); err != nil {
+			return err
+		}
+
+		if err := applyPolicyBool(target, "failing on vanished files", &p.ErrorHandlingPolicy.FailOnVanished, *policySetFailOnVanished); err != nil {
+			return err
+		}
+
+		if err := applyPolicyBool(target, "failing on I/O errors", &p.ErrorHandlingPolicy.FailOnIOError, *policySetFailOnIOError); err != nil {
+			return err
+		}
+
+		if err := applySchedulingPolicy(target, &p.SchedulingPolicy); err != nil {
+			return err
+		}
+
+		if err := applyHooksPolicy(target, &p.HooksPolicy); err != nil {
+			return err
+		}
+
 		if err := mgr.SavePolicy(p); err != nil {
 			return fmt.Errorf("can't save policy for %v: %v", target, err)
 		}
@@ -111,6 +218,129 @@ func setPolicy(context *kingpin.ParseContext) error {
 	return nil
 }
 
+func applySchedulingPolicy(src *snapshot.SourceInfo, sp *snapshot.SchedulingPolicy) error {
+	if err := applyPolicyDuration(src, "snapshot interval", &sp.Interval, *policySetSnapshotInterval); err != nil {
+		return err
+	}
+
+	for _, str := range *policySetAddSnapshotTime {
+		t, err := snapshot.ParseTimeOfDay(str)
+		if err != nil {
+			return err
+		}
+		sp.TimesOfDay = append(removeTimeOfDay(sp.TimesOfDay, t), t)
+		sort.Slice(sp.TimesOfDay, func(i, j int) bool {
+			return sp.TimesOfDay[i].String() < sp.TimesOfDay[j].String()
+		})
+	}
+
+	for _, str := range *policySetRemoveSnapshotTime {
+		t, err := snapshot.ParseTimeOfDay(str)
+		if err != nil {
+			return err
+		}
+		sp.TimesOfDay = removeTimeOfDay(sp.TimesOfDay, t)
+	}
+
+	if *policySetClearSnapshotTime {
+		sp.TimesOfDay = nil
+	}
+
+	for _, str := range *policySetAddSnapshotWindow {
+		w, err := snapshot.ParseTimeWindow(str)
+		if err != nil {
+			return err
+		}
+		sp.Windows = append(removeTimeWindow(sp.Windows, w), w)
+	}
+
+	for _, str := range *policySetRemoveSnapshotWindow {
+		w, err := snapshot.ParseTimeWindow(str)
+		if err != nil {
+			return err
+		}
+		sp.Windows = removeTimeWindow(sp.Windows, w)
+	}
+
+	if *policySetClearSnapshotWindow {
+		sp.Windows = nil
+	}
+
+	return nil
+}
+
+func applyHooksPolicy(src *snapshot.SourceInfo, hp *snapshot.HooksPolicy) error {
+	if *policySetClearBeforeSnapshotHook {
+		hp.BeforeSnapshot = nil
+	}
+
+	if *policySetClearAfterSnapshotHook {
+		hp.AfterSnapshot = nil
+	}
+
+	for _, str := range *policySetAddBeforeSnapshotHook {
+		h, err := parseHookCommand(str)
+		if err != nil {
+			return err
+		}
+		log.Printf("Adding before-snapshot hook %q on %q.", h.String(), src)
+		hp.BeforeSnapshot = append(hp.BeforeSnapshot, h)
+	}
+
+	for _, str := range *policySetAddAfterSnapshotHook {
+		h, err := parseHookCommand(str)
+		if err != nil {
+			return err
+		}
+		log.Printf("Adding after-snapshot hook %q on %q.", h.String(), src)
+		hp.AfterSnapshot = append(hp.AfterSnapshot, h)
+	}
+
+	if len(*policySetAddBeforeSnapshotHook)+len(*policySetAddAfterSnapshotHook) > 0 {
+		log.Printf("Hooks are run only by clients started with --enable-hooks.")
+	}
+
+	return nil
+}
+
+func parseHookCommand(str string) (snapshot.HookCommand, error) {
+	parts := strings.Fields(str)
+	if len(parts) == 0 {
+		return snapshot.HookCommand{}, fmt.Errorf("empty hook command")
+	}
+
+	return snapshot.HookCommand{
+		Command:        parts[0],
+		Arguments:      parts[1:],
+		Timeout:        *policySetHookTimeout,
+		AbortOnFailure: *policySetHookAbortOnFailure,
+	}, nil
+}
+
+func removeTimeOfDay(p []snapshot.TimeOfDay, t snapshot.TimeOfDay) []snapshot.TimeOfDay {
+	var result []snapshot.TimeOfDay
+
+	for _, item := range p {
+		if item == t {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+func removeTimeWindow(p []snapshot.TimeWindow, w snapshot.TimeWindow) []snapshot.TimeWindow {
+	var result []snapshot.TimeWindow
+
+	for _, item := range p {
+		if item == w {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
 func addString(p []string, s string) []string {
 	p = append(removeString(p, s), s)
 	sort.Strings(p)
@@ -151,3 +381,47 @@ func applyPolicyNumber(src *snapshot.SourceInfo, desc string, val **int, str str
 	*val = &i
 	return nil
 }
+
+func applyPolicyBool(src *snapshot.SourceInfo, desc string, val **bool, str string) error {
+	if str == "" {
+		// not changed
+		return nil
+	}
+
+	if str == "inherit" || str == "default" {
+		log.Printf("Resetting %v for %q to a default value inherited from parent.", desc, src)
+		*val = nil
+		return nil
+	}
+
+	v, err := strconv.ParseBool(str)
+	if err != nil {
+		return fmt.Errorf("can't parse the %v %q: %v", desc, str, err)
+	}
+
+	log.Printf("Setting %v on %q to %v.", desc, src, v)
+	*val = &v
+	return nil
+}
+
+func applyPolicyDuration(src *snapshot.SourceInfo, desc string, val **time.Duration, str string) error {
+	if str == "" {
+		// not changed
+		return nil
+	}
+
+	if str == "inherit" || str == "default" {
+		log.Printf("Resetting %v for %q to a default value inherited from parent.", desc, src)
+		*val = nil
+		return nil
+	}
+
+	v, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("can't parse the %v %q: %v", desc, str, err)
+	}
+
+	log.Printf("Setting %v on %q to %v.", desc, src, v)
+	*val = &v
+	return nil
+}
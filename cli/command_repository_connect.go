@@ -1,9 +1,9 @@
 package cli
 
 import (
-	"context"
 	"fmt"
 
+	"github.com/kopia/kopia/blob/throttling"
 	"github.com/kopia/kopia/repo"
 
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -14,12 +14,14 @@ var (
 	connectRepositoryLocation     = connectCommand.Arg("location", "Repository address").Required().String()
 	connectDontPersistCredentials bool
 	connectCacheDirectory         string
+	connectMaxCacheSizeMB         int64
 
 	// options shared by various providers
 	connectCredentialsFile                string
 	connectReadOnly                       bool
 	connectMaxDownloadSpeedBytesPerSecond int
 	connectMaxUploadSpeedBytesPerSecond   int
+	connectThrottleSchedule               []string
 
 	// options for filesystem provider
 	connectOwnerUID string
@@ -33,6 +35,7 @@ func setupConnectOptions(cmd *kingpin.CmdClause) {
 	// we must use *Var() methods, otherwise one of the commands would always get default flag values.
 	cmd.Flag("no-credentials", "Don't save credentials in the configuration file").Short('n').BoolVar(&connectDontPersistCredentials)
 	cmd.Flag("cache-directory", "Cache directory").PlaceHolder("PATH").StringVar(&connectCacheDirectory)
+	cmd.Flag("max-cache-size-mb", "Size limit for the local cache of repository metadata").PlaceHolder("MB").Int64Var(&connectMaxCacheSizeMB)
 	cmd.Flag("credentials", "File containing credentials to connect to storage (GCS)").PlaceHolder("PATH").ExistingFileVar(&connectCredentialsFile)
 	cmd.Flag("read-only", "Connect in read-only mode").BoolVar(&connectReadOnly)
 
@@ -43,13 +46,43 @@ func setupConnectOptions(cmd *kingpin.CmdClause) {
 
 	cmd.Flag("max-download-speed", "Limit the download speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&connectMaxDownloadSpeedBytesPerSecond)
 	cmd.Flag("max-upload-speed", "Limit the upload speed.").PlaceHolder("BYTES_PER_SEC").IntVar(&connectMaxUploadSpeedBytesPerSecond)
+	cmd.Flag("throttle-schedule", "Limit download and upload speeds during the specified time of day (0 means unlimited).").PlaceHolder("HH:MM-HH:MM=DOWNLOAD/UPLOAD").StringsVar(&connectThrottleSchedule)
 }
 
-func connectOptions() repo.ConnectOptions {
+func connectOptions() (repo.ConnectOptions, error) {
+	limits, err := throttlingLimitsFromFlags()
+	if err != nil {
+		return repo.ConnectOptions{}, err
+	}
+
 	return repo.ConnectOptions{
 		PersistCredentials: !connectDontPersistCredentials,
 		CacheDirectory:     connectCacheDirectory,
+		MaxCacheSizeBytes:  connectMaxCacheSizeMB << 20,
+		Throttling:         limits,
+	}, nil
+}
+
+func throttlingLimitsFromFlags() (*throttling.Limits, error) {
+	if connectMaxDownloadSpeedBytesPerSecond <= 0 && connectMaxUploadSpeedBytesPerSecond <= 0 && len(connectThrottleSchedule) == 0 {
+		return nil, nil
+	}
+
+	limits := &throttling.Limits{
+		MaxDownloadSpeedBytesPerSecond: connectMaxDownloadSpeedBytesPerSecond,
+		MaxUploadSpeedBytesPerSecond:   connectMaxUploadSpeedBytesPerSecond,
 	}
+
+	for _, s := range connectThrottleSchedule {
+		sl, err := throttling.ParseScheduledLimits(s)
+		if err != nil {
+			return nil, err
+		}
+
+		limits.Schedule = append(limits.Schedule, sl)
+	}
+
+	return limits, nil
 }
 
 func init() {
@@ -68,7 +101,12 @@ func runConnectCommand(_ *kingpin.ParseContext) error {
 		return err
 	}
 
-	if err := repo.Connect(context.Background(), repositoryConfigFileName(), storage, creds, connectOptions()); err != nil {
+	opt, err := connectOptions()
+	if err != nil {
+		return err
+	}
+
+	if err := repo.Connect(getContext(), repositoryConfigFileName(), storage, creds, opt); err != nil {
 		return err
 	}
 
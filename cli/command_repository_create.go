@@ -25,6 +25,11 @@ var (
 	createMaxPackedContentLength = createCommand.Flag("max-packed-file-size", "Minimum size of a file to include in a pack.").PlaceHolder("KB").Default("4096").Int()
 	createMaxPackFileLength      = createCommand.Flag("max-pack-size", "Minimum size of a single pack file.").PlaceHolder("KB").Default("20480").Int()
 
+	createWriteOnce    = createCommand.Flag("write-once", "Require all clients to access the repository in write-once mode, which prevents modification and deletion of stored data, but not of metadata such as policies (enforced by clients only).").Bool()
+	createMinRetention = createCommand.Flag("min-retention", "Minimum age of blocks that can be deleted from write-once repository (0 means never).").PlaceHolder("DURATION").Default("0").Duration()
+
+	createCompatibleWith = createCommand.Flag("compatible-with", "Configuration file of an existing repository, from which snapshots can be copied to the new repository without re-uploading.").PlaceHolder("PATH").ExistingFile()
+
 	createOverwrite = createCommand.Flag("overwrite", "Overwrite existing data (DANGEROUS).").Bool()
 	createOnly      = createCommand.Flag("create-only", "Create repository, but don't connect to it.").Short('c').Bool()
 )
@@ -34,10 +39,14 @@ func init() {
 	createCommand.Action(runCreateCommand)
 }
 
-func newRepositoryOptionsFromFlags() *repo.NewRepositoryOptions {
-	return &repo.NewRepositoryOptions{
-		MetadataEncryptionAlgorithm: *createMetadataEncryptionFormat,
-		ObjectFormat:                *createObjectFormat,
+func newRepositoryOptionsFromFlags() (*repo.NewRepositoryOptions, error) {
+	privilegedCreds, err := getPrivilegedCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	options := &repo.NewRepositoryOptions{
+		ObjectFormat: *createObjectFormat,
 
 		Splitter:     *createObjectSplitter,
 		MinBlockSize: *createMinBlockSize * 1024,
@@ -47,6 +56,22 @@ func newRepositoryOptionsFromFlags() *repo.NewRepositoryOptions {
 		MaxPackedContentLength: *createMaxPackedContentLength * 1024,
 		MaxPackFileLength:      *createMaxPackFileLength * 1024,
 	}
+
+	if *createCompatibleWith != "" {
+		compatibleRepo, err := repo.Open(getContext(), *createCompatibleWith, applyOptionsFromFlags(nil))
+		if err != nil {
+			return nil, fmt.Errorf("unable to open compatible repository: %v", err)
+		}
+		options = compatibleRepo.CompatibleRepositoryOptions()
+		compatibleRepo.Close()
+	}
+
+	options.MetadataEncryptionAlgorithm = *createMetadataEncryptionFormat
+	options.WriteOnce = *createWriteOnce
+	options.MinRetentionPeriod = *createMinRetention
+	options.PrivilegedCredentials = privilegedCreds
+
+	return options, nil
 }
 
 func openStorageAndEnsureEmpty(url string) (blob.Storage, error) {
@@ -77,7 +102,15 @@ func runCreateCommand(_ *kingpin.ParseContext) error {
 		return fmt.Errorf("unable to get repository storage: %v", err)
 	}
 
-	options := newRepositoryOptionsFromFlags()
+	options, err := newRepositoryOptionsFromFlags()
+	if err != nil {
+		return err
+	}
+
+	connectOpt, err := connectOptions()
+	if err != nil {
+		return err
+	}
 
 	creds, err := getRepositoryCredentials(true)
 	if err != nil {
@@ -101,13 +134,16 @@ func runCreateCommand(_ *kingpin.ParseContext) error {
 		fmt.Printf("  object splitter:     NEVER\n")
 	}
 
-	if err := repo.Initialize(st, options, creds); err != nil {
+	if options.WriteOnce {
+		fmt.Printf("  write-once:          min retention %v, privileged password: %v\n", options.MinRetentionPeriod, options.PrivilegedCredentials != nil)
+	}
+
+	if err := repo.Initialize(getContext(), st, options, creds); err != nil {
 		return fmt.Errorf("cannot initialize repository: %v", err)
 	}
 
 	if !*createOnly {
-		err := repo.Connect(getContext(), repositoryConfigFileName(), st, creds, connectOptions())
-		if err != nil {
+		if err := repo.Connect(getContext(), repositoryConfigFileName(), st, creds, connectOpt); err != nil {
 			return err
 		}
 
@@ -1,10 +1,14 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/kopia/kopia/fs/repofs"
+	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/snapshot"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
@@ -18,11 +22,32 @@ var (
 	migrateDirectories  = migrateCommand.Flag("directory-objects", "Migrate directory objects").Strings()
 	migrateLatestOnly   = migrateCommand.Flag("latest-only", "Only migrate the latest snapshot").Bool()
 	migrateIgnoreErrors = migrateCommand.Flag("ignore-errors", "Ignore errors when reading source backup").Bool()
+	migrateDirectCopy   = migrateCommand.Flag("direct-copy", "Copy blocks directly without re-uploading files when repository formats are compatible").Default("true").Bool()
+	migrateDryRun       = migrateCommand.Flag("dry-run", "Only print what would be migrated").Bool()
 )
 
+// migrator migrates snapshots and policies between repositories. Snapshots already present in the destination
+// (identified by source and start time) are skipped. Interrupted uploads are saved as incomplete snapshots,
+// whose hash caches are used to resume the migration when the command is invoked again.
+type migrator struct {
+	sourceRepo *repo.Repository
+	sourceSM   *snapshot.Manager
+	destSM     *snapshot.Manager
+	destRepo   *repo.Repository
+	uploader   *snapshot.Uploader
+	copier     *snapshot.Copier
+	dryRun     bool
+}
+
+// migratedSnapshot is a snapshot manifest in the destination repository along with its ID.
+type migratedSnapshot struct {
+	manifestID string
+	manifest   *snapshot.Manifest
+}
+
 func runMigrateCommand(context *kingpin.ParseContext) error {
 	destRepo := mustOpenRepository(nil)
-	destSM := snapshot.NewManager(destRepo)
+	defer destRepo.Close()
 
 	uploader := snapshot.NewUploader(destRepo)
 	uploader.Progress = &uploadProgress{}
@@ -33,66 +58,273 @@ func runMigrateCommand(context *kingpin.ParseContext) error {
 	if err != nil {
 		return fmt.Errorf("can't open source repository: %v", err)
 	}
+	defer sourceRepo.Close()
 
-	sourceSM := snapshot.NewManager(sourceRepo)
-	sources, err := getSourcesToMigrate(sourceSM)
+	mig := &migrator{
+		sourceRepo: sourceRepo,
+		sourceSM:   snapshot.NewManager(sourceRepo),
+		destRepo:   destRepo,
+		destSM:     snapshot.NewManager(destRepo),
+		uploader:   uploader,
+		dryRun:     *migrateDryRun,
+	}
+
+	if *migrateDirectCopy {
+		mig.copier, err = snapshot.NewCopier(mig.destSM, mig.sourceSM)
+		if err != nil {
+			log.Printf("unable to copy blocks directly, files will be re-uploaded: %v", err)
+		}
+	}
+
+	sources, err := getSourcesToMigrate(mig.sourceSM)
 	if err != nil {
 		return fmt.Errorf("can't retrieve sources: %v", err)
 	}
 
+	if err := mig.migratePolicies(sources); err != nil {
+		return fmt.Errorf("unable to migrate policies: %v", err)
+	}
+
 	for _, s := range sources {
 		if uploader.IsCancelled() {
 			log.Printf("upload cancelled")
 			break
 		}
 
-		log.Printf("migrating source %v", s)
+		if err := mig.migrateSource(s); err != nil {
+			return err
+		}
+	}
 
-		manifests, err := sourceSM.ListSnapshotManifests(s, -1)
+	for _, dir := range *migrateDirectories {
+		dirOID, err := repo.ParseObjectID(dir)
 		if err != nil {
-			return fmt.Errorf("unable to list snapshot manifests for %v: %v", s, err)
+			return err
 		}
 
-		snapshots, err := sourceSM.LoadSnapshots(manifests)
+		if mig.dryRun {
+			log.Printf("would migrate directory: %v", dirOID)
+			continue
+		}
+
+		d := repofs.Directory(sourceRepo, dirOID)
+		newm, err := uploader.Upload(d, &snapshot.SourceInfo{Host: "temp"}, nil)
 		if err != nil {
-			return fmt.Errorf("unable to load snapshot manifests for %v: %v", s, err)
+			return fmt.Errorf("error migrating directory %v: %v", dirOID, err)
 		}
 
-		for _, m := range filterSnapshotsToMigrate(snapshots) {
-			d := repofs.Directory(sourceRepo, m.RootObjectID)
-			newm, err := uploader.Upload(d, &m.Source, nil)
-			if err != nil {
-				return fmt.Errorf("error migrating shapshot %v @ %v: %v", m.Source, m.StartTime, err)
-			}
+		log.Printf("migrated directory: %v with %#v", dirOID, newm)
+	}
 
-			m.RootObjectID = newm.RootObjectID
-			m.HashCacheID = newm.HashCacheID
-			m.Stats = newm.Stats
-			m.IncompleteReason = newm.IncompleteReason
+	return nil
+}
 
-			if _, err := destSM.SaveSnapshot(m); err != nil {
-				return fmt.Errorf("cannot save manifest: %v", err)
+func (mig *migrator) migrateSource(s *snapshot.SourceInfo) error {
+	log.Printf("migrating source %v", s)
+
+	manifests, err := mig.sourceSM.ListSnapshotManifests(s, -1)
+	if err != nil {
+		return fmt.Errorf("unable to list snapshot manifests for %v: %v", s, err)
+	}
+
+	snapshots, err := mig.sourceSM.LoadSnapshots(manifests)
+	if err != nil {
+		return fmt.Errorf("unable to load snapshot manifests for %v: %v", s, err)
+	}
+
+	existing, err := mig.loadMigratedSnapshots(s)
+	if err != nil {
+		return fmt.Errorf("unable to load migrated snapshots for %v: %v", s, err)
+	}
+
+	// Snapshots are listed newest first, migrate them in chronological order so that
+	// each upload can use the hash cache of the previously migrated snapshot.
+	toMigrate := filterSnapshotsToMigrate(snapshots)
+	for i := len(toMigrate) - 1; i >= 0; i-- {
+		if mig.uploader.IsCancelled() {
+			return nil
+		}
+
+		m := toMigrate[i]
+		checkpoint := existing[m.StartTime.UnixNano()]
+		if checkpoint != nil && checkpoint.manifest.IncompleteReason == m.IncompleteReason {
+			log.Printf("  snapshot @ %v already migrated", m.StartTime)
+			continue
+		}
+
+		if mig.dryRun {
+			log.Printf("  would migrate snapshot @ %v", m.StartTime)
+			continue
+		}
+
+		migrated, err := mig.migrateSnapshot(m, checkpoint, existing)
+		if err != nil {
+			return fmt.Errorf("error migrating snapshot %v @ %v: %v", m.Source, m.StartTime, err)
+		}
+
+		existing[m.StartTime.UnixNano()] = migrated
+
+		if migrated.manifest.IncompleteReason != m.IncompleteReason {
+			log.Printf("migration of %v @ %v has been interrupted, run the command again to resume", m.Source, m.StartTime)
+			return nil
+		}
+
+		if checkpoint != nil && checkpoint.manifestID != migrated.manifestID {
+			if err := mig.destRepo.RemoveMetadata(checkpoint.manifestID); err != nil {
+				log.Printf("warning: unable to remove migration checkpoint %v: %v", checkpoint.manifestID, err)
 			}
 		}
 	}
 
-	for _, dir := range *migrateDirectories {
-		dirOID, err := repo.ParseObjectID(dir)
+	return nil
+}
+
+func (mig *migrator) migrateSnapshot(m *snapshot.Manifest, checkpoint *migratedSnapshot, existing map[int64]*migratedSnapshot) (*migratedSnapshot, error) {
+	if mig.copier != nil {
+		before := mig.copier.Stats()
+		manifestID, err := mig.copier.Copy(m)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		d := repofs.Directory(sourceRepo, dirOID)
-		newm, err := uploader.Upload(d, &snapshot.SourceInfo{Host: "temp"}, nil)
+
+		after := mig.copier.Stats()
+		log.Printf("  copied snapshot @ %v: %v blocks (%v), %v blocks already present",
+			m.StartTime,
+			after.CopiedBlocks-before.CopiedBlocks,
+			units.BytesStringBase10(after.CopiedBytes-before.CopiedBytes),
+			after.SkippedBlocks-before.SkippedBlocks)
+
+		return &migratedSnapshot{manifestID, m}, nil
+	}
+
+	previous := previousMigratedSnapshot(existing, m.StartTime)
+	if checkpoint != nil {
+		previous = checkpoint.manifest
+	}
+
+	newm, err := mig.uploader.Upload(snapshotRoot(mig.sourceRepo, m), &m.Source, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	// Preserve description and times of the original snapshot.
+	migrated := *m
+	migrated.RootObjectID = newm.RootObjectID
+	migrated.RootType = newm.RootType
+	migrated.HashCacheID = newm.HashCacheID
+	migrated.HashCacheCutoffTime = newm.HashCacheCutoffTime
+	migrated.Stats = newm.Stats
+	if newm.IncompleteReason != "" {
+		migrated.IncompleteReason = newm.IncompleteReason
+	}
+
+	manifestID, err := mig.destSM.SaveSnapshot(&migrated)
+	if err != nil {
+		return nil, fmt.Errorf("cannot save manifest: %v", err)
+	}
+
+	return &migratedSnapshot{manifestID, &migrated}, nil
+}
+
+// loadMigratedSnapshots returns snapshots of the specified source in the destination repository keyed by start time.
+func (mig *migrator) loadMigratedSnapshots(s *snapshot.SourceInfo) (map[int64]*migratedSnapshot, error) {
+	names, err := mig.destSM.ListSnapshotManifests(s, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[int64]*migratedSnapshot{}
+	for _, n := range names {
+		m, err := mig.destSM.LoadSnapshot(n)
 		if err != nil {
-			return fmt.Errorf("error migrating directory %v: %v", dirOID, err)
+			return nil, err
 		}
 
-		log.Printf("migrated directory: %v with %#v", dirOID, newm)
+		result[m.StartTime.UnixNano()] = &migratedSnapshot{n, m}
+	}
+
+	return result, nil
+}
+
+// previousMigratedSnapshot returns the latest complete migrated snapshot started before the specified time.
+func previousMigratedSnapshot(existing map[int64]*migratedSnapshot, startTime time.Time) *snapshot.Manifest {
+	var result *snapshot.Manifest
+
+	for _, e := range existing {
+		m := e.manifest
+		if m.IncompleteReason != "" || !m.StartTime.Before(startTime) {
+			continue
+		}
+
+		if result == nil || m.StartTime.After(result.StartTime) {
+			result = m
+		}
+	}
+
+	return result
+}
+
+// migratePolicies copies policies inherited by the migrated sources, or all policies when migrating all sources.
+func (mig *migrator) migratePolicies(sources []*snapshot.SourceInfo) error {
+	policies, err := mig.sourceSM.ListPolicies()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range policies {
+		if !*migrateAll && !policyAppliesToAny(&p.Source, sources) {
+			continue
+		}
+
+		existing, err := mig.destSM.GetPolicy(&p.Source)
+		if err != nil && err != snapshot.ErrPolicyNotFound {
+			return err
+		}
+
+		if existing != nil && policiesEqual(existing, p) {
+			continue
+		}
+
+		if mig.dryRun {
+			log.Printf("would migrate policy for %v", p.Source)
+			continue
+		}
+
+		log.Printf("migrating policy for %v", p.Source)
+		if err := mig.destSM.SavePolicy(p); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// policyAppliesToAny determines whether the policy defined for the target is inherited by any of the sources.
+func policyAppliesToAny(target *snapshot.SourceInfo, sources []*snapshot.SourceInfo) bool {
+	for _, s := range sources {
+		switch {
+		case target.Host == "":
+			return true
+		case target.Host != s.Host:
+			continue
+		case target.UserName == "":
+			return true
+		case target.UserName != s.UserName:
+			continue
+		case target.Path == "" || target.Path == s.Path || strings.HasPrefix(s.Path, strings.TrimSuffix(target.Path, "/")+"/"):
+			return true
+		}
+	}
+
+	return false
+}
+
+func policiesEqual(p1, p2 *snapshot.Policy) bool {
+	b1, err1 := json.Marshal(p1)
+	b2, err2 := json.Marshal(p2)
+	return err1 == nil && err2 == nil && string(b1) == string(b2)
+}
+
 func filterSnapshotsToMigrate(s []*snapshot.Manifest) []*snapshot.Manifest {
 	if *migrateLatestOnly && len(s) > 0 {
 		s = s[0:1]
@@ -3,6 +3,7 @@ package cli
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/kopia/kopia/blob"
 	"github.com/kopia/kopia/internal/units"
@@ -21,6 +22,10 @@ func runStatusCommand(context *kingpin.ParseContext) error {
 
 	fmt.Printf("Config file:         %v\n", rep.ConfigFile)
 	fmt.Printf("Cache directory:     %v\n", rep.CacheDirectory)
+	if cs, ok := rep.CacheStats(); ok {
+		fmt.Printf("Cache size:          %v in %v blocks (max %v)\n", units.BytesStringBase2(cs.SizeBytes), cs.Entries, units.BytesStringBase2(cs.MaxSizeBytes))
+		fmt.Printf("Cache hits/misses:   %v/%v (%v evictions)\n", cs.Hits, cs.Misses, cs.Evictions)
+	}
 	fmt.Println()
 
 	if cip, ok := rep.Storage.(blob.ConnectionInfoProvider); ok {
@@ -51,6 +56,16 @@ func runStatusCommand(context *kingpin.ParseContext) error {
 	fmt.Printf("Metadata Encryption: %v\n", s.MetadataEncryptionAlgorithm)
 	fmt.Printf("Key Derivation:      %v\n", s.KeyDerivationAlgorithm)
 	fmt.Printf("Unique ID:           %v\n", s.UniqueID)
+	if len(s.RequiredFeatures) > 0 {
+		fmt.Printf("Required features:   %v\n", strings.Join(s.RequiredFeatures, ", "))
+	}
+	if s.WriteOnce {
+		if s.MinRetentionPeriod > 0 {
+			fmt.Printf("Write-once:          blocks retained for at least %v\n", s.MinRetentionPeriod)
+		} else {
+			fmt.Printf("Write-once:          blocks retained forever\n")
+		}
+	}
 	fmt.Println()
 	fmt.Printf("Object manager:      v%v\n", s.ObjectManagerVersion)
 	fmt.Printf("Object format:       %v\n", s.ObjectFormat)
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb"
+	"github.com/kopia/kopia/blob"
+	"github.com/kopia/kopia/internal/config"
+	"github.com/kopia/kopia/internal/units"
+	"github.com/kopia/kopia/repo"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	syncToCommand     = repositoryCommands.Command("sync-to", "Synchronize raw blocks of connected repository to another storage location.")
+	syncToLocation    = syncToCommand.Arg("location", "Destination storage location").Required().String()
+	syncToParallelism = syncToCommand.Flag("parallel", "Number of blocks copied in parallel").Default("10").Int()
+	syncToDelete      = syncToCommand.Flag("delete", "Delete blocks missing in the source from the destination after copying all blocks").Bool()
+)
+
+func init() {
+	setupConnectOptions(syncToCommand)
+	syncToCommand.Action(runSyncToCommand)
+}
+
+func runSyncToCommand(_ *kingpin.ParseContext) error {
+	lc, err := config.LoadFromFile(repositoryConfigFileName())
+	if err != nil {
+		return fmt.Errorf("unable to load repository configuration: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(getContext())
+	defer cancel()
+
+	src, err := blob.NewStorage(ctx, lc.Connection.ConnectionInfo)
+	if err != nil {
+		return fmt.Errorf("unable to open source storage: %v", err)
+	}
+	defer src.Close()
+
+	dst, err := newStorageFromURL(ctx, *syncToLocation)
+	if err != nil {
+		return fmt.Errorf("unable to open destination storage: %v", err)
+	}
+	defer dst.Close()
+
+	onCtrlC(cancel)
+
+	var bar *pb.ProgressBar
+	var progressStarted sync.Once
+
+	log.Printf("Synchronizing to %v...", *syncToLocation)
+	stats, err := repo.SyncStorage(ctx, src, dst, &repo.SyncOptions{
+		Parallelism: *syncToParallelism,
+		Delete:      *syncToDelete,
+		Progress: func(s repo.SyncStats) {
+			progressStarted.Do(func() {
+				bar = pb.New64(s.BytesToCopy).Prefix("  Copying")
+				bar.SetRefreshRate(time.Second)
+				bar.ShowSpeed = true
+				bar.ShowTimeLeft = true
+				bar.SetUnits(pb.U_BYTES)
+				bar.Start()
+			})
+			bar.Set64(s.CopiedBytes)
+		},
+	})
+
+	if bar != nil {
+		bar.Finish()
+	}
+
+	log.Printf("Copied %v of %v blocks (%v), %v blocks were already present.",
+		stats.CopiedBlocks, stats.BlocksToCopy, units.BytesStringBase10(stats.CopiedBytes), stats.SkippedBlocks)
+	if *syncToDelete {
+		log.Printf("Deleted %v of %v blocks missing in the source.", stats.DeletedBlocks, stats.BlocksToDelete)
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("synchronization interrupted, run the command again to resume: %v", err)
+		}
+
+		return err
+	}
+
+	return nil
+}
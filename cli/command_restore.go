@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/fs/localfs"
+	"github.com/kopia/kopia/fs/repofs"
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	restoreCommand    = app.Command("restore", "Restore a directory or file stored in repository to the local filesystem.")
+	restoreObjectID   = restoreCommand.Arg("path", "Identifier of the directory or file to restore.").Required().String()
+	restoreTargetPath = restoreCommand.Arg("target-path", "Local directory, which will contain restored files, or path of the restored file.").Required().String()
+)
+
+func runRestoreCommand(context *kingpin.ParseContext) error {
+	rep := mustOpenRepository(nil)
+	defer rep.Close()
+
+	e, err := findRestoredEntry(rep, *restoreObjectID)
+	if err != nil {
+		return err
+	}
+
+	switch e := e.(type) {
+	case fs.Directory:
+		return localfs.RestoreDirectory(e, *restoreTargetPath)
+
+	case fs.File:
+		return localfs.RestoreFile(e, *restoreTargetPath)
+
+	default:
+		return fmt.Errorf("%v is neither a directory nor a file", *restoreObjectID)
+	}
+}
+
+// findRestoredEntry returns the entry with the specified object ID, optionally followed by a path within the directory.
+// Object IDs themselves don't indicate whether they are files or directories, so types of snapshot roots
+// are determined from their manifests.
+func findRestoredEntry(rep *repo.Repository, id string) (fs.Entry, error) {
+	head, tail := splitHeadTail(id)
+	oid, err := parseObjectID(head, rep)
+	if err != nil {
+		return nil, err
+	}
+
+	if tail != "" {
+		return findNestedEntry(repofs.Directory(rep, oid), tail)
+	}
+
+	m, err := findSnapshotWithRoot(snapshot.NewManager(rep), oid)
+	if err != nil {
+		return nil, err
+	}
+
+	if m != nil {
+		return snapshotRoot(rep, m), nil
+	}
+
+	return repofs.Directory(rep, oid), nil
+}
+
+// findSnapshotWithRoot returns the manifest of any snapshot with the specified root object or nil if not found.
+func findSnapshotWithRoot(mgr *snapshot.Manager, oid repo.ObjectID) (*snapshot.Manifest, error) {
+	names, err := mgr.ListSnapshotManifests(nil, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := mgr.LoadSnapshots(names)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range manifests {
+		if m.RootObjectID.String() == oid.String() {
+			return m, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func init() {
+	restoreCommand.Action(runRestoreCommand)
+}
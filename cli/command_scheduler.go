@@ -0,0 +1,196 @@
+package cli
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	schedulerCommand            = app.Command("scheduler", "Keep running and take snapshots of local sources when they are due according to their policies.")
+	schedulerPollInterval       = schedulerCommand.Flag("poll-interval", "How often to re-evaluate policies of local sources.").Default("1m").Duration()
+	schedulerHashCacheMinAge    = schedulerCommand.Flag("hash-cache-min-age", "Do not hash-cache files below certain age").Default("1h").Duration()
+	schedulerParallelUploads    = schedulerCommand.Flag("parallel", "Number of files uploaded in parallel.").PlaceHolder("N").Default("4").Int()
+	schedulerCheckpointInterval = schedulerCommand.Flag("checkpoint-interval", "How often to save checkpoints, from which interrupted uploads are resumed (0 to disable).").Default("30m").Duration()
+)
+
+func init() {
+	schedulerCommand.Action(runSchedulerCommand)
+}
+
+func runSchedulerCommand(_ *kingpin.ParseContext) error {
+	rep := mustOpenRepository(nil)
+	defer rep.Close()
+
+	mgr := snapshot.NewManager(rep)
+
+	u := snapshot.NewUploader(rep)
+	u.HashCacheMinAge = *schedulerHashCacheMinAge
+	u.ParallelUploads = *schedulerParallelUploads
+	u.CheckpointInterval = *schedulerCheckpointInterval
+
+	stop := make(chan struct{})
+	onCtrlC(func() {
+		log.Printf("Stopping scheduler...")
+		close(stop)
+		u.Cancel()
+	})
+
+	for {
+		next := runDueSnapshots(rep, mgr, u, stop)
+
+		// Sleeping at most until the next poll ensures that snapshots, which became due while the computer was
+		// suspended, are caught up shortly after resuming.
+		wait := *schedulerPollInterval
+		if !next.IsZero() {
+			if d := next.Sub(time.Now()); d < wait {
+				wait = d
+			}
+		}
+
+		if wait > 0 {
+			log.Printf("Next check at %v", time.Now().Add(wait).Format(time.RFC3339))
+		}
+
+		select {
+		case <-stop:
+			return nil
+
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runDueSnapshots takes snapshots of all local sources, which are due, and returns the time of the next scheduled snapshot
+// or zero time if none are scheduled. Snapshots are taken one at a time, so runs of the same source never overlap.
+func runDueSnapshots(rep *repo.Repository, mgr *snapshot.Manager, u *snapshot.Uploader, stop chan struct{}) time.Time {
+	paths, err := getScheduledSourcePaths(mgr)
+	if err != nil {
+		log.Printf("warning: unable to list local sources: %v", err)
+		return time.Time{}
+	}
+
+	var next time.Time
+
+	for _, path := range paths {
+		select {
+		case <-stop:
+			return time.Time{}
+		default:
+		}
+
+		sourceInfo := &snapshot.SourceInfo{Path: path, Host: getHostName(), UserName: getUserName()}
+
+		due, ok, err := nextSnapshotTime(mgr, sourceInfo)
+		if err != nil {
+			log.Printf("warning: unable to determine schedule of %v: %v", sourceInfo, err)
+			continue
+		}
+
+		if !ok {
+			continue
+		}
+
+		if due.After(time.Now()) {
+			if next.IsZero() || due.Before(next) {
+				next = due
+			}
+			continue
+		}
+
+		rep.ResetStats()
+		log.Printf("Backing up %v (due at %v)", path, due.Format(time.RFC3339))
+		if err := snapshotSingleSource(mgr, u, sourceInfo, ""); err != nil {
+			// Failed snapshot will be retried after the poll interval.
+			log.Printf("warning: unable to snapshot %v: %v", sourceInfo, err)
+			continue
+		}
+
+		// The snapshot may have taken long enough for other sources to become due, re-evaluate immediately.
+		next = time.Now()
+	}
+
+	return next
+}
+
+// nextSnapshotTime returns the time when the next snapshot of the source is due according to its effective policy.
+func nextSnapshotTime(mgr *snapshot.Manager, sourceInfo *snapshot.SourceInfo) (time.Time, bool, error) {
+	policy, err := mgr.GetEffectivePolicy(sourceInfo)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	previous, err := mgr.ListSnapshots(sourceInfo, 1)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	// Snapshots interrupted after saving a checkpoint are resumed immediately.
+	if len(previous) > 0 && previous[0].IncompleteReason == snapshot.IncompleteReasonCheckpoint {
+		due, ok := policy.SchedulingPolicy.ResumeTime(time.Now())
+		return due, ok, nil
+	}
+
+	var previousStartTime time.Time
+	if len(previous) > 0 {
+		previousStartTime = previous[0].StartTime
+	}
+
+	due, ok := policy.SchedulingPolicy.NextSnapshotTime(previousStartTime, time.Now())
+	return due, ok, nil
+}
+
+// getScheduledSourcePaths returns paths previously backed up by this user on this computer
+// and paths, which have their own policies.
+func getScheduledSourcePaths(mgr *snapshot.Manager) ([]string, error) {
+	h := getHostName()
+	u := getUserName()
+
+	sources, err := mgr.ListSources()
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, src := range sources {
+		// Data streamed from standard input and tar archives can't be snapshotted by the scheduler.
+		if src.IsSynthetic() {
+			continue
+		}
+
+		if src.Host == h && src.UserName == u {
+			paths = append(paths, src.Path)
+		}
+	}
+
+	policies, err := mgr.ListPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range policies {
+		if p.Source.IsSynthetic() {
+			continue
+		}
+
+		if p.Source.Path != "" && p.Source.Host == h && p.Source.UserName == u {
+			paths = append(paths, p.Source.Path)
+		}
+	}
+
+	sort.Strings(paths)
+
+	var result []string
+	for i, p := range paths {
+		if i == 0 || paths[i-1] != p {
+			result = append(result, p)
+		}
+	}
+
+	return result, nil
+}
@@ -12,7 +12,13 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
+	"github.com/kopia/kopia/blob/writeonce"
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/fs/tarfs"
+	"github.com/kopia/kopia/fs/virtualfs"
+	"github.com/kopia/kopia/internal/hashcache"
 	"github.com/kopia/kopia/repo"
 	"github.com/kopia/kopia/snapshot"
 
@@ -33,6 +39,13 @@ var (
 	snapshotCreateForceHash               = snapshotCreateCommand.Flag("force-hash", "Force hashing of source files for a given percentage of files [0..100]").Default("0").Int()
 	snapshotCreateHashCacheMinAge         = snapshotCreateCommand.Flag("hash-cache-min-age", "Do not hash-cache files below certain age").Default("1h").Duration()
 	snapshotCreateWriteBack               = snapshotCreateCommand.Flag("async-write", "Perform updates asynchronously.").PlaceHolder("N").Default("0").Int()
+	snapshotCreateParallelUploads         = snapshotCreateCommand.Flag("parallel", "Number of files uploaded in parallel.").PlaceHolder("N").Default("4").Int()
+	snapshotCreateStdin                   = snapshotCreateCommand.Flag("stdin", "Create snapshot of data streamed from standard input.").Bool()
+	snapshotCreateStdinName               = snapshotCreateCommand.Flag("stdin-name", "Name of data streamed from standard input.").PlaceHolder("NAME").String()
+	snapshotCreateFromTar                 = snapshotCreateCommand.Flag("from-tar", "Create snapshot of contents of the tar archive.").PlaceHolder("FILE").ExistingFile()
+	snapshotCreateCheckpointInterval      = snapshotCreateCommand.Flag("checkpoint-interval", "How often to save checkpoints, from which interrupted uploads are resumed (0 to disable).").Default("30m").Duration()
+	snapshotCreateChangedFileRetries      = snapshotCreateCommand.Flag("changed-file-retries", "How many times to read files, which change while being read, again before storing them as possibly inconsistent.").PlaceHolder("N").Default("3").Int()
+	snapshotCreateLocalHashCache          = snapshotCreateCommand.Flag("local-hash-cache", "Use local database of file hashes keyed by inode and change time.").Bool()
 )
 
 func runBackupCommand(c *kingpin.ParseContext) error {
@@ -43,6 +56,14 @@ func runBackupCommand(c *kingpin.ParseContext) error {
 
 	mgr := snapshot.NewManager(rep)
 
+	if *snapshotCreateStdin {
+		return runStdinBackup(rep, mgr)
+	}
+
+	if *snapshotCreateFromTar != "" {
+		return runTarBackup(rep, mgr)
+	}
+
 	sources := *snapshotCreateSources
 	if *snapshotCreateAll {
 		local, err := getLocalBackupPaths(mgr)
@@ -60,10 +81,27 @@ func runBackupCommand(c *kingpin.ParseContext) error {
 	u.MaxUploadBytes = *snapshotCreateCheckpointUploadLimitMB * 1024 * 1024
 	u.ForceHashPercentage = *snapshotCreateForceHash
 	u.HashCacheMinAge = *snapshotCreateHashCacheMinAge
+	u.ParallelUploads = *snapshotCreateParallelUploads
+	u.CheckpointInterval = *snapshotCreateCheckpointInterval
+	u.ChangedFileRetries = *snapshotCreateChangedFileRetries
 	onCtrlC(u.Cancel)
 
+	if *snapshotCreateLocalHashCache {
+		db, err := openLocalHashCache(rep)
+		if err != nil {
+			log.Printf("warning: unable to open local hash cache: %v", err)
+		} else {
+			defer db.Close()
+			u.LocalHashCache = db
+		}
+	}
+
 	u.Progress = &uploadProgress{}
 
+	if len(*snapshotCreateDescription) > maxSnapshotDescriptionLength {
+		return fmt.Errorf("description too long")
+	}
+
 	for _, backupDirectory := range sources {
 		rep.ResetStats()
 		log.Printf("Backing up %v", backupDirectory)
@@ -73,54 +111,221 @@ func runBackupCommand(c *kingpin.ParseContext) error {
 		}
 
 		sourceInfo := &snapshot.SourceInfo{Path: filepath.Clean(dir), Host: getHostName(), UserName: getUserName()}
-		policy, err := mgr.GetEffectivePolicy(sourceInfo)
-		if err != nil {
-			return fmt.Errorf("unable to get backup policy for source %v: %v", sourceInfo, err)
+		if err := snapshotSingleSource(mgr, u, sourceInfo, *snapshotCreateDescription); err != nil {
+			return err
 		}
+	}
 
-		if len(*snapshotCreateDescription) > maxSnapshotDescriptionLength {
-			return fmt.Errorf("description too long")
-		}
+	return nil
+}
 
-		previous, err := mgr.ListSnapshots(sourceInfo, 1)
-		if err != nil {
-			return fmt.Errorf("error listing previous backups: %v", err)
-		}
+func runStdinBackup(rep *repo.Repository, mgr *snapshot.Manager) error {
+	if *snapshotCreateStdinName == "" {
+		return fmt.Errorf("--stdin-name is required when streaming from standard input")
+	}
 
-		var oldManifest *snapshot.Manifest
+	if len(*snapshotCreateSources) > 0 || *snapshotCreateAll {
+		return fmt.Errorf("sources can't be specified when streaming from standard input")
+	}
 
-		if len(previous) > 0 {
-			oldManifest = previous[0]
-		}
+	if len(*snapshotCreateDescription) > maxSnapshotDescriptionLength {
+		return fmt.Errorf("description too long")
+	}
 
-		localEntry := mustGetLocalFSEntry(sourceInfo.Path)
-		if err != nil {
+	u := snapshot.NewUploader(rep)
+	u.MaxUploadBytes = *snapshotCreateCheckpointUploadLimitMB * 1024 * 1024
+	onCtrlC(u.Cancel)
+
+	u.Progress = &uploadProgress{}
+
+	sourceInfo := snapshot.StdinSourceInfo(*snapshotCreateStdinName, getHostName(), getUserName())
+	log.Printf("Backing up standard input as %v", sourceInfo)
+	return snapshotSingleSource(mgr, u, &sourceInfo, *snapshotCreateDescription)
+}
+
+func runTarBackup(rep *repo.Repository, mgr *snapshot.Manager) error {
+	if len(*snapshotCreateSources) > 0 || *snapshotCreateAll {
+		return fmt.Errorf("sources can't be specified when creating snapshot of a tar archive")
+	}
+
+	if len(*snapshotCreateDescription) > maxSnapshotDescriptionLength {
+		return fmt.Errorf("description too long")
+	}
+
+	archivePath, err := filepath.Abs(*snapshotCreateFromTar)
+	if err != nil {
+		return fmt.Errorf("invalid archive path: '%s': %s", *snapshotCreateFromTar, err)
+	}
+
+	u := snapshot.NewUploader(rep)
+	u.MaxUploadBytes = *snapshotCreateCheckpointUploadLimitMB * 1024 * 1024
+	u.ForceHashPercentage = *snapshotCreateForceHash
+	u.ParallelUploads = *snapshotCreateParallelUploads
+	u.CheckpointInterval = *snapshotCreateCheckpointInterval
+	onCtrlC(u.Cancel)
+
+	u.Progress = &uploadProgress{}
+
+	sourceInfo := snapshot.TarSourceInfo(filepath.Clean(archivePath), getHostName(), getUserName())
+	log.Printf("Backing up contents of %v", sourceInfo)
+	return snapshotSingleSource(mgr, u, &sourceInfo, *snapshotCreateDescription)
+}
+
+// snapshotSingleSource uploads a snapshot of the local source using its effective policy and saves its manifest.
+func snapshotSingleSource(mgr *snapshot.Manager, u *snapshot.Uploader, sourceInfo *snapshot.SourceInfo, description string) error {
+	policy, err := mgr.GetEffectivePolicy(sourceInfo)
+	if err != nil {
+		return fmt.Errorf("unable to get backup policy for source %v: %v", sourceInfo, err)
+	}
+
+	previous, err := mgr.ListSnapshotManifests(sourceInfo, 1)
+	if err != nil {
+		return fmt.Errorf("error listing previous backups: %v", err)
+	}
+
+	var oldManifest *snapshot.Manifest
+
+	// IDs of checkpoints, which are superseded by the next checkpoint or the snapshot.
+	var checkpoints []string
+
+	if len(previous) > 0 {
+		if oldManifest, err = mgr.LoadSnapshot(previous[0]); err != nil {
 			return err
 		}
 
-		u.FilesPolicy = policy.FilesPolicy
+		if oldManifest.IncompleteReason == snapshot.IncompleteReasonCheckpoint {
+			log.Printf("Resuming interrupted snapshot from checkpoint saved at %v", oldManifest.EndTime.Format(time.RFC3339))
+			checkpoints = append(checkpoints, previous[0])
+		}
+	}
 
-		manifest, err := u.Upload(localEntry, sourceInfo, oldManifest)
+	u.FilesPolicy = policy.FilesPolicy
+	u.ErrorHandlingPolicy = policy.ErrorHandlingPolicy
+	u.CheckpointFunc = func(m *snapshot.Manifest) error {
+		m.Description = description
+		manifestID, err := mgr.SaveSnapshot(m)
 		if err != nil {
 			return err
 		}
 
-		manifest.Description = *snapshotCreateDescription
+		log.Printf("Saved checkpoint %v", manifestID)
+		removeCheckpoints(mgr, checkpoints)
+		checkpoints = []string{manifestID}
+		return nil
+	}
 
-		if _, err := mgr.SaveSnapshot(manifest); err != nil {
-			return fmt.Errorf("cannot save manifest: %v", err)
-		}
+	hooks := enabledHooks(sourceInfo, &policy.HooksPolicy)
+
+	// After-snapshot hooks run even if before-snapshot hooks or the upload fail, so they can clean up.
+	hookResults, err := hooks.RunBeforeSnapshot(sourceInfo)
+	if err != nil {
+		hooks.RunAfterSnapshot(sourceInfo, nil, err)
+		return err
+	}
+
+	var manifest *snapshot.Manifest
+	entry, closeEntry, err := getSourceEntry(sourceInfo)
+	if err == nil {
+		manifest, err = u.Upload(entry, sourceInfo, oldManifest)
+		closeEntry()
+	}
 
-		log.Printf("Root: %v", manifest.RootObjectID.String())
-		log.Printf("Hash Cache: %v", manifest.HashCacheID.String())
+	afterResults, hookErr := hooks.RunAfterSnapshot(sourceInfo, manifest, err)
+	if err != nil {
+		return err
+	}
 
-		b, _ := json.MarshalIndent(&manifest, "", "  ")
-		log.Printf("%s", string(b))
+	if hookErr != nil {
+		return hookErr
 	}
 
+	manifest.Description = description
+	manifest.Hooks = append(hookResults, afterResults...)
+
+	if _, err := mgr.SaveSnapshot(manifest); err != nil {
+		return fmt.Errorf("cannot save manifest: %v", err)
+	}
+
+	removeCheckpoints(mgr, checkpoints)
+
+	log.Printf("Root: %v", manifest.RootObjectID.String())
+	log.Printf("Hash Cache: %v", manifest.HashCacheID.String())
+
+	b, _ := json.MarshalIndent(&manifest, "", "  ")
+	log.Printf("%s", string(b))
 	return nil
 }
 
+// enabledHooks returns hooks of the policy, which are run only when enabled locally, because policies stored in the repository
+// would otherwise allow anyone with access to it to run commands on every computer taking snapshots.
+func enabledHooks(sourceInfo *snapshot.SourceInfo, hp *snapshot.HooksPolicy) *snapshot.HooksPolicy {
+	if *enableHooks || len(hp.BeforeSnapshot)+len(hp.AfterSnapshot) == 0 {
+		return hp
+	}
+
+	log.Printf("warning: skipping hooks of %v defined in policies, use --enable-hooks to run them", sourceInfo)
+	return &snapshot.HooksPolicy{}
+}
+
+// removeCheckpoints removes superseded checkpoint manifests. Write-once repositories keep them until their minimum
+// retention period has elapsed.
+func removeCheckpoints(mgr *snapshot.Manager, manifestIDs []string) {
+	for _, id := range manifestIDs {
+		if err := mgr.DeleteSnapshot(id); err == writeonce.ErrDeleteNotAllowed {
+			log.Printf("Keeping checkpoint %v until its minimum retention period has elapsed", id)
+		} else if err != nil {
+			log.Printf("warning: unable to remove checkpoint %v: %v", id, err)
+		}
+	}
+}
+
+// getSourceEntry returns the filesystem entry to upload for the source, which is either a local path, standard input
+// or contents of a tar archive, and a function releasing resources used by the entry.
+func getSourceEntry(sourceInfo *snapshot.SourceInfo) (fs.Entry, func(), error) {
+	if name, ok := sourceInfo.StdinName(); ok {
+		return virtualfs.StreamingFile(name, os.Stdin), func() {}, nil
+	}
+
+	if archivePath, ok := sourceInfo.TarPath(); ok {
+		return openTarArchive(archivePath)
+	}
+
+	e, err := getLocalFSEntry(sourceInfo.Path)
+	return e, func() {}, err
+}
+
+// openTarArchive returns the root directory of the tar archive, whose contents are read from the archive file
+// until the returned function is called.
+func openTarArchive(archivePath string) (fs.Entry, func(), error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open archive: %v", err)
+	}
+
+	a, err := tarfs.Open(f, filepath.Base(archivePath))
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return a.Root(), func() {
+		a.Close()
+		f.Close()
+	}, nil
+}
+
+func openLocalHashCache(rep *repo.Repository) (*hashcache.DB, error) {
+	if rep.CacheDirectory == "" {
+		return nil, fmt.Errorf("cache directory not configured")
+	}
+
+	if err := os.MkdirAll(rep.CacheDirectory, 0700); err != nil {
+		return nil, err
+	}
+
+	return hashcache.NewHashCacheDB(filepath.Join(rep.CacheDirectory, "hashcache.db"))
+}
+
 func getLocalBackupPaths(mgr *snapshot.Manager) ([]string, error) {
 	h := getHostName()
 	u := getUserName()
@@ -134,6 +339,10 @@ func getLocalBackupPaths(mgr *snapshot.Manager) ([]string, error) {
 	var result []string
 
 	for _, src := range sources {
+		if src.IsSynthetic() {
+			continue
+		}
+
 		if src.Host == h && src.UserName == u {
 			result = append(result, src.Path)
 		}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"log"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/ignore"
 	"github.com/kopia/kopia/internal/units"
 	"github.com/kopia/kopia/snapshot"
 
@@ -47,6 +49,28 @@ func (b buckets) add(fname string, size int64) {
 	}
 }
 
+func (b buckets) total() (int, int64) {
+	var count int
+	var size int64
+	for _, bucket := range b {
+		count += bucket.Count
+		size += bucket.TotalSize
+	}
+	return count, size
+}
+
+// excludedBuckets keeps buckets of excluded entries for each rule, which excluded them.
+type excludedBuckets map[string]buckets
+
+func (eb excludedBuckets) add(reason string, fname string, size int64) {
+	b := eb[reason]
+	if b == nil {
+		b = makeBuckets()
+		eb[reason] = b
+	}
+	b.add(fname, size)
+}
+
 func makeBuckets() buckets {
 	return buckets{
 		&bucket{MinSize: 1e15},
@@ -77,8 +101,17 @@ func runSnapshotEstimateCommand(c *kingpin.ParseContext) error {
 
 	var stats snapshot.Stats
 	ib := makeBuckets()
-	eb := makeBuckets()
-	if err := estimate(".", mustGetLocalFSEntry(path), &policy.FilesPolicy, &stats, ib, eb); err != nil {
+	eb := excludedBuckets{}
+	root := mustGetLocalFSEntry(path)
+	est := &estimator{
+		policy: &policy.FilesPolicy,
+		root:   root,
+		now:    time.Now(),
+		stats:  &stats,
+		ib:     ib,
+		eb:     eb,
+	}
+	if err := est.estimate(".", root, nil); err != nil {
 		return err
 	}
 
@@ -87,7 +120,16 @@ func runSnapshotEstimateCommand(c *kingpin.ParseContext) error {
 	fmt.Println()
 
 	fmt.Printf("Snapshot excludes %v files, total size %v\n", stats.ExcludedFileCount, units.BytesStringBase10(stats.ExcludedTotalFileSize))
-	showBuckets(eb)
+	var reasons []string
+	for reason := range eb {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		count, size := eb[reason].total()
+		fmt.Printf(" excluded by %v: %v files, total size %v\n", reason, count, units.BytesStringBase10(size))
+		showBuckets(eb[reason])
+	}
 
 	megabits := float64(stats.TotalFileSize) * 8 / 1000000
 	seconds := megabits / *snapshotEstimateUploadSpeed
@@ -111,12 +153,34 @@ func showBuckets(b buckets) {
 		}
 	}
 }
-func estimate(relativePath string, entry fs.Entry, pol *snapshot.FilesPolicy, stats *snapshot.Stats, ib, eb buckets) error {
-	if !pol.ShouldInclude(entry.Metadata()) {
-		eb.add(relativePath, entry.Metadata().FileSize)
-		stats.ExcludedFileCount++
-		stats.ExcludedTotalFileSize += entry.Metadata().FileSize
-		return nil
+
+type estimator struct {
+	policy *snapshot.FilesPolicy
+	root   fs.Entry
+	now    time.Time
+	stats  *snapshot.Stats
+	ib     buckets
+	eb     excludedBuckets
+}
+
+func (est *estimator) exclude(reason string, relativePath string, entry fs.Entry) {
+	est.eb.add(reason, relativePath, entry.Metadata().FileSize)
+	est.stats.ExcludedFileCount++
+	est.stats.ExcludedTotalFileSize += entry.Metadata().FileSize
+}
+
+func (est *estimator) estimate(relativePath string, entry fs.Entry, ignoreRules *ignore.Matcher) error {
+	// The root is never excluded.
+	if entry != est.root {
+		if reason := est.policy.ExcludeReason(filepath.ToSlash(relativePath), entry, est.root, est.now); reason != "" {
+			est.exclude(reason, relativePath, entry)
+			return nil
+		}
+
+		if ignoreRules.Ignored(filepath.ToSlash(relativePath), entry.Metadata().Type == fs.EntryTypeDirectory) {
+			est.exclude(ignore.FileName, relativePath, entry)
+			return nil
+		}
 	}
 
 	switch entry := entry.(type) {
@@ -129,16 +193,28 @@ func estimate(relativePath string, entry fs.Entry, pol *snapshot.FilesPolicy, st
 			return err
 		}
 
+		if reason := est.policy.ExcludeContentsReason(children); reason != "" {
+			for _, child := range children {
+				est.exclude(reason, filepath.Join(relativePath, child.Metadata().Name), child)
+			}
+			return nil
+		}
+
+		childIgnoreRules, err := ignoreRules.ForDirectory(filepath.ToSlash(relativePath), children)
+		if err != nil {
+			log.Printf("warning: %v", err)
+		}
+
 		for _, child := range children {
-			if err := estimate(filepath.Join(relativePath, child.Metadata().Name), child, pol, stats, ib, eb); err != nil {
+			if err := est.estimate(filepath.Join(relativePath, child.Metadata().Name), child, childIgnoreRules); err != nil {
 				return err
 			}
 		}
 
 	case fs.File:
-		ib.add(relativePath, entry.Metadata().FileSize)
-		stats.TotalFileCount++
-		stats.TotalFileSize += entry.Metadata().FileSize
+		est.ib.add(relativePath, entry.Metadata().FileSize)
+		est.stats.TotalFileCount++
+		est.stats.TotalFileSize += entry.Metadata().FileSize
 	}
 	return nil
 }
@@ -19,6 +19,7 @@ var (
 	snapshotListIncludeIncomplete = snapshotListCommand.Flag("include-incomplete", "Include incomplete.").Short('i').Bool()
 	snapshotListShowItemID        = snapshotListCommand.Flag("show-metadata-id", "Include metadata item ID.").Short('m').Bool()
 	snapshotListShowHashCache     = snapshotListCommand.Flag("show-hashcache", "Include hashcache object ID.").Bool()
+	snapshotListShowErrors        = snapshotListCommand.Flag("show-errors", "Include files and directories, which couldn't be read.").Short('e').Bool()
 	maxResultsPerPath             = snapshotListCommand.Flag("max-results", "Maximum number of results.").Default("100").Int()
 )
 
@@ -130,6 +131,20 @@ func runBackupsCommand(context *kingpin.ParseContext) error {
 			if *snapshotListShowHashCache {
 				fmt.Printf("    hashcache: %v\n", m.HashCacheID)
 			}
+			if m.Stats.ReadErrors > 0 {
+				fmt.Printf("    errors:    %v entries couldn't be read\n", m.Stats.ReadErrors)
+			}
+			if m.Stats.InconsistentFiles > 0 {
+				fmt.Printf("    warning:   %v files changed while being read and may be inconsistent\n", m.Stats.InconsistentFiles)
+			}
+			if *snapshotListShowErrors {
+				for _, e := range m.EntryErrors {
+					fmt.Printf("      %v (%v): %v\n", e.Path, e.Category, e.Error)
+				}
+				if n := m.Stats.ReadErrors - len(m.EntryErrors); n > 0 {
+					fmt.Printf("      ... and %v more\n", n)
+				}
+			}
 			count++
 		}
 
@@ -25,11 +25,13 @@ var (
 	traceObjectManager = app.Flag("trace-object-manager", "Enables tracing of object manager operations.").Hidden().Envar("KOPIA_TRACE_OBJECT_MANAGER").Bool()
 	traceLocalFS       = app.Flag("trace-localfs", "Enables tracing of local filesystem operations").Hidden().Envar("KOPIA_TRACE_FS").Bool()
 
-	configPath   = app.Flag("config-file", "Specify the config file to use.").PlaceHolder("PATH").Envar("KOPIA_CONFIG_PATH").String()
-	password     = app.Flag("password", "Repository password.").Envar("KOPIA_PASSWORD").Short('p').String()
-	passwordFile = app.Flag("passwordfile", "Read repository password from a file.").PlaceHolder("FILENAME").Envar("KOPIA_PASSWORD_FILE").ExistingFile()
-	key          = app.Flag("key", "Specify master key (hexadecimal).").Envar("KOPIA_KEY").Short('k').String()
-	keyFile      = app.Flag("keyfile", "Read master key from file.").PlaceHolder("FILENAME").Envar("KOPIA_KEY_FILE").ExistingFile()
+	configPath         = app.Flag("config-file", "Specify the config file to use.").PlaceHolder("PATH").Envar("KOPIA_CONFIG_PATH").String()
+	password           = app.Flag("password", "Repository password.").Envar("KOPIA_PASSWORD").Short('p').String()
+	privilegedPassword = app.Flag("privileged-password", "Privileged repository password, which bypasses write-once restrictions.").Envar("KOPIA_PRIVILEGED_PASSWORD").String()
+	passwordFile       = app.Flag("passwordfile", "Read repository password from a file.").PlaceHolder("FILENAME").Envar("KOPIA_PASSWORD_FILE").ExistingFile()
+	key                = app.Flag("key", "Specify master key (hexadecimal).").Envar("KOPIA_KEY").Short('k').String()
+	keyFile            = app.Flag("keyfile", "Read master key from file.").PlaceHolder("FILENAME").Envar("KOPIA_KEY_FILE").ExistingFile()
+	enableHooks        = app.Flag("enable-hooks", "Run hook commands from policies, which can be modified by anyone with access to the repository.").Envar("KOPIA_ENABLE_HOOKS").Bool()
 )
 
 func failOnError(err error) {
@@ -39,21 +41,35 @@ func failOnError(err error) {
 	}
 }
 
+var rootContext, cancelRootContext = context.WithCancel(context.Background())
+
+// onCtrlC invokes the provided function when the user presses Ctrl-C for the first time,
+// which is expected to stop the command gracefully. Pressing Ctrl-C again cancels the
+// root context, which aborts all in-flight storage operations.
 func onCtrlC(f func()) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	go func() {
 		<-c
 		f()
+		<-c
+		log.Printf("Interrupted again, aborting pending storage operations.")
+		cancelRootContext()
 	}()
 }
 
 func getContext() context.Context {
-	return context.Background()
+	return rootContext
 }
 
 func openRepository(opts *repo.Options) (*repo.Repository, error) {
-	return repo.Open(getContext(), repositoryConfigFileName(), applyOptionsFromFlags(opts))
+	rep, err := repo.Open(getContext(), repositoryConfigFileName(), applyOptionsFromFlags(opts))
+	if err != nil {
+		return nil, err
+	}
+
+	startMetricsServer(rep)
+	return rep, nil
 }
 
 func applyOptionsFromFlags(opts *repo.Options) *repo.Options {
@@ -70,6 +86,14 @@ func applyOptionsFromFlags(opts *repo.Options) *repo.Options {
 		opts.TraceObjectManager = log.Printf
 	}
 
+	if *metricsListenAddr != "" {
+		opts.StorageMetrics = storageMetrics
+	}
+
+	privilegedCreds, err := getPrivilegedCredentials()
+	failOnError(err)
+	opts.PrivilegedCredentials = privilegedCreds
+
 	return opts
 }
 
@@ -155,17 +179,32 @@ func getRepositoryCredentials(isNew bool) (auth.Credentials, error) {
 	}
 }
 
+// getPrivilegedCredentials returns credentials bypassing write-once restrictions or nil if not provided.
+func getPrivilegedCredentials() (auth.Credentials, error) {
+	if *privilegedPassword == "" {
+		return nil, nil
+	}
+
+	return auth.Password(strings.TrimSpace(*privilegedPassword))
+}
+
 func mustGetLocalFSEntry(path string) fs.Entry {
+	e, err := getLocalFSEntry(path)
+	failOnError(err)
+	return e
+}
+
+func getLocalFSEntry(path string) (fs.Entry, error) {
 	e, err := localfs.NewEntry(path, nil)
-	if err == nil {
-		failOnError(err)
+	if err != nil {
+		return nil, err
 	}
 
 	if *traceLocalFS {
-		return loggingfs.Wrap(e, loggingfs.Prefix("[LOCALFS] "))
+		return loggingfs.Wrap(e, loggingfs.Prefix("[LOCALFS] ")), nil
 	}
 
-	return e
+	return e, nil
 }
 
 func askPass(prompt string) (string, error) {
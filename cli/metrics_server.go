@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/kopia/kopia/blob/metrics"
+	"github.com/kopia/kopia/repo"
+)
+
+var (
+	metricsListenAddr = app.Flag("metrics-listen-addr", "Expose storage and repository metrics in Prometheus format on the specified address.").PlaceHolder("HOST:PORT").Envar("KOPIA_METRICS_LISTEN_ADDR").String()
+
+	storageMetrics = metrics.NewCollector()
+)
+
+// startMetricsServer starts serving metrics of the provided repository at /metrics
+// if --metrics-listen-addr has been specified.
+func startMetricsServer(rep *repo.Repository) {
+	if *metricsListenAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		storageMetrics.WritePrometheus(w, "kopia_storage")
+		writeRepositoryStats(w, "kopia_repository", rep.Stats())
+	})
+
+	go func() {
+		if err := http.ListenAndServe(*metricsListenAddr, mux); err != nil {
+			log.Printf("warning: unable to serve metrics on %v: %v", *metricsListenAddr, err)
+		}
+	}()
+}
+
+// writeRepositoryStats writes all numeric fields of repo.Stats as Prometheus gauges named
+// after their JSON names (e.g. 'readBytes' becomes '<prefix>_read_bytes').
+func writeRepositoryStats(w io.Writer, prefix string, stats repo.Stats) {
+	v := reflect.ValueOf(stats)
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		jsonName := strings.Split(f.Tag.Get("json"), ",")[0]
+		if jsonName == "" {
+			continue
+		}
+
+		name := prefix + "_" + snakeCase(jsonName)
+		fmt.Fprintf(w, "# TYPE %v gauge\n", name)
+		fmt.Fprintf(w, "%v %v\n", name, v.Field(i).Int())
+	}
+}
+
+func snakeCase(s string) string {
+	var result []rune
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			result = append(result, '_', unicode.ToLower(r))
+		} else {
+			result = append(result, r)
+		}
+	}
+
+	return string(result)
+}
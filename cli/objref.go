@@ -7,6 +7,7 @@ import (
 	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/fs/repofs"
 	"github.com/kopia/kopia/repo"
+	"github.com/kopia/kopia/snapshot"
 )
 
 // ParseObjectID interprets the given ID string and returns corresponding repo.ObjectID.
@@ -34,30 +35,55 @@ func parseObjectID(id string, r *repo.Repository) (repo.ObjectID, error) {
 }
 
 func parseNestedObjectID(startingDir fs.Directory, id string) (repo.ObjectID, error) {
+	e, err := findNestedEntry(startingDir, id)
+	if err != nil {
+		return repo.NullObjectID, err
+	}
+
+	return e.(repo.HasObjectID).ObjectID(), nil
+}
+
+// findNestedEntry returns the entry with the specified slash-separated path relative to the starting directory.
+func findNestedEntry(startingDir fs.Directory, id string) (fs.Entry, error) {
 	head, tail := splitHeadTail(id)
 	var current fs.Entry
 	current = startingDir
 	for head != "" {
 		dir, ok := current.(fs.Directory)
 		if !ok {
-			return repo.NullObjectID, fmt.Errorf("entry not found '%v': parent is not a directory", head)
+			return nil, fmt.Errorf("entry not found '%v': parent is not a directory", head)
 		}
 
 		entries, err := dir.Readdir()
 		if err != nil {
-			return repo.NullObjectID, err
+			return nil, err
 		}
 
 		e := entries.FindByName(head)
 		if e == nil {
-			return repo.NullObjectID, fmt.Errorf("entry not found: '%v'", head)
+			return nil, fmt.Errorf("entry not found: '%v'", head)
 		}
 
 		current = e
 		head, tail = splitHeadTail(tail)
 	}
 
-	return current.(repo.HasObjectID).ObjectID(), nil
+	return current, nil
+}
+
+// snapshotRoot returns the root entry of the snapshot, which is a directory or a file, such as data streamed from standard input.
+// Manifests don't record metadata of root files, so it's derived from the snapshot.
+func snapshotRoot(rep *repo.Repository, m *snapshot.Manifest) fs.Entry {
+	if m.RootEntryType() != fs.EntryTypeFile {
+		return repofs.Directory(rep, m.RootObjectID)
+	}
+
+	return repofs.File(rep, m.RootObjectID, fs.EntryMetadata{
+		Name:        m.RootObjectID.String(),
+		Permissions: 0644,
+		ModTime:     m.StartTime,
+		FileSize:    m.Stats.TotalFileSize,
+	})
 }
 
 func splitHeadTail(id string) (string, string) {
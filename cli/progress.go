@@ -13,7 +13,9 @@ import (
 type uploadProgress struct {
 	currentDir string
 
-	bar *pb.ProgressBar
+	// progress bar of a single file, other files uploaded concurrently are not displayed
+	bar     *pb.ProgressBar
+	barPath string
 }
 
 func (p *uploadProgress) Cached(path string, length int64) {
@@ -34,6 +36,11 @@ func (p *uploadProgress) Started(path string, length int64) {
 		log.Printf("Processing directory: %v", dir)
 	}
 
+	if p.bar != nil {
+		return
+	}
+
+	p.barPath = path
 	p.bar = pb.New64(length).Prefix("  " + filepath.Base(path))
 	p.bar.SetRefreshRate(time.Second)
 	p.bar.ShowSpeed = true
@@ -43,7 +50,7 @@ func (p *uploadProgress) Started(path string, length int64) {
 }
 
 func (p *uploadProgress) Finished(path string, length int64, err error) {
-	if p.bar != nil {
+	if p.bar != nil && p.barPath == path {
 		p.bar.Finish()
 		p.bar = nil
 	}
@@ -52,7 +59,9 @@ func (p *uploadProgress) Finished(path string, length int64, err error) {
 
 func (p *uploadProgress) Progress(path string, completed, total int64) {
 	//log.Printf("PROGRESS %v %v/%v", path, units.BytesString(completed), units.BytesString(total))
-	p.bar.Set64(completed)
+	if p.bar != nil && p.barPath == path {
+		p.bar.Set64(completed)
+	}
 }
 
 var up snapshot.UploadProgress = &uploadProgress{}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"sort"
 	"strings"
+	"time"
 )
 
 // Entry represents a filesystem entry, which can be Directory, File, or Symlink
@@ -39,6 +40,20 @@ type Symlink interface {
 	Readlink() (string, error)
 }
 
+// LocalEntry is implemented by entries backed by a local filesystem, which can report details
+// used to detect changes without reading their contents.
+type LocalEntry interface {
+	LocalPath() string
+	LocalIdentity() LocalIdentity
+}
+
+// LocalIdentity contains details identifying a local filesystem entry, inode and change time change whenever its contents might have changed.
+type LocalIdentity struct {
+	Device     uint64
+	Inode      uint64
+	ChangeTime time.Time
+}
+
 // FindByName returns an entry with a given name, or nil if not found.
 func (e Entries) FindByName(n string) Entry {
 	i := sort.Search(
@@ -26,11 +26,12 @@ func (e sortedEntries) Less(i, j int) bool {
 type filesystemEntry struct {
 	parent   fs.Directory
 	metadata *fs.EntryMetadata
+	identity fs.LocalIdentity
 	path     string
 }
 
-func newEntry(md *fs.EntryMetadata, parent fs.Directory, path string) filesystemEntry {
-	return filesystemEntry{parent, md, path}
+func newEntry(fi os.FileInfo, parent fs.Directory, path string) filesystemEntry {
+	return filesystemEntry{parent, entryMetadataFromFileInfo(fi, path), localIdentityFromFileInfo(fi), path}
 }
 
 func (e *filesystemEntry) Parent() fs.Directory {
@@ -41,6 +42,14 @@ func (e *filesystemEntry) Metadata() *fs.EntryMetadata {
 	return e.metadata
 }
 
+func (e *filesystemEntry) LocalPath() string {
+	return e.path
+}
+
+func (e *filesystemEntry) LocalIdentity() fs.LocalIdentity {
+	return e.identity
+}
+
 type filesystemDirectory struct {
 	filesystemEntry
 }
@@ -53,6 +62,11 @@ type filesystemFile struct {
 	filesystemEntry
 }
 
+// filesystemSpecial is a device, named pipe or socket, which is described only by its metadata.
+type filesystemSpecial struct {
+	filesystemEntry
+}
+
 func (fsd *filesystemDirectory) Readdir() (fs.Entries, error) {
 	f, err := os.Open(fsd.path)
 	if err != nil {
@@ -95,7 +109,7 @@ func (erc *fileWithMetadata) EntryMetadata() (*fs.EntryMetadata, error) {
 	if err != nil {
 		return nil, err
 	}
-	return entryMetadataFromFileInfo(fi), nil
+	return entryMetadataFromFileInfo(fi, erc.Name()), nil
 }
 
 func (fsf *filesystemFile) Open() (fs.Reader, error) {
@@ -111,7 +125,8 @@ func (fsl *filesystemSymlink) Readlink() (string, error) {
 	return os.Readlink(fsl.path)
 }
 
-// NewEntry returns fs.Entry for the specified path, the result will be one of supported entry types: fs.File, fs.Directory, fs.Symlink.
+// NewEntry returns fs.Entry for the specified path, the result will be one of supported entry types: fs.File, fs.Directory, fs.Symlink
+// or a plain fs.Entry describing a device, named pipe or socket.
 func NewEntry(path string, parent fs.Directory) (fs.Entry, error) {
 	fi, err := os.Lstat(path)
 	if err != nil {
@@ -137,7 +152,7 @@ func Directory(path string, parent fs.Directory) (fs.Directory, error) {
 	}
 }
 
-func entryMetadataFromFileInfo(fi os.FileInfo) *fs.EntryMetadata {
+func entryMetadataFromFileInfo(fi os.FileInfo, path string) *fs.EntryMetadata {
 	e := &fs.EntryMetadata{
 		Name:        filepath.Base(fi.Name()),
 		Type:        entryTypeFromFileMode(fi.Mode() & os.ModeType),
@@ -147,9 +162,19 @@ func entryMetadataFromFileInfo(fi os.FileInfo) *fs.EntryMetadata {
 
 	if fi.Mode().IsRegular() {
 		e.FileSize = fi.Size()
+
+		if err := readHoles(e, fi, path); err != nil {
+			log.Printf("warning: unable to find holes in %v: %v", path, err)
+		}
 	}
 
 	populatePlatformSpecificEntryDetails(e, fi)
+
+	// Missing extended attributes don't prevent the entry from being backed up.
+	if err := readExtendedAttributes(e, path); err != nil {
+		log.Printf("warning: unable to read extended attributes of %v: %v", path, err)
+	}
+
 	return e
 }
 
@@ -164,6 +189,18 @@ func entryTypeFromFileMode(t os.FileMode) fs.EntryType {
 	case os.ModeDir:
 		return fs.EntryTypeDirectory
 
+	case os.ModeDevice:
+		return fs.EntryTypeBlockDevice
+
+	case os.ModeDevice | os.ModeCharDevice:
+		return fs.EntryTypeCharDevice
+
+	case os.ModeNamedPipe:
+		return fs.EntryTypeNamedPipe
+
+	case os.ModeSocket:
+		return fs.EntryTypeSocket
+
 	default:
 		panic("unsupported file mode: " + t.String())
 	}
@@ -172,13 +209,16 @@ func entryTypeFromFileMode(t os.FileMode) fs.EntryType {
 func entryFromFileInfo(fi os.FileInfo, path string, parent fs.Directory) (fs.Entry, error) {
 	switch fi.Mode() & os.ModeType {
 	case os.ModeDir:
-		return &filesystemDirectory{newEntry(entryMetadataFromFileInfo(fi), parent, path)}, nil
+		return &filesystemDirectory{newEntry(fi, parent, path)}, nil
 
 	case os.ModeSymlink:
-		return &filesystemSymlink{newEntry(entryMetadataFromFileInfo(fi), parent, path)}, nil
+		return &filesystemSymlink{newEntry(fi, parent, path)}, nil
 
 	case 0:
-		return &filesystemFile{newEntry(entryMetadataFromFileInfo(fi), parent, path)}, nil
+		return &filesystemFile{newEntry(fi, parent, path)}, nil
+
+	case os.ModeDevice, os.ModeDevice | os.ModeCharDevice, os.ModeNamedPipe, os.ModeSocket:
+		return &filesystemSpecial{newEntry(fi, parent, path)}, nil
 
 	default:
 		return nil, fmt.Errorf("unsupported filesystem entry: %v", path)
@@ -188,3 +228,5 @@ func entryFromFileInfo(fi os.FileInfo, path string, parent fs.Directory) (fs.Ent
 var _ fs.Directory = &filesystemDirectory{}
 var _ fs.File = &filesystemFile{}
 var _ fs.Symlink = &filesystemSymlink{}
+var _ fs.LocalEntry = &filesystemFile{}
+var _ fs.LocalEntry = &filesystemSpecial{}
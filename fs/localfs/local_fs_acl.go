@@ -0,0 +1,146 @@
+package localfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// POSIX ACLs are stored by Linux in extended attributes as a version header followed by entries consisting
+// of a tag, permissions and user or group ID.
+const (
+	aclVersion     = 2
+	aclHeaderSize  = 4
+	aclEntrySize   = 8
+	aclUndefinedID = 0xffffffff
+)
+
+var aclTags = []struct {
+	tag       uint16
+	name      string
+	qualified bool // entry applies to the user or group with the specified ID rather than to the owner, owning group or others
+}{
+	{0x01, "user", false},
+	{0x02, "user", true},
+	{0x04, "group", false},
+	{0x08, "group", true},
+	{0x10, "mask", false},
+	{0x20, "other", false},
+}
+
+// formatACL converts the ACL from its extended attribute representation to short text form.
+func formatACL(b []byte) (string, error) {
+	if len(b) < aclHeaderSize || (len(b)-aclHeaderSize)%aclEntrySize != 0 {
+		return "", fmt.Errorf("invalid ACL length: %v", len(b))
+	}
+
+	if v := binary.LittleEndian.Uint32(b); v != aclVersion {
+		return "", fmt.Errorf("unsupported ACL version: %v", v)
+	}
+
+	var entries []string
+	for p := b[aclHeaderSize:]; len(p) > 0; p = p[aclEntrySize:] {
+		tag := binary.LittleEndian.Uint16(p)
+		perm := binary.LittleEndian.Uint16(p[2:])
+		id := binary.LittleEndian.Uint32(p[4:])
+
+		var name string
+		for _, t := range aclTags {
+			if t.tag == tag {
+				name = t.name + ":"
+				if t.qualified {
+					name += strconv.FormatUint(uint64(id), 10)
+				}
+			}
+		}
+
+		if name == "" {
+			return "", fmt.Errorf("unsupported ACL tag: %v", tag)
+		}
+
+		entries = append(entries, name+":"+formatACLPermissions(perm))
+	}
+
+	return strings.Join(entries, ","), nil
+}
+
+// parseACL converts the ACL from short text form to its extended attribute representation.
+func parseACL(s string) ([]byte, error) {
+	b := make([]byte, aclHeaderSize)
+	binary.LittleEndian.PutUint32(b, aclVersion)
+
+	for _, e := range strings.Split(s, ",") {
+		parts := strings.Split(e, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid ACL entry: %q", e)
+		}
+
+		perm, err := parseACLPermissions(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACL entry: %q", e)
+		}
+
+		var tag uint16
+		var id uint32 = aclUndefinedID
+		for _, t := range aclTags {
+			if t.name != parts[0] || t.qualified != (parts[1] != "") {
+				continue
+			}
+
+			tag = t.tag
+			if t.qualified {
+				v, err := strconv.ParseUint(parts[1], 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("invalid ACL entry: %q", e)
+				}
+
+				id = uint32(v)
+			}
+		}
+
+		if tag == 0 {
+			return nil, fmt.Errorf("invalid ACL entry: %q", e)
+		}
+
+		var entry [aclEntrySize]byte
+		binary.LittleEndian.PutUint16(entry[0:], tag)
+		binary.LittleEndian.PutUint16(entry[2:], perm)
+		binary.LittleEndian.PutUint32(entry[4:], id)
+		b = append(b, entry[:]...)
+	}
+
+	return b, nil
+}
+
+const aclPermissionChars = "rwx"
+
+func formatACLPermissions(perm uint16) string {
+	b := []byte("---")
+	for i := range b {
+		if perm&(4>>uint(i)) != 0 {
+			b[i] = aclPermissionChars[i]
+		}
+	}
+
+	return string(b)
+}
+
+func parseACLPermissions(s string) (uint16, error) {
+	if len(s) != len(aclPermissionChars) {
+		return 0, fmt.Errorf("invalid permissions: %q", s)
+	}
+
+	var perm uint16
+	for i := range s {
+		switch s[i] {
+		case aclPermissionChars[i]:
+			perm |= 4 >> uint(i)
+		case '-':
+		default:
+			return 0, fmt.Errorf("invalid permissions: %q", s)
+		}
+	}
+
+	return perm, nil
+}
@@ -0,0 +1,12 @@
+// +build linux openbsd solaris
+
+package localfs
+
+import (
+	"syscall"
+	"time"
+)
+
+func statChangeTime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec))
+}
@@ -0,0 +1,12 @@
+// +build darwin freebsd netbsd dragonfly
+
+package localfs
+
+import (
+	"syscall"
+	"time"
+)
+
+func statChangeTime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(int64(stat.Ctimespec.Sec), int64(stat.Ctimespec.Nsec))
+}
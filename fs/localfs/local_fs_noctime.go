@@ -0,0 +1,12 @@
+// +build !windows,!linux,!openbsd,!solaris,!darwin,!freebsd,!netbsd,!dragonfly
+
+package localfs
+
+import (
+	"syscall"
+	"time"
+)
+
+func statChangeTime(stat *syscall.Stat_t) time.Time {
+	return time.Time{}
+}
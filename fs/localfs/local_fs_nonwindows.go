@@ -3,17 +3,44 @@
 package localfs
 
 import (
+	"fmt"
 	"os"
 	"syscall"
 
 	"github.com/kopia/kopia/fs"
+
+	"golang.org/x/sys/unix"
 )
 
 func populatePlatformSpecificEntryDetails(e *fs.EntryMetadata, fi os.FileInfo) error {
 	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
 		e.UserID = stat.Uid
 		e.GroupID = stat.Gid
+
+		if e.Type == fs.EntryTypeBlockDevice || e.Type == fs.EntryTypeCharDevice {
+			e.Device = &fs.DeviceNumber{
+				Major: unix.Major(uint64(stat.Rdev)),
+				Minor: unix.Minor(uint64(stat.Rdev)),
+			}
+		}
+
+		// Links to the same file share the device and inode number.
+		if e.Type == fs.EntryTypeFile && stat.Nlink > 1 {
+			e.HardLinkID = fmt.Sprintf("%x:%x", uint64(stat.Dev), uint64(stat.Ino))
+		}
 	}
 
 	return nil
 }
+
+func localIdentityFromFileInfo(fi os.FileInfo) fs.LocalIdentity {
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return fs.LocalIdentity{
+			Device:     uint64(stat.Dev),
+			Inode:      uint64(stat.Ino),
+			ChangeTime: statChangeTime(stat),
+		}
+	}
+
+	return fs.LocalIdentity{}
+}
@@ -0,0 +1,199 @@
+package localfs
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/kopia/kopia/fs"
+)
+
+// RestoreDirectory recreates entries of the directory along with their metadata in the local directory with the specified path,
+// which is created if it doesn't exist. Existing subdirectories are merged, but other existing entries are never overwritten.
+// Files sharing HardLinkID are restored as hard links to the same file.
+//
+// Ownership is restored only when running as root. Failures to restore extended attributes, ACLs and device nodes,
+// which usually require privileges or filesystem support, are logged but don't stop the restore.
+func RestoreDirectory(d fs.Directory, path string) error {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return err
+	}
+
+	r := &restorer{hardLinks: map[string]string{}}
+	return r.restoreEntries(d, path)
+}
+
+// RestoreFile recreates the file along with its metadata at the specified path, which must not exist.
+func RestoreFile(f fs.File, path string) error {
+	md := f.Metadata()
+	if err := restoreFile(f, md, path); err != nil {
+		return fmt.Errorf("unable to restore file %v: %v", path, err)
+	}
+
+	return restoreMetadata(path, md)
+}
+
+type restorer struct {
+	hardLinks map[string]string // path of the first restored file of each group of hard links, keyed by HardLinkID
+}
+
+func (r *restorer) restoreEntries(d fs.Directory, path string) error {
+	entries, err := d.Readdir()
+	if err != nil {
+		return fmt.Errorf("unable to read directory %v: %v", fs.EntryPath(d), err)
+	}
+
+	for _, e := range entries {
+		if err := r.restoreEntry(e, filepath.Join(path, e.Metadata().Name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *restorer) restoreEntry(e fs.Entry, path string) error {
+	md := e.Metadata()
+
+	switch e := e.(type) {
+	case fs.Directory:
+		if err := os.Mkdir(path, 0700); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("unable to create directory %v: %v", path, err)
+		}
+
+		// Contents are restored first, so that read-only directories can be populated and their modification time is retained.
+		if err := r.restoreEntries(e, path); err != nil {
+			return err
+		}
+
+	case fs.File:
+		if id := md.HardLinkID; id != "" {
+			// Links share contents and metadata, which have been restored with the first one.
+			if existing, ok := r.hardLinks[id]; ok {
+				if err := os.Link(existing, path); err != nil {
+					return fmt.Errorf("unable to create hard link %v: %v", path, err)
+				}
+
+				return nil
+			}
+
+			r.hardLinks[id] = path
+		}
+
+		if err := restoreFile(e, md, path); err != nil {
+			return fmt.Errorf("unable to restore file %v: %v", path, err)
+		}
+
+	case fs.Symlink:
+		target, err := e.Readlink()
+		if err != nil {
+			return fmt.Errorf("unable to read symlink %v: %v", fs.EntryPath(e), err)
+		}
+
+		if err := os.Symlink(target, path); err != nil {
+			return fmt.Errorf("unable to create symlink %v: %v", path, err)
+		}
+
+	default:
+		if !md.Type.IsSpecial() {
+			return fmt.Errorf("unsupported entry type %q: %v", md.Type, fs.EntryPath(e))
+		}
+
+		if err := createSpecialFile(path, md); err != nil {
+			if os.IsPermission(err) {
+				log.Printf("warning: unable to create %v: %v", path, err)
+				return nil
+			}
+
+			return fmt.Errorf("unable to create %v: %v", path, err)
+		}
+	}
+
+	return restoreMetadata(path, md)
+}
+
+func restoreFile(f fs.File, md *fs.EntryMetadata, path string) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if s, ok := r.(io.Seeker); ok && len(md.Holes) > 0 {
+		err = copySparse(w, r, s, md)
+	} else {
+		_, err = io.Copy(w, r)
+	}
+
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// copySparse copies data of the sparse file, seeking over its holes, which are left unallocated in the restored file.
+func copySparse(w *os.File, r io.Reader, s io.Seeker, md *fs.EntryMetadata) error {
+	var pos int64
+	for _, h := range md.Holes {
+		if _, err := io.CopyN(w, r, h.Offset-pos); err != nil {
+			return err
+		}
+
+		pos = h.Offset + h.Length
+		if _, err := s.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+
+		if _, err := w.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+
+	// Trailing hole is created by extending the file.
+	return w.Truncate(md.FileSize)
+}
+
+// restoreMetadata applies metadata to the restored entry. Permission bits are set after changing ownership, which clears
+// some of them, and ACLs are set after permission bits, which they override. Modification times of symlinks are not restored.
+func restoreMetadata(path string, md *fs.EntryMetadata) error {
+	if err := writeExtendedAttributes(path, md); err != nil {
+		log.Printf("warning: unable to restore extended attributes of %v: %v", path, err)
+	}
+
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(path, int(md.UserID), int(md.GroupID)); err != nil {
+			return fmt.Errorf("unable to change owner of %v: %v", path, err)
+		}
+	}
+
+	if md.Type == fs.EntryTypeSymlink {
+		return nil
+	}
+
+	if err := os.Chmod(path, os.FileMode(md.Permissions)&os.ModePerm); err != nil {
+		return fmt.Errorf("unable to change permissions of %v: %v", path, err)
+	}
+
+	if err := writeACLs(path, md); err != nil {
+		log.Printf("warning: unable to restore ACLs of %v: %v", path, err)
+	}
+
+	if err := os.Chtimes(path, md.ModTime, md.ModTime); err != nil {
+		return fmt.Errorf("unable to change modification time of %v: %v", path, err)
+	}
+
+	return nil
+}
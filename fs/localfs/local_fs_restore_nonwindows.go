@@ -0,0 +1,32 @@
+// +build !windows
+
+package localfs
+
+import (
+	"github.com/kopia/kopia/fs"
+
+	"golang.org/x/sys/unix"
+)
+
+// createSpecialFile creates a device, named pipe or socket described by the metadata.
+func createSpecialFile(path string, md *fs.EntryMetadata) error {
+	mode := uint32(md.Permissions)
+
+	switch md.Type {
+	case fs.EntryTypeBlockDevice:
+		mode |= unix.S_IFBLK
+	case fs.EntryTypeCharDevice:
+		mode |= unix.S_IFCHR
+	case fs.EntryTypeNamedPipe:
+		mode |= unix.S_IFIFO
+	case fs.EntryTypeSocket:
+		mode |= unix.S_IFSOCK
+	}
+
+	var dev uint64
+	if md.Device != nil {
+		dev = unix.Mkdev(md.Device.Major, md.Device.Minor)
+	}
+
+	return unix.Mknod(path, mode, int(dev))
+}
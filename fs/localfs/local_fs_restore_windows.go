@@ -0,0 +1,11 @@
+package localfs
+
+import (
+	"fmt"
+
+	"github.com/kopia/kopia/fs"
+)
+
+func createSpecialFile(path string, md *fs.EntryMetadata) error {
+	return fmt.Errorf("entries of type %q are not supported on this platform", md.Type)
+}
@@ -0,0 +1,58 @@
+// +build linux
+
+package localfs
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/kopia/kopia/fs"
+
+	"golang.org/x/sys/unix"
+)
+
+// readHoles populates holes of the regular file with the specified path.
+func readHoles(e *fs.EntryMetadata, fi os.FileInfo, path string) error {
+	// Files, which have all their blocks allocated, have no holes and don't need to be opened.
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || int64(stat.Blocks)*512 >= fi.Size() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		// Files, which can't be opened, are reported when they're read.
+		return nil
+	}
+	defer f.Close()
+
+	fd := int(f.Fd())
+	size := fi.Size()
+
+	var holes []fs.Hole
+	for offset := int64(0); offset < size; {
+		// Filesystems, which don't support finding holes, report the entire file as data.
+		hole, err := unix.Seek(fd, offset, unix.SEEK_HOLE)
+		if err != nil {
+			return err
+		}
+
+		if hole >= size {
+			break
+		}
+
+		data, err := unix.Seek(fd, hole, unix.SEEK_DATA)
+		if err == unix.ENXIO || (err == nil && data > size) {
+			// The file ends with a hole.
+			data = size
+		} else if err != nil {
+			return err
+		}
+
+		holes = append(holes, fs.Hole{Offset: hole, Length: data - hole})
+		offset = data
+	}
+
+	e.Holes = holes
+	return nil
+}
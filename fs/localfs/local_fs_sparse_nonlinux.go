@@ -0,0 +1,13 @@
+// +build !linux
+
+package localfs
+
+import (
+	"os"
+
+	"github.com/kopia/kopia/fs"
+)
+
+func readHoles(e *fs.EntryMetadata, fi os.FileInfo, path string) error {
+	return nil
+}
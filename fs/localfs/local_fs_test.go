@@ -1,10 +1,12 @@
 package localfs
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/kopia/kopia/fs"
@@ -85,3 +87,230 @@ func TestFiles(t *testing.T) {
 		t.Errorf("invalid dir data:\n%v", dir)
 	}
 }
+
+func TestACL(t *testing.T) {
+	cases := []string{
+		"user::rw-,group::r--,other::---",
+		"user::rwx,user:1000:r-x,group::r--,group:20:-w-,mask::rwx,other::--x",
+	}
+
+	for _, tc := range cases {
+		b, err := parseACL(tc)
+		if err != nil {
+			t.Errorf("unable to parse %q: %v", tc, err)
+			continue
+		}
+
+		if len(b) != aclHeaderSize+aclEntrySize*len(strings.Split(tc, ",")) {
+			t.Errorf("unexpected length of %q: %v", tc, len(b))
+		}
+
+		s, err := formatACL(b)
+		if err != nil || s != tc {
+			t.Errorf("unexpected ACL after round trip: %q %v, expected %q", s, err, tc)
+		}
+	}
+
+	for _, tc := range []string{"", "user:rw-", "user:x:rw-", "mask:1:rw-", "other::rwz", "owner::rw-"} {
+		if _, err := parseACL(tc); err == nil {
+			t.Errorf("expected error when parsing %q", tc)
+		}
+	}
+
+	if _, err := formatACL([]byte{2, 0, 0, 0, 1}); err == nil {
+		t.Errorf("expected error when formatting truncated ACL")
+	}
+}
+
+func TestRestoreDirectory(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "kopia")
+	if err != nil {
+		t.Fatalf("cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "src")
+	dst := filepath.Join(tmp, "dst")
+	modTime := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	os.MkdirAll(filepath.Join(src, "dir"), 0700)
+	ioutil.WriteFile(filepath.Join(src, "dir", "file"), []byte{1, 2, 3}, 0640)
+	os.Symlink("dir/file", filepath.Join(src, "link"))
+	os.Link(filepath.Join(src, "dir", "file"), filepath.Join(src, "hardlink"))
+
+	// Sparse file starting and ending with holes.
+	sparse, err := os.Create(filepath.Join(src, "sparse"))
+	if err != nil {
+		t.Fatalf("unable to create sparse file: %v", err)
+	}
+	sparse.WriteAt([]byte{1, 2, 3}, 1<<20)
+	sparse.WriteAt([]byte{4, 5, 6}, 3<<20)
+	sparse.Truncate(5 << 20)
+	sparse.Close()
+
+	// Special files are verified only if they can be created, devices usually require root privileges.
+	special := map[string]*fs.EntryMetadata{
+		"fifo": {Type: fs.EntryTypeNamedPipe, Permissions: 0600},
+		"null": {Type: fs.EntryTypeCharDevice, Permissions: 0600, Device: &fs.DeviceNumber{Major: 1, Minor: 3}},
+	}
+	for name, md := range special {
+		if err := createSpecialFile(filepath.Join(src, name), md); err != nil {
+			delete(special, name)
+		}
+	}
+
+	// Extended attributes and ACLs are verified only if supported by the platform and filesystem.
+	xattrs := &fs.EntryMetadata{
+		ExtendedAttributes: map[string][]byte{"user.kopia": []byte("value")},
+		ACL:                "user::rw-,user:1000:r--,group::r--,mask::r--,other::---",
+	}
+	if writeExtendedAttributes(filepath.Join(src, "dir", "file"), xattrs) != nil {
+		xattrs.ExtendedAttributes = nil
+	}
+	if writeACLs(filepath.Join(src, "dir", "file"), xattrs) != nil {
+		xattrs.ACL = ""
+	}
+
+	os.Chtimes(filepath.Join(src, "dir", "file"), modTime, modTime)
+	os.Chtimes(filepath.Join(src, "dir"), modTime, modTime)
+
+	srcDir, err := Directory(src, nil)
+	if err != nil {
+		t.Fatalf("unable to list source: %v", err)
+	}
+
+	if err := RestoreDirectory(srcDir, dst); err != nil {
+		t.Fatalf("unable to restore: %v", err)
+	}
+
+	dstDir, err := Directory(dst, nil)
+	if err != nil {
+		t.Fatalf("unable to list restored directory: %v", err)
+	}
+
+	restored := getEntries(t, dstDir)
+	if len(restored) != 4+len(special) {
+		t.Errorf("unexpected restored entries: %v", restored)
+	}
+
+	for name, expected := range special {
+		e := restored[name]
+		if e == nil {
+			t.Errorf("%v not restored", name)
+			continue
+		}
+
+		if md := e.Metadata(); md.Type != expected.Type || md.Permissions != expected.Permissions || (md.Device == nil) != (expected.Device == nil) ||
+			(md.Device != nil && *md.Device != *expected.Device) {
+			t.Errorf("unexpected restored %v: %+v", name, md)
+		}
+	}
+
+	if target, err := restored["link"].(fs.Symlink).Readlink(); err != nil || target != "dir/file" {
+		t.Errorf("unexpected restored symlink: %v %v", target, err)
+	}
+
+	dir := restored["dir"].(fs.Directory)
+	if md := dir.Metadata(); md.Permissions != 0700 || !md.ModTime.Equal(modTime) {
+		t.Errorf("unexpected restored directory metadata: %+v", md)
+	}
+
+	file := getEntries(t, dir)["file"]
+	md := file.Metadata()
+	if md.Permissions != 0640 || md.FileSize != 3 || !md.ModTime.Equal(modTime) {
+		t.Errorf("unexpected restored file metadata: %+v", md)
+	}
+
+	if string(md.ExtendedAttributes["user.kopia"]) != string(xattrs.ExtendedAttributes["user.kopia"]) || md.ACL != xattrs.ACL {
+		t.Errorf("unexpected restored extended attributes: %v %q, expected %v %q", md.ExtendedAttributes, md.ACL, xattrs.ExtendedAttributes, xattrs.ACL)
+	}
+
+	hardLink := restored["hardlink"].Metadata()
+	if hardLink.HardLinkID == "" || hardLink.HardLinkID != md.HardLinkID {
+		t.Errorf("unexpected hard link IDs: %q and %q", hardLink.HardLinkID, md.HardLinkID)
+	}
+
+	fi1, err1 := os.Stat(filepath.Join(dst, "dir", "file"))
+	fi2, err2 := os.Stat(filepath.Join(dst, "hardlink"))
+	if err1 != nil || err2 != nil || !os.SameFile(fi1, fi2) {
+		t.Errorf("hard link not restored: %v %v", err1, err2)
+	}
+
+	// Holes are found and restored only if supported by the platform and filesystem.
+	sparseEntry, err := NewEntry(filepath.Join(src, "sparse"), nil)
+	if err != nil {
+		t.Fatalf("unable to list sparse file: %v", err)
+	}
+
+	holes := sparseEntry.Metadata().Holes
+	if restoredHoles := restored["sparse"].Metadata().Holes; fmt.Sprint(restoredHoles) != fmt.Sprint(holes) {
+		t.Errorf("unexpected holes of restored sparse file: %v, expected %v", restoredHoles, holes)
+	}
+
+	srcContents, _ := ioutil.ReadFile(filepath.Join(src, "sparse"))
+	dstContents, err := ioutil.ReadFile(filepath.Join(dst, "sparse"))
+	if err != nil || len(dstContents) != 5<<20 || !bytes.Equal(srcContents, dstContents) {
+		t.Errorf("unexpected contents of restored sparse file: %v", err)
+	}
+
+	// Existing files are not overwritten.
+	if err := RestoreDirectory(srcDir, dst); err == nil {
+		t.Errorf("expected error when restoring over existing files")
+	}
+}
+
+func TestRestoreFile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "kopia")
+	if err != nil {
+		t.Fatalf("cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "src")
+	dst := filepath.Join(tmp, "dst")
+	modTime := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	ioutil.WriteFile(src, []byte{1, 2, 3}, 0640)
+	os.Chtimes(src, modTime, modTime)
+
+	srcFile, err := NewEntry(src, nil)
+	if err != nil {
+		t.Fatalf("unable to list source: %v", err)
+	}
+
+	if err := RestoreFile(srcFile.(fs.File), dst); err != nil {
+		t.Fatalf("unable to restore: %v", err)
+	}
+
+	restored, err := NewEntry(dst, nil)
+	if err != nil {
+		t.Fatalf("unable to list restored file: %v", err)
+	}
+
+	if md := restored.Metadata(); md.Type != fs.EntryTypeFile || md.Permissions != 0640 || md.FileSize != 3 || !md.ModTime.Equal(modTime) {
+		t.Errorf("unexpected restored file metadata: %+v", md)
+	}
+
+	if contents, err := ioutil.ReadFile(dst); err != nil || !bytes.Equal(contents, []byte{1, 2, 3}) {
+		t.Errorf("unexpected contents of restored file: %v %v", contents, err)
+	}
+
+	// Existing files are not overwritten.
+	if err := RestoreFile(srcFile.(fs.File), dst); err == nil {
+		t.Errorf("expected error when restoring over existing file")
+	}
+}
+
+func getEntries(t *testing.T, dir fs.Directory) map[string]fs.Entry {
+	entries, err := dir.Readdir()
+	if err != nil {
+		t.Fatalf("unable to read directory: %v", err)
+	}
+
+	result := map[string]fs.Entry{}
+	for _, e := range entries {
+		result[e.Metadata().Name] = e
+	}
+
+	return result
+}
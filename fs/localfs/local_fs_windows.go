@@ -9,3 +9,7 @@ import (
 func populatePlatformSpecificEntryDetails(e *fs.EntryMetadata, fi os.FileInfo) error {
 	return nil
 }
+
+func localIdentityFromFileInfo(fi os.FileInfo) fs.LocalIdentity {
+	return fs.LocalIdentity{}
+}
@@ -0,0 +1,145 @@
+// +build linux
+
+package localfs
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/kopia/kopia/fs"
+
+	"golang.org/x/sys/unix"
+)
+
+// Linux stores POSIX ACLs in extended attributes with these names.
+const (
+	aclAccessAttribute  = "system.posix_acl_access"
+	aclDefaultAttribute = "system.posix_acl_default"
+)
+
+// readExtendedAttributes populates extended attributes and ACLs of the entry with the specified path.
+func readExtendedAttributes(e *fs.EntryMetadata, path string) error {
+	names, err := listExtendedAttributes(path)
+	if err != nil {
+		if err == unix.ENOTSUP {
+			// The filesystem doesn't support extended attributes.
+			return nil
+		}
+
+		return err
+	}
+
+	for _, n := range names {
+		v, err := getExtendedAttribute(path, n)
+		if err == unix.ENODATA {
+			// Removed after being listed.
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("%v: %v", n, err)
+		}
+
+		switch n {
+		case aclAccessAttribute:
+			e.ACL, err = formatACL(v)
+
+		case aclDefaultAttribute:
+			e.DefaultACL, err = formatACL(v)
+
+		default:
+			if e.ExtendedAttributes == nil {
+				e.ExtendedAttributes = map[string][]byte{}
+			}
+			e.ExtendedAttributes[n] = v
+		}
+
+		if err != nil {
+			return fmt.Errorf("%v: %v", n, err)
+		}
+	}
+
+	return nil
+}
+
+// writeExtendedAttributes sets extended attributes of the entry with the specified path, other than ACLs.
+func writeExtendedAttributes(path string, e *fs.EntryMetadata) error {
+	for n, v := range e.ExtendedAttributes {
+		if err := unix.Lsetxattr(path, n, v, 0); err != nil {
+			return fmt.Errorf("%v: %v", n, err)
+		}
+	}
+
+	return nil
+}
+
+// writeACLs sets ACLs of the entry with the specified path. Because they also determine group permission bits,
+// they must be set after permissions.
+func writeACLs(path string, e *fs.EntryMetadata) error {
+	for n, acl := range map[string]string{aclAccessAttribute: e.ACL, aclDefaultAttribute: e.DefaultACL} {
+		if acl == "" {
+			continue
+		}
+
+		v, err := parseACL(acl)
+		if err != nil {
+			return fmt.Errorf("%v: %v", n, err)
+		}
+
+		if err := unix.Lsetxattr(path, n, v, 0); err != nil {
+			return fmt.Errorf("%v: %v", n, err)
+		}
+	}
+
+	return nil
+}
+
+func listExtendedAttributes(path string) ([]string, error) {
+	b, err := readAttributeBuffer(func(dest []byte) (int, error) {
+		return unix.Llistxattr(path, dest)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, n := range bytes.Split(b, []byte{0}) {
+		if len(n) > 0 {
+			names = append(names, string(n))
+		}
+	}
+
+	return names, nil
+}
+
+func getExtendedAttribute(path, name string) ([]byte, error) {
+	return readAttributeBuffer(func(dest []byte) (int, error) {
+		return unix.Lgetxattr(path, name, dest)
+	})
+}
+
+// readAttributeBuffer queries the size of the value and reads it, retrying if it grows in between.
+func readAttributeBuffer(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		sz, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if sz == 0 {
+			return []byte{}, nil
+		}
+
+		b := make([]byte, sz)
+		sz, err = read(b)
+		if err == unix.ERANGE {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return b[0:sz], nil
+	}
+}
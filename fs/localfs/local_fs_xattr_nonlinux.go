@@ -0,0 +1,31 @@
+// +build !linux
+
+package localfs
+
+import (
+	"errors"
+
+	"github.com/kopia/kopia/fs"
+)
+
+var errExtendedAttributesNotSupported = errors.New("extended attributes and ACLs are not supported on this platform")
+
+func readExtendedAttributes(e *fs.EntryMetadata, path string) error {
+	return nil
+}
+
+func writeExtendedAttributes(path string, e *fs.EntryMetadata) error {
+	if len(e.ExtendedAttributes) > 0 {
+		return errExtendedAttributesNotSupported
+	}
+
+	return nil
+}
+
+func writeACLs(path string, e *fs.EntryMetadata) error {
+	if e.ACL != "" || e.DefaultACL != "" {
+		return errExtendedAttributesNotSupported
+	}
+
+	return nil
+}
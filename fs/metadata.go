@@ -16,8 +16,24 @@ const (
 	EntryTypeFile      EntryType = "f" // file
 	EntryTypeDirectory EntryType = "d" // directory
 	EntryTypeSymlink   EntryType = "s" // symbolic link
+
+	EntryTypeBlockDevice EntryType = "b" // block device
+	EntryTypeCharDevice  EntryType = "c" // character device
+	EntryTypeNamedPipe   EntryType = "p" // named pipe (FIFO)
+	EntryTypeSocket      EntryType = "S" // UNIX domain socket
 )
 
+// IsSpecial determines whether the entry type is a device, named pipe or socket, which have no contents.
+func (t EntryType) IsSpecial() bool {
+	switch t {
+	case EntryTypeBlockDevice, EntryTypeCharDevice, EntryTypeNamedPipe, EntryTypeSocket:
+		return true
+
+	default:
+		return false
+	}
+}
+
 // Permissions encapsulates UNIX permissions for a filesystem entry.
 type Permissions int
 
@@ -57,6 +73,36 @@ type EntryMetadata struct {
 	ModTime     time.Time   `json:"mtime,omitempty"`
 	UserID      uint32      `json:"uid,omitempty"`
 	GroupID     uint32      `json:"gid,omitempty"`
+
+	// Device is the device number of block and character devices.
+	Device *DeviceNumber `json:"dev,omitempty"`
+
+	// ExtendedAttributes contains extended attributes of the entry other than ACLs, keyed by their names.
+	ExtendedAttributes map[string][]byte `json:"xattrs,omitempty"`
+
+	// ACL and DefaultACL are POSIX access and default ACLs in short text form (such as "user::rw-,user:1000:r--,group::r--,mask::r--,other::---"),
+	// with users and groups identified by their IDs. They're empty unless the entry has an extended ACL.
+	ACL        string `json:"acl,omitempty"`
+	DefaultACL string `json:"defaultAcl,omitempty"`
+
+	// HardLinkID is shared by files, which are hard links to the same file. It's empty unless the file has multiple links.
+	HardLinkID string `json:"hardLink,omitempty"`
+
+	// Holes lists ranges of a sparse file, which read as zeros and aren't stored, in the order of their offsets.
+	// FileSize includes holes.
+	Holes []Hole `json:"holes,omitempty"`
+}
+
+// Hole is a range of a sparse file, which has no data allocated.
+type Hole struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"len"`
+}
+
+// DeviceNumber identifies a block or character device.
+type DeviceNumber struct {
+	Major uint32 `json:"major"`
+	Minor uint32 `json:"minor"`
 }
 
 // FileMode returns os.FileMode corresponding to Type and Permissions of the entry metadata.
@@ -75,5 +121,17 @@ func (e *EntryMetadata) FileMode() os.FileMode {
 
 	case EntryTypeSymlink:
 		return perm | os.ModeSymlink
+
+	case EntryTypeBlockDevice:
+		return perm | os.ModeDevice
+
+	case EntryTypeCharDevice:
+		return perm | os.ModeDevice | os.ModeCharDevice
+
+	case EntryTypeNamedPipe:
+		return perm | os.ModeNamedPipe
+
+	case EntryTypeSocket:
+		return perm | os.ModeSocket
 	}
 }
@@ -2,7 +2,6 @@ package repofs
 
 import (
 	"fmt"
-	"io"
 	"io/ioutil"
 
 	"github.com/kopia/kopia/fs"
@@ -66,6 +65,11 @@ func (rf *repositoryFile) Open() (fs.Reader, error) {
 		return nil, err
 	}
 
+	// Objects of sparse files contain only their data.
+	if len(rf.metadata.Holes) > 0 {
+		r = newSparseReader(r, rf.metadata.Holes, rf.metadata.FileSize)
+	}
+
 	return withMetadata(r, &rf.metadata.EntryMetadata), nil
 }
 
@@ -100,13 +104,17 @@ func newRepoEntry(r *repo.Repository, md *dir.Entry, parent fs.Directory) fs.Ent
 	case fs.EntryTypeFile:
 		return fs.File(&repositoryFile{re})
 
+	case fs.EntryTypeBlockDevice, fs.EntryTypeCharDevice, fs.EntryTypeNamedPipe, fs.EntryTypeSocket:
+		// Special files have no contents.
+		return fs.Entry(&re)
+
 	default:
 		panic(fmt.Sprintf("not supported entry metadata type: %v", md.Type))
 	}
 }
 
 type entryMetadataReadCloser struct {
-	io.ReadCloser
+	repo.ObjectReader
 	metadata *fs.EntryMetadata
 }
 
@@ -114,7 +122,7 @@ func (emrc *entryMetadataReadCloser) EntryMetadata() (*fs.EntryMetadata, error)
 	return emrc.metadata, nil
 }
 
-func withMetadata(rc io.ReadCloser, md *fs.EntryMetadata) fs.Reader {
+func withMetadata(rc repo.ObjectReader, md *fs.EntryMetadata) fs.Reader {
 	return &entryMetadataReadCloser{
 		rc,
 		md,
@@ -136,6 +144,17 @@ func Directory(r *repo.Repository, objectID repo.ObjectID) fs.Directory {
 	return d.(fs.Directory)
 }
 
+// File returns fs.File based on repository object with the specified ID and metadata, such as the root of a snapshot of a single file.
+func File(r *repo.Repository, objectID repo.ObjectID, md fs.EntryMetadata) fs.File {
+	md.Type = fs.EntryTypeFile
+	f := newRepoEntry(r, &dir.Entry{
+		EntryMetadata: md,
+		ObjectID:      objectID,
+	}, nil)
+
+	return f.(fs.File)
+}
+
 var _ fs.Directory = &repositoryDirectory{}
 var _ fs.File = &repositoryFile{}
 var _ fs.Symlink = &repositorySymlink{}
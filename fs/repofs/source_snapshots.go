@@ -47,14 +47,23 @@ func (s *sourceSnapshots) Readdir() (fs.Entries, error) {
 		if m.IncompleteReason != "" {
 			name += fmt.Sprintf(" (%v)", m.IncompleteReason)
 		}
+		md := fs.EntryMetadata{
+			Name:        name,
+			Permissions: 0555,
+			Type:        fs.EntryTypeDirectory,
+			ModTime:     m.StartTime,
+		}
+
+		// Snapshots of single files, such as data streamed from standard input, have file roots.
+		if m.RootEntryType() == fs.EntryTypeFile {
+			md.Permissions = 0444
+			md.Type = fs.EntryTypeFile
+			md.FileSize = m.Stats.TotalFileSize
+		}
+
 		e := newRepoEntry(s.repo, &dir.Entry{
-			EntryMetadata: fs.EntryMetadata{
-				Name:        name,
-				Permissions: 0555,
-				Type:        fs.EntryTypeDirectory,
-				ModTime:     m.StartTime,
-			},
-			ObjectID: m.RootObjectID,
+			EntryMetadata: md,
+			ObjectID:      m.RootObjectID,
 		}, s)
 
 		result = append(result, e)
@@ -0,0 +1,116 @@
+package repofs
+
+import (
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/repo"
+)
+
+// sparseReader reads contents of a sparse file, whose object stores only its data, returning zeros in place of its holes.
+type sparseReader struct {
+	data    repo.ObjectReader
+	holes   []fs.Hole
+	skipped []int64 // total length of holes preceding each hole and of all holes
+	size    int64
+
+	pos     int64
+	dataPos int64 // position in the object, which the next read of data continues from
+}
+
+func newSparseReader(data repo.ObjectReader, holes []fs.Hole, size int64) *sparseReader {
+	r := &sparseReader{
+		data:    data,
+		holes:   holes,
+		skipped: make([]int64, len(holes)+1),
+		size:    size,
+	}
+
+	for i, h := range holes {
+		r.skipped[i+1] = r.skipped[i] + h.Length
+	}
+
+	return r
+}
+
+func (r *sparseReader) Read(b []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+
+	if remaining := r.size - r.pos; int64(len(b)) > remaining {
+		b = b[0:remaining]
+	}
+
+	// Find the first hole, which ends after the current position.
+	i := sort.Search(len(r.holes), func(i int) bool {
+		return r.holes[i].Offset+r.holes[i].Length > r.pos
+	})
+
+	if i < len(r.holes) && r.holes[i].Offset <= r.pos {
+		h := r.holes[i]
+		if end := h.Offset + h.Length - r.pos; int64(len(b)) > end {
+			b = b[0:end]
+		}
+
+		for j := range b {
+			b[j] = 0
+		}
+
+		r.pos += int64(len(b))
+		return len(b), nil
+	}
+
+	// Data up to the next hole is stored in the object after data preceding all earlier holes.
+	if i < len(r.holes) {
+		if end := r.holes[i].Offset - r.pos; int64(len(b)) > end {
+			b = b[0:end]
+		}
+	}
+
+	dataPos := r.pos - r.skipped[i]
+
+	if dataPos != r.dataPos {
+		if _, err := r.data.Seek(dataPos, io.SeekStart); err != nil {
+			return 0, err
+		}
+		r.dataPos = dataPos
+	}
+
+	n, err := r.data.Read(b)
+	r.pos += int64(n)
+	r.dataPos += int64(n)
+	if err == io.EOF && r.pos < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+func (r *sparseReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	}
+
+	if offset < 0 {
+		return -1, errors.New("invalid seek")
+	}
+
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *sparseReader) Close() error {
+	return r.data.Close()
+}
+
+func (r *sparseReader) Length() int64 {
+	return r.size
+}
+
+var _ repo.ObjectReader = &sparseReader{}
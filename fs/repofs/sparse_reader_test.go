@@ -0,0 +1,52 @@
+package repofs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/kopia/kopia/fs"
+)
+
+type bytesObjectReader struct {
+	*bytes.Reader
+}
+
+func (r *bytesObjectReader) Close() error {
+	return nil
+}
+
+func (r *bytesObjectReader) Length() int64 {
+	return r.Size()
+}
+
+func TestSparseReader(t *testing.T) {
+	holes := []fs.Hole{{Offset: 0, Length: 3}, {Offset: 5, Length: 4}, {Offset: 12, Length: 5}}
+	data := []byte{1, 2, 3, 4, 5}
+	expected := []byte{0, 0, 0, 1, 2, 0, 0, 0, 0, 3, 4, 5, 0, 0, 0, 0, 0}
+
+	r := newSparseReader(&bytesObjectReader{bytes.NewReader(data)}, holes, int64(len(expected)))
+	b, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(b, expected) {
+		t.Errorf("unexpected contents: %v %v, expected %v", b, err, expected)
+	}
+
+	for offset := range expected {
+		if _, err := r.Seek(int64(offset), io.SeekStart); err != nil {
+			t.Fatalf("unable to seek to %v: %v", offset, err)
+		}
+
+		b := make([]byte, 2)
+		n, err := io.ReadFull(r, b)
+		if n == 0 || !bytes.Equal(b[0:n], expected[offset:offset+n]) {
+			t.Errorf("unexpected contents at %v: %v %v", offset, b[0:n], err)
+		}
+	}
+
+	// Missing data is reported as an error.
+	r = newSparseReader(&bytesObjectReader{bytes.NewReader(data[0:3])}, holes, int64(len(expected)))
+	if _, err := ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,408 @@
+// Package tarfs implements a read-only filesystem backed by a tar archive.
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kopia/kopia/fs"
+)
+
+// maxInMemoryFileSize is the size of the largest file, whose contents are kept in memory when they can't be read
+// directly from the archive. Contents of larger files are spooled to a temporary file.
+const maxInMemoryFileSize = 64 << 10
+
+// maxInMemoryTotalSize limits the total size of contents kept in memory. Once it's reached, contents of small files
+// are spooled as well, so that memory usage doesn't grow with the number of files in the archive.
+const maxInMemoryTotalSize = 16 << 20
+
+// Archive is a directory tree read from a tar archive.
+type Archive struct {
+	root         *tarDirectory
+	hasRootEntry bool
+
+	source    io.ReaderAt // archive itself, if it can be seeked
+	spool     *os.File    // temporary file holding contents of files, which can't be read directly from the archive
+	spoolSize int64
+
+	inMemorySize int64 // total size of contents kept in memory
+}
+
+// Root returns the root directory of the archive.
+func (a *Archive) Root() fs.Directory {
+	return a.root
+}
+
+// Close releases temporary files used by the archive. It does not close the underlying reader.
+func (a *Archive) Close() error {
+	if a.spool == nil {
+		return nil
+	}
+
+	a.spool.Close()
+	return os.Remove(a.spool.Name())
+}
+
+// Open reads the entire tar archive from the provided reader and returns its directory tree, whose root has the specified name.
+//
+// If the reader can be seeked (such as a regular file), contents of files are read directly from the archive,
+// which must not be closed before the Archive. Otherwise contents of small files are kept in memory
+// (up to a limited total size) and the remaining files are spooled to a temporary file until the Archive is closed.
+func Open(r io.Reader, name string) (*Archive, error) {
+	a := &Archive{}
+
+	tarReader, pr, seekable := newPositionReader(r)
+	if seekable {
+		a.source = r.(io.ReaderAt)
+	}
+
+	a.root = newDirectory(nil, &fs.EntryMetadata{
+		Name:        name,
+		Type:        fs.EntryTypeDirectory,
+		Permissions: 0755,
+	})
+
+	tr := tar.NewReader(tarReader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			a.Close()
+			return nil, fmt.Errorf("unable to read tar archive: %v", err)
+		}
+
+		if err := a.add(hdr, tr, pr.pos); err != nil {
+			a.Close()
+			return nil, err
+		}
+
+		// Unless the archive describes the root directory, its modification time is that of the newest entry.
+		if !a.hasRootEntry && a.root.metadata.ModTime.Before(hdr.ModTime) {
+			a.root.metadata.ModTime = hdr.ModTime.UTC()
+		}
+	}
+
+	return a, nil
+}
+
+// add adds the entry described by the tar header, whose contents start at the specified offset in the archive.
+func (a *Archive) add(hdr *tar.Header, contents io.Reader, offset int64) error {
+	parts := splitPath(hdr.Name)
+
+	md := &fs.EntryMetadata{
+		Type:        fs.EntryTypeFile,
+		Permissions: fs.Permissions(hdr.Mode & int64(os.ModePerm)),
+		ModTime:     hdr.ModTime.UTC(),
+		UserID:      uint32(hdr.Uid),
+		GroupID:     uint32(hdr.Gid),
+	}
+
+	if len(parts) == 0 {
+		// Entry describing the root directory itself.
+		if hdr.Typeflag == tar.TypeDir {
+			md.Name = a.root.metadata.Name
+			md.Type = fs.EntryTypeDirectory
+			a.root.metadata = md
+			a.hasRootEntry = true
+		}
+		return nil
+	}
+
+	parent := a.root.mkdirAll(parts[0:len(parts)-1], md.ModTime)
+	md.Name = parts[len(parts)-1]
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		md.Type = fs.EntryTypeDirectory
+		if existing, ok := parent.children[md.Name].(*tarDirectory); ok {
+			existing.metadata = md
+			return nil
+		}
+
+		parent.add(newDirectory(parent, md))
+
+	case tar.TypeReg, tar.TypeGNUSparse:
+		md.FileSize = hdr.Size
+		f := &tarFile{tarEntry: tarEntry{parent, md}}
+		if a.source != nil && !isSparse(hdr) {
+			f.source = a.source
+			f.offset = offset
+		} else if err := a.storeContents(f, contents); err != nil {
+			return fmt.Errorf("unable to read contents of %v: %v", hdr.Name, err)
+		}
+
+		parent.add(f)
+
+	case tar.TypeLink:
+		target, ok := a.root.find(splitPath(hdr.Linkname)).(*tarFile)
+		if !ok {
+			log.Printf("warning: ignoring hard link %v to unknown file %v", hdr.Name, hdr.Linkname)
+			return nil
+		}
+
+		md.FileSize = target.metadata.FileSize
+		parent.add(&tarFile{tarEntry: tarEntry{parent, md}, source: target.source, offset: target.offset})
+
+	case tar.TypeSymlink:
+		md.Type = fs.EntryTypeSymlink
+		parent.add(&tarSymlink{tarEntry: tarEntry{parent, md}, target: hdr.Linkname})
+
+	case tar.TypeXGlobalHeader:
+		// Global PAX headers don't describe entries.
+
+	default:
+		log.Printf("warning: ignoring %v with unsupported type %q", hdr.Name, hdr.Typeflag)
+	}
+
+	return nil
+}
+
+// storeContents reads contents of the file from the archive and keeps them in memory or in the spool file.
+func (a *Archive) storeContents(f *tarFile, contents io.Reader) error {
+	if f.metadata.FileSize <= maxInMemoryFileSize && a.inMemorySize+f.metadata.FileSize <= maxInMemoryTotalSize {
+		b, err := ioutil.ReadAll(contents)
+		if err != nil {
+			return err
+		}
+
+		f.source = bytes.NewReader(b)
+		a.inMemorySize += int64(len(b))
+		return nil
+	}
+
+	if a.spool == nil {
+		spool, err := ioutil.TempFile("", "kopia-tar")
+		if err != nil {
+			return fmt.Errorf("unable to create spool file: %v", err)
+		}
+		a.spool = spool
+	}
+
+	n, err := io.Copy(a.spool, contents)
+	if err != nil {
+		return err
+	}
+
+	f.source = a.spool
+	f.offset = a.spoolSize
+	a.spoolSize += n
+	return nil
+}
+
+// isSparse determines whether the file is stored in the archive as a sparse file,
+// in which case its contents can't be read directly from the archive.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitPath returns components of the path of an archive entry relative to the root of the archive.
+func splitPath(p string) []string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil
+	}
+
+	return strings.Split(p, "/")
+}
+
+type sortedEntries fs.Entries
+
+func (e sortedEntries) Len() int      { return len(e) }
+func (e sortedEntries) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e sortedEntries) Less(i, j int) bool {
+	return e[i].Metadata().Name < e[j].Metadata().Name
+}
+
+type tarEntry struct {
+	parent   *tarDirectory
+	metadata *fs.EntryMetadata
+}
+
+func (e *tarEntry) Parent() fs.Directory {
+	if e.parent == nil {
+		return nil
+	}
+
+	return e.parent
+}
+
+func (e *tarEntry) Metadata() *fs.EntryMetadata {
+	return e.metadata
+}
+
+type tarDirectory struct {
+	tarEntry
+
+	children map[string]fs.Entry
+}
+
+func newDirectory(parent *tarDirectory, md *fs.EntryMetadata) *tarDirectory {
+	return &tarDirectory{
+		tarEntry: tarEntry{parent, md},
+		children: map[string]fs.Entry{},
+	}
+}
+
+// add adds the entry to the directory, replacing any previous entry with the same name.
+func (d *tarDirectory) add(e fs.Entry) {
+	d.children[e.Metadata().Name] = e
+}
+
+// mkdirAll returns the subdirectory with the specified path, creating directories, which are not present in the archive.
+func (d *tarDirectory) mkdirAll(parts []string, modTime time.Time) *tarDirectory {
+	for _, p := range parts {
+		subdir, ok := d.children[p].(*tarDirectory)
+		if !ok {
+			subdir = newDirectory(d, &fs.EntryMetadata{
+				Name:        p,
+				Type:        fs.EntryTypeDirectory,
+				Permissions: 0755,
+				ModTime:     modTime,
+			})
+			d.add(subdir)
+		}
+
+		d = subdir
+	}
+
+	return d
+}
+
+// find returns the entry with the specified path or nil if not found.
+func (d *tarDirectory) find(parts []string) fs.Entry {
+	var e fs.Entry = d
+	for _, p := range parts {
+		dir, ok := e.(*tarDirectory)
+		if !ok {
+			return nil
+		}
+
+		if e, ok = dir.children[p]; !ok {
+			return nil
+		}
+	}
+
+	return e
+}
+
+func (d *tarDirectory) Readdir() (fs.Entries, error) {
+	var entries fs.Entries
+	for _, e := range d.children {
+		entries = append(entries, e)
+	}
+
+	sort.Sort(sortedEntries(entries))
+	return entries, nil
+}
+
+type tarFile struct {
+	tarEntry
+
+	source io.ReaderAt
+	offset int64
+}
+
+func (f *tarFile) Open() (fs.Reader, error) {
+	var r io.Reader = bytes.NewReader(nil)
+	if f.source != nil {
+		r = io.NewSectionReader(f.source, f.offset, f.metadata.FileSize)
+	}
+
+	return &tarFileReader{r, f.metadata}, nil
+}
+
+type tarFileReader struct {
+	io.Reader
+	metadata *fs.EntryMetadata
+}
+
+func (r *tarFileReader) Close() error {
+	return nil
+}
+
+func (r *tarFileReader) EntryMetadata() (*fs.EntryMetadata, error) {
+	return r.metadata, nil
+}
+
+type tarSymlink struct {
+	tarEntry
+
+	target string
+}
+
+func (s *tarSymlink) Readlink() (string, error) {
+	return s.target, nil
+}
+
+// positionReader tracks the position in the underlying reader, so that offsets of file contents can be recorded.
+type positionReader struct {
+	reader io.Reader
+	pos    int64
+}
+
+func (pr *positionReader) Read(b []byte) (int, error) {
+	n, err := pr.reader.Read(b)
+	pr.pos += int64(n)
+	return n, err
+}
+
+// seekingPositionReader allows the tar reader to skip over contents of files without reading them.
+type seekingPositionReader struct {
+	*positionReader
+	seeker io.Seeker
+}
+
+func (pr *seekingPositionReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := pr.seeker.Seek(offset, whence)
+	if err == nil {
+		pr.pos = pos
+	}
+	return pos, err
+}
+
+// newPositionReader returns a reader tracking the position in r and a boolean indicating whether r can be seeked.
+func newPositionReader(r io.Reader) (io.Reader, *positionReader, bool) {
+	pr := &positionReader{reader: r}
+
+	// Readers, which can't be seeked (such as pipes), report an error when asked for current position.
+	s, ok := r.(io.Seeker)
+	if _, isReaderAt := r.(io.ReaderAt); !ok || !isReaderAt {
+		return pr, pr, false
+	}
+
+	pos, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return pr, pr, false
+	}
+
+	pr.pos = pos
+	return &seekingPositionReader{pr, s}, pr, true
+}
+
+var (
+	_ fs.Directory = &tarDirectory{}
+	_ fs.File      = &tarFile{}
+	_ fs.Symlink   = &tarSymlink{}
+)
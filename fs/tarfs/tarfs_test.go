@@ -0,0 +1,217 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/fs"
+)
+
+func createArchive(t *testing.T, largeContents []byte) []byte {
+	var buf bytes.Buffer
+
+	modTime := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	tw := tar.NewWriter(&buf)
+	headers := []struct {
+		hdr      tar.Header
+		contents []byte
+	}{
+		{tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0700, ModTime: modTime}, nil},
+		{tar.Header{Name: "./dir1/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: modTime}, nil},
+		{tar.Header{Name: "./dir1/small.txt", Typeflag: tar.TypeReg, Mode: 0640, Uid: 12, Gid: 34, ModTime: modTime}, []byte("hello")},
+		{tar.Header{Name: "dir2/sub/large.bin", Typeflag: tar.TypeReg, Mode: 0600, ModTime: modTime}, largeContents},
+		{tar.Header{Name: "dir2/link", Typeflag: tar.TypeSymlink, Linkname: "sub/large.bin", ModTime: modTime}, nil},
+		{tar.Header{Name: "dir2/hardlink.txt", Typeflag: tar.TypeLink, Linkname: "dir1/small.txt", Mode: 0640, ModTime: modTime}, nil},
+		{tar.Header{Name: "fifo", Typeflag: tar.TypeFifo, ModTime: modTime}, nil},
+	}
+
+	for _, h := range headers {
+		h.hdr.Size = int64(len(h.contents))
+		if err := tw.WriteHeader(&h.hdr); err != nil {
+			t.Fatalf("unable to write header: %v", err)
+		}
+
+		if _, err := tw.Write(h.contents); err != nil {
+			t.Fatalf("unable to write contents: %v", err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unable to close archive: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestArchive(t *testing.T) {
+	largeContents := make([]byte, 3*maxInMemoryFileSize)
+	for i := range largeContents {
+		largeContents[i] = byte(i % 251)
+	}
+
+	data := createArchive(t, largeContents)
+
+	cases := []struct {
+		desc   string
+		reader io.Reader
+	}{
+		{"seekable", bytes.NewReader(data)},
+		{"non-seekable", ioutil.NopCloser(bytes.NewReader(data))},
+	}
+
+	for _, tc := range cases {
+		a, err := Open(tc.reader, "archive.tar")
+		if err != nil {
+			t.Fatalf("%v: unable to open archive: %v", tc.desc, err)
+		}
+
+		if (a.spool != nil) == (tc.desc == "seekable") {
+			t.Errorf("%v: unexpected spool file: %v", tc.desc, a.spool)
+		}
+
+		root := a.Root()
+		if md := root.Metadata(); md.Name != "archive.tar" || md.Permissions != 0700 || md.Type != fs.EntryTypeDirectory {
+			t.Errorf("%v: unexpected root metadata: %+v", tc.desc, md)
+		}
+
+		verifyEntries(t, tc.desc, root, []string{"dir1", "dir2"})
+		dir1 := getEntry(t, root, "dir1").(fs.Directory)
+		dir2 := getEntry(t, root, "dir2").(fs.Directory)
+
+		verifyEntries(t, tc.desc, dir1, []string{"small.txt"})
+		verifyEntries(t, tc.desc, dir2, []string{"hardlink.txt", "link", "sub"})
+
+		if md := dir1.Metadata(); md.Permissions != 0750 {
+			t.Errorf("%v: unexpected dir1 metadata: %+v", tc.desc, md)
+		}
+
+		// dir2 is not present in the archive and has default permissions.
+		if md := dir2.Metadata(); md.Permissions != 0755 || dir2.Parent() != root {
+			t.Errorf("%v: unexpected dir2 metadata: %+v", tc.desc, md)
+		}
+
+		small := getEntry(t, dir1, "small.txt").(fs.File)
+		if md := small.Metadata(); md.Permissions != 0640 || md.UserID != 12 || md.GroupID != 34 || md.FileSize != 5 {
+			t.Errorf("%v: unexpected file metadata: %+v", tc.desc, md)
+		}
+
+		verifyContents(t, tc.desc, small, []byte("hello"))
+		verifyContents(t, tc.desc, getEntry(t, dir2, "hardlink.txt").(fs.File), []byte("hello"))
+
+		sub := getEntry(t, dir2, "sub").(fs.Directory)
+		verifyContents(t, tc.desc, getEntry(t, sub, "large.bin").(fs.File), largeContents)
+
+		if target, err := getEntry(t, dir2, "link").(fs.Symlink).Readlink(); err != nil || target != "sub/large.bin" {
+			t.Errorf("%v: unexpected symlink target: %v %v", tc.desc, target, err)
+		}
+
+		if err := a.Close(); err != nil {
+			t.Errorf("%v: unable to close archive: %v", tc.desc, err)
+		}
+	}
+}
+
+func TestArchive_Corrupted(t *testing.T) {
+	data := createArchive(t, []byte{1, 2, 3})
+
+	if _, err := Open(bytes.NewReader(data[0:700]), "archive.tar"); err == nil {
+		t.Errorf("expected error when reading truncated archive")
+	}
+}
+
+func TestArchive_InMemoryLimit(t *testing.T) {
+	var buf bytes.Buffer
+
+	// Small files exceeding the in-memory limit in total.
+	const numFiles = 2*maxInMemoryTotalSize/maxInMemoryFileSize + 1
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < numFiles; i++ {
+		contents := bytes.Repeat([]byte{byte(i)}, maxInMemoryFileSize)
+		if err := tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("f%v", i), Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(contents))}); err != nil {
+			t.Fatalf("unable to write header: %v", err)
+		}
+
+		if _, err := tw.Write(contents); err != nil {
+			t.Fatalf("unable to write contents: %v", err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("unable to close archive: %v", err)
+	}
+
+	a, err := Open(ioutil.NopCloser(&buf), "archive.tar")
+	if err != nil {
+		t.Fatalf("unable to open archive: %v", err)
+	}
+	defer a.Close()
+
+	if a.inMemorySize > maxInMemoryTotalSize || a.inMemorySize+a.spoolSize != numFiles*maxInMemoryFileSize {
+		t.Errorf("unexpected sizes of contents in memory (%v) and in the spool file (%v)", a.inMemorySize, a.spoolSize)
+	}
+
+	for _, i := range []int{0, numFiles - 1} {
+		name := fmt.Sprintf("f%v", i)
+		verifyContents(t, name, getEntry(t, a.Root(), name).(fs.File), bytes.Repeat([]byte{byte(i)}, maxInMemoryFileSize))
+	}
+}
+
+func getEntry(t *testing.T, dir fs.Directory, name string) fs.Entry {
+	entries, err := dir.Readdir()
+	if err != nil {
+		t.Fatalf("unable to read directory: %v", err)
+	}
+
+	e := entries.FindByName(name)
+	if e == nil {
+		t.Fatalf("entry %v not found in %v", name, fs.EntryPath(dir))
+	}
+
+	return e
+}
+
+func verifyEntries(t *testing.T, desc string, dir fs.Directory, names []string) {
+	entries, err := dir.Readdir()
+	if err != nil {
+		t.Fatalf("%v: unable to read directory: %v", desc, err)
+	}
+
+	var actual []string
+	for _, e := range entries {
+		actual = append(actual, e.Metadata().Name)
+	}
+
+	if len(actual) != len(names) {
+		t.Errorf("%v: unexpected entries of %v: %v, expected %v", desc, fs.EntryPath(dir), actual, names)
+		return
+	}
+
+	for i := range names {
+		if actual[i] != names[i] {
+			t.Errorf("%v: unexpected entries of %v: %v, expected %v", desc, fs.EntryPath(dir), actual, names)
+			return
+		}
+	}
+}
+
+func verifyContents(t *testing.T, desc string, f fs.File, expected []byte) {
+	r, err := f.Open()
+	if err != nil {
+		t.Fatalf("%v: unable to open %v: %v", desc, fs.EntryPath(f), err)
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%v: unable to read %v: %v", desc, fs.EntryPath(f), err)
+	}
+
+	if !bytes.Equal(b, expected) {
+		t.Errorf("%v: unexpected contents of %v (%v bytes, expected %v)", desc, fs.EntryPath(f), len(b), len(expected))
+	}
+}
@@ -0,0 +1,80 @@
+// Package virtualfs implements virtual filesystem entries, which are not backed by a local filesystem.
+package virtualfs
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/kopia/kopia/fs"
+)
+
+// ErrAlreadyOpened is returned when a streaming file is opened more than once.
+var ErrAlreadyOpened = errors.New("streaming file can only be opened once")
+
+type streamingFile struct {
+	metadata *fs.EntryMetadata
+
+	mu     sync.Mutex
+	reader io.Reader
+}
+
+func (f *streamingFile) Parent() fs.Directory {
+	return nil
+}
+
+func (f *streamingFile) Metadata() *fs.EntryMetadata {
+	return f.metadata
+}
+
+func (f *streamingFile) Open() (fs.Reader, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.reader == nil {
+		return nil, ErrAlreadyOpened
+	}
+
+	r := &streamingReader{reader: f.reader, metadata: *f.metadata}
+	f.reader = nil
+	return r, nil
+}
+
+type streamingReader struct {
+	reader   io.Reader
+	metadata fs.EntryMetadata
+}
+
+func (r *streamingReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.metadata.FileSize += int64(n)
+	return n, err
+}
+
+// Close does not close the underlying reader, which is owned by the caller.
+func (r *streamingReader) Close() error {
+	return nil
+}
+
+// EntryMetadata returns metadata of the file with the size equal to the number of bytes read so far.
+func (r *streamingReader) EntryMetadata() (*fs.EntryMetadata, error) {
+	md := r.metadata
+	return &md, nil
+}
+
+// StreamingFile returns a file with the specified name, whose contents are read once from the provided reader.
+// The size of the file is unknown until it has been read.
+func StreamingFile(name string, r io.Reader) fs.File {
+	return &streamingFile{
+		metadata: &fs.EntryMetadata{
+			Name:        name,
+			Type:        fs.EntryTypeFile,
+			Permissions: 0600,
+			ModTime:     time.Now(),
+		},
+		reader: r,
+	}
+}
+
+var _ fs.File = &streamingFile{}
@@ -0,0 +1,35 @@
+package virtualfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestStreamingFile(t *testing.T) {
+	f := StreamingFile("dump.sql", bytes.NewReader([]byte("some data")))
+
+	if md := f.Metadata(); md.Name != "dump.sql" || md.FileSize != 0 {
+		t.Errorf("unexpected metadata: %+v", md)
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		t.Fatalf("unable to open: %v", err)
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil || string(b) != "some data" {
+		t.Errorf("unexpected contents: %q %v", b, err)
+	}
+
+	md, err := r.EntryMetadata()
+	if err != nil || md.FileSize != int64(len(b)) {
+		t.Errorf("unexpected metadata after reading: %+v %v", md, err)
+	}
+
+	if _, err := f.Open(); err != ErrAlreadyOpened {
+		t.Errorf("unexpected error when opening again: %v", err)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	fusefs "bazil.org/fuse/fs"
 
 	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
 )
 
 type fuseNode struct {
@@ -31,6 +32,32 @@ func (n *fuseNode) Attr(ctx context.Context, a *fuse.Attr) error {
 	a.Mtime = m.ModTime
 	a.Uid = m.UserID
 	a.Gid = m.GroupID
+	if m.Device != nil {
+		a.Rdev = uint32(unix.Mkdev(m.Device.Major, m.Device.Minor))
+	}
+	return nil
+}
+
+// Getxattr returns the extended attribute of the entry. ACLs are not exposed, since they're not enforced by the filesystem.
+func (n *fuseNode) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	v, ok := n.entry.Metadata().ExtendedAttributes[req.Name]
+	if !ok {
+		return fuse.ErrNoXattr
+	}
+
+	resp.Xattr = v
+	return nil
+}
+
+// Listxattr returns names of extended attributes of the entry.
+func (n *fuseNode) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	var names []string
+	for name := range n.entry.Metadata().ExtendedAttributes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	resp.Append(names...)
 	return nil
 }
 
@@ -111,6 +138,14 @@ func (dir *fuseDirectoryNode) ReadDirAll(ctx context.Context) ([]fuse.Dirent, er
 			dirent.Type = fuse.DT_File
 		case fs.EntryTypeSymlink:
 			dirent.Type = fuse.DT_Link
+		case fs.EntryTypeBlockDevice:
+			dirent.Type = fuse.DT_Block
+		case fs.EntryTypeCharDevice:
+			dirent.Type = fuse.DT_Char
+		case fs.EntryTypeNamedPipe:
+			dirent.Type = fuse.DT_FIFO
+		case fs.EntryTypeSocket:
+			dirent.Type = fuse.DT_Socket
 		}
 
 		result = append(result, dirent)
@@ -136,6 +171,10 @@ func newFuseNode(e fs.Entry, cache *Cache) (fusefs.Node, error) {
 	case fs.Symlink:
 		return &fuseSymlinkNode{fuseNode{e, cache}}, nil
 	default:
+		if e.Metadata().Type.IsSpecial() {
+			return &fuseNode{e, cache}, nil
+		}
+
 		return nil, fmt.Errorf("entry type not supported: %v", e.Metadata().Type)
 	}
 }
@@ -0,0 +1,319 @@
+// Package benchmark implements benchmarks used to choose repository storage and format settings.
+package benchmark
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kopia/kopia/blob"
+)
+
+// Storage operations exercised by the benchmark.
+const (
+	OpWrite  = "write"
+	OpRead   = "read"
+	OpList   = "list"
+	OpDelete = "delete"
+)
+
+// StorageOptions specifies the workload of a storage benchmark.
+type StorageOptions struct {
+	BlockSizes  []int         // sizes of written blocks, chosen at random for each write
+	Parallelism int           // number of concurrent workers
+	Duration    time.Duration // duration of the workload, not including cleanup
+
+	// Relative weights of operations performed by workers, reads and lists are only performed
+	// after at least one block has been written.
+	WriteWeight int
+	ReadWeight  int
+	ListWeight  int
+}
+
+// OperationStats contains statistics of a single kind of storage operation for a particular block size.
+type OperationStats struct {
+	Operation string        `json:"operation"`
+	BlockSize int           `json:"blockSize,omitempty"`
+	Count     int           `json:"count"`
+	Errors    int           `json:"errors"`
+	Bytes     int64         `json:"bytes"`
+	Total     time.Duration `json:"total"`
+	P50       time.Duration `json:"p50"`
+	P90       time.Duration `json:"p90"`
+	P99       time.Duration `json:"p99"`
+	Max       time.Duration `json:"max"`
+
+	latencies []time.Duration
+}
+
+// ErrorRate returns the fraction of operations that have failed.
+func (s *OperationStats) ErrorRate() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+
+	return float64(s.Errors) / float64(s.Count)
+}
+
+// StorageResults contains results of the storage benchmark.
+type StorageResults struct {
+	Duration   time.Duration     `json:"duration"`
+	Operations []*OperationStats `json:"operations"`
+}
+
+// Throughput returns the number of bytes per second transferred by the operation during the benchmark.
+func (r *StorageResults) Throughput(s *OperationStats) float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+
+	return float64(s.Bytes) / r.Duration.Seconds()
+}
+
+// Storage runs the benchmark against the provided storage. All blocks written by the benchmark use a unique prefix
+// and are deleted before returning, even if the context has been cancelled.
+func Storage(ctx context.Context, st blob.Storage, opt StorageOptions) (*StorageResults, error) {
+	if len(opt.BlockSizes) == 0 {
+		return nil, fmt.Errorf("no block sizes specified")
+	}
+
+	if opt.WriteWeight <= 0 {
+		return nil, fmt.Errorf("write weight must be positive")
+	}
+
+	if opt.Parallelism <= 0 {
+		opt.Parallelism = 1
+	}
+
+	b := &storageBenchmark{
+		st:     st,
+		opt:    opt,
+		prefix: randomPrefix(),
+		data:   map[int][]byte{},
+		stats:  map[statsKey]*OperationStats{},
+	}
+
+	for _, s := range opt.BlockSizes {
+		if s <= 0 {
+			return nil, fmt.Errorf("invalid block size: %v", s)
+		}
+
+		b.data[s] = make([]byte, s)
+		if _, err := rand.Read(b.data[s]); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opt.Duration)
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < opt.Parallelism; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			b.worker(ctx, mathrand.New(mathrand.NewSource(seed)))
+		}(start.UnixNano() + int64(i))
+	}
+	wg.Wait()
+
+	result := &StorageResults{Duration: time.Since(start)}
+
+	b.cleanup()
+
+	for _, s := range b.stats {
+		s.computePercentiles()
+		result.Operations = append(result.Operations, s)
+	}
+
+	sort.Slice(result.Operations, func(i, j int) bool {
+		oi, oj := result.Operations[i], result.Operations[j]
+		if oi.Operation != oj.Operation {
+			return operationOrder[oi.Operation] < operationOrder[oj.Operation]
+		}
+		return oi.BlockSize < oj.BlockSize
+	})
+
+	return result, nil
+}
+
+var operationOrder = map[string]int{OpWrite: 0, OpRead: 1, OpList: 2, OpDelete: 3}
+
+type statsKey struct {
+	op        string
+	blockSize int
+}
+
+type writtenBlock struct {
+	id   string
+	size int
+}
+
+type storageBenchmark struct {
+	nextBlock int64 // must be first for alignment of atomic access
+
+	st     blob.Storage
+	opt    StorageOptions
+	prefix string
+	data   map[int][]byte
+
+	mu       sync.Mutex
+	written  []writtenBlock // all blocks written, including failed writes
+	readable []writtenBlock // blocks successfully written
+	stats    map[statsKey]*OperationStats
+}
+
+func (b *storageBenchmark) worker(ctx context.Context, rnd *mathrand.Rand) {
+	total := b.opt.WriteWeight + b.opt.ReadWeight + b.opt.ListWeight
+
+	for ctx.Err() == nil {
+		n := rnd.Intn(total)
+
+		b.mu.Lock()
+		var existing writtenBlock
+		hasExisting := len(b.readable) > 0
+		if hasExisting {
+			existing = b.readable[rnd.Intn(len(b.readable))]
+		}
+		b.mu.Unlock()
+
+		switch {
+		case n < b.opt.WriteWeight || !hasExisting:
+			b.write(b.opt.BlockSizes[rnd.Intn(len(b.opt.BlockSizes))])
+
+		case n < b.opt.WriteWeight+b.opt.ReadWeight:
+			b.read(existing)
+
+		default:
+			b.list()
+		}
+	}
+}
+
+func (b *storageBenchmark) write(size int) {
+	id := fmt.Sprintf("%v%08x", b.prefix, atomic.AddInt64(&b.nextBlock, 1))
+
+	t0 := time.Now()
+	err := b.st.PutBlock(id, b.data[size])
+	b.record(OpWrite, size, int64(size), time.Since(t0), err)
+
+	// Remember the block even if the write has failed, so that a partial write gets cleaned up.
+	b.mu.Lock()
+	b.written = append(b.written, writtenBlock{id, size})
+	if err == nil {
+		b.readable = append(b.readable, writtenBlock{id, size})
+	}
+	b.mu.Unlock()
+}
+
+func (b *storageBenchmark) read(wb writtenBlock) {
+	t0 := time.Now()
+	data, err := b.st.GetBlock(wb.id, 0, -1)
+	dt := time.Since(t0)
+	if err == nil && len(data) != wb.size {
+		err = fmt.Errorf("unexpected length of block %v: %v, expected %v", wb.id, len(data), wb.size)
+	}
+	b.record(OpRead, wb.size, int64(len(data)), dt, err)
+}
+
+func (b *storageBenchmark) list() {
+	t0 := time.Now()
+	ch, cancel := b.st.ListBlocks(b.prefix)
+	defer cancel()
+
+	var err error
+	for bm := range ch {
+		if bm.Error != nil {
+			err = bm.Error
+			break
+		}
+	}
+
+	b.record(OpList, 0, 0, time.Since(t0), err)
+}
+
+// cleanup deletes all blocks written by the benchmark.
+func (b *storageBenchmark) cleanup() {
+	ch := make(chan writtenBlock)
+	var wg sync.WaitGroup
+
+	for i := 0; i < b.opt.Parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for wb := range ch {
+				t0 := time.Now()
+				err := b.st.DeleteBlock(wb.id)
+				if err == blob.ErrBlockNotFound {
+					err = nil
+				}
+				b.record(OpDelete, wb.size, 0, time.Since(t0), err)
+			}
+		}()
+	}
+
+	for _, wb := range b.written {
+		ch <- wb
+	}
+	close(ch)
+	wg.Wait()
+}
+
+func (b *storageBenchmark) record(op string, blockSize int, bytes int64, dt time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	k := statsKey{op, blockSize}
+	s := b.stats[k]
+	if s == nil {
+		s = &OperationStats{Operation: op, BlockSize: blockSize}
+		b.stats[k] = s
+	}
+
+	s.Count++
+	s.Total += dt
+	s.latencies = append(s.latencies, dt)
+	if err != nil {
+		s.Errors++
+		return
+	}
+
+	s.Bytes += bytes
+}
+
+func (s *OperationStats) computePercentiles() {
+	if len(s.latencies) == 0 {
+		return
+	}
+
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+
+	s.P50 = percentile(s.latencies, 50)
+	s.P90 = percentile(s.latencies, 90)
+	s.P99 = percentile(s.latencies, 99)
+	s.Max = s.latencies[len(s.latencies)-1]
+}
+
+// percentile returns the p-th percentile of sorted latencies using the nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+func randomPrefix() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "benchmark-" + hex.EncodeToString(b) + "-"
+}
@@ -0,0 +1,66 @@
+package benchmark
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/internal/storagetesting"
+)
+
+func TestStorageBenchmark(t *testing.T) {
+	data := map[string][]byte{}
+	st := storagetesting.NewMapStorage(data)
+
+	res, err := Storage(context.Background(), st, StorageOptions{
+		BlockSizes:  []int{100, 1000},
+		Parallelism: 3,
+		Duration:    100 * time.Millisecond,
+		WriteWeight: 1,
+		ReadWeight:  2,
+		ListWeight:  1,
+	})
+	if err != nil {
+		t.Fatalf("benchmark failed: %v", err)
+	}
+
+	if len(data) != 0 {
+		t.Errorf("benchmark did not clean up %v blocks", len(data))
+	}
+
+	counts := map[string]int{}
+	for _, s := range res.Operations {
+		counts[s.Operation] += s.Count
+		if s.Errors != 0 {
+			t.Errorf("unexpected errors in %v of %v-byte blocks: %v", s.Operation, s.BlockSize, s.Errors)
+		}
+
+		if s.P50 > s.P90 || s.P90 > s.P99 || s.P99 > s.Max {
+			t.Errorf("invalid percentiles of %v: %v %v %v %v", s.Operation, s.P50, s.P90, s.P99, s.Max)
+		}
+	}
+
+	for _, op := range []string{OpWrite, OpRead, OpList} {
+		if counts[op] == 0 {
+			t.Errorf("no %v operations were performed", op)
+		}
+	}
+
+	if counts[OpDelete] != counts[OpWrite] {
+		t.Errorf("unexpected number of deletes: %v, expected %v", counts[OpDelete], counts[OpWrite])
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 200; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+
+	cases := map[int]time.Duration{50: 100, 90: 180, 99: 198, 100: 200, 0: 1}
+	for p, want := range cases {
+		if got := percentile(sorted, p); got != want {
+			t.Errorf("percentile(%v) = %v, want %v", p, got, want)
+		}
+	}
+}
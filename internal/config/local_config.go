@@ -6,12 +6,15 @@ import (
 	"os"
 
 	"github.com/kopia/kopia/blob"
+	"github.com/kopia/kopia/blob/throttling"
 )
 
 // LocalConfig is a configuration of Kopia.
 type LocalConfig struct {
-	Connection     *RepositoryConnectionInfo `json:"connection,omitempty"`
-	CacheDirectory string                    `json:"cacheDirectory,omitempty"`
+	Connection        *RepositoryConnectionInfo `json:"connection,omitempty"`
+	CacheDirectory    string                    `json:"cacheDirectory,omitempty"`
+	MaxCacheSizeBytes int64                     `json:"maxCacheSizeBytes,omitempty"`
+	Throttling        *throttling.Limits        `json:"throttling,omitempty"`
 }
 
 // RepositoryObjectFormat describes the format of objects in a repository.
@@ -8,6 +8,14 @@ import (
 // Contents of this structure are serialized in plain text in the storage.
 type MetadataFormat struct {
 	auth.SecurityOptions
-	Version             string `json:"version"`
-	EncryptionAlgorithm string `json:"encryption"`
+	Version             string           `json:"version"`
+	EncryptionAlgorithm string           `json:"encryption"`
+	RequiredFeatures    []string         `json:"requiredFeatures,omitempty"` // features clients must support to connect
+	WriteOnce           *WriteOnceFormat `json:"writeOnce,omitempty"`
+}
+
+// WriteOnceFormat describes restrictions of repositories that must be accessed in write-once mode.
+type WriteOnceFormat struct {
+	MinRetentionSeconds   int64  `json:"minRetentionSeconds,omitempty"`   // minimum age of blocks that can be deleted, 0 means never
+	PrivilegedKeyChecksum []byte `json:"privilegedKeyChecksum,omitempty"` // checksum of key that allows unrestricted access
 }
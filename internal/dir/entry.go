@@ -9,4 +9,7 @@ import (
 type Entry struct {
 	fs.EntryMetadata
 	ObjectID repo.ObjectID `json:"obj,omitempty"`
+
+	// Inconsistent is set when the file kept changing while being read, so its contents may be corrupted.
+	Inconsistent bool `json:"inconsistent,omitempty"`
 }
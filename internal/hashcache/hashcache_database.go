@@ -1,4 +1,3 @@
-// Package hashcache implements streaming cache of file hashes.
 package hashcache
 
 import (
@@ -9,14 +8,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
+	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
+	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/repo"
 )
 
-const batchSize = 10
-
 var hashcacheBucket = []byte("hashcache")
 
 // DB is a databsase caching ObjectID computations for local files.
@@ -24,19 +23,29 @@ type DB struct {
 	db *bolt.DB
 }
 
+// directoryRecord is stored in the database for each directory.
+type directoryRecord struct {
+	Files       map[string]string `json:"files,omitempty"`
+	DirHash     uint64            `json:"dirHash,omitempty"`
+	DirObjectID string            `json:"dirOid,omitempty"`
+}
+
 // DirectoryInfo manages a set of hashes for all directory entries.
+// It is safe to call Lookup() and Set() concurrently.
 type DirectoryInfo struct {
 	parent  *DB
-	dirty   bool
 	dirName string
+	found   bool
 
-	previousFiles map[string]string
-	currentFiles  map[string]string
+	mu       sync.Mutex
+	dirty    bool
+	previous directoryRecord
+	current  directoryRecord
 }
 
 // NewHashCacheDB returns new hash cache database stored in a given file.
 func NewHashCacheDB(filename string) (*DB, error) {
-	db, err := bolt.Open(filename, 0600, nil)
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: 5 * time.Second})
 	if err != nil {
 		return nil, err
 	}
@@ -44,6 +53,11 @@ func NewHashCacheDB(filename string) (*DB, error) {
 	return &DB{db: db}, nil
 }
 
+// Close closes the database.
+func (h *DB) Close() error {
+	return h.db.Close()
+}
+
 func (h *DB) keyOf(dirName string) []byte {
 	o := sha1.New()
 	fmt.Fprintf(o, "%v", dirName)
@@ -52,8 +66,12 @@ func (h *DB) keyOf(dirName string) []byte {
 
 // OpenDir returns DirectoryInfo for a single local directory.
 // The caller is expected to call Lookup()/Set() for all entries in the directory before calling Save().
-func (h *DB) OpenDir(dirName string) DirectoryInfo {
-	var dhi DirectoryInfo
+func (h *DB) OpenDir(dirName string) *DirectoryInfo {
+	dhi := &DirectoryInfo{
+		parent:  h,
+		dirName: dirName,
+		current: directoryRecord{Files: map[string]string{}},
+	}
 
 	h.db.View(func(t *bolt.Tx) error {
 		b := t.Bucket(hashcacheBucket)
@@ -71,34 +89,39 @@ func (h *DB) OpenDir(dirName string) DirectoryInfo {
 			return nil
 		}
 
-		var files map[string]string
-
-		if err := json.NewDecoder(gz).Decode(&files); err != nil {
+		var rec directoryRecord
+		if err := json.NewDecoder(gz).Decode(&rec); err != nil {
 			return nil
 		}
 
-		dhi.previousFiles = files
+		dhi.previous = rec
+		dhi.found = true
 
 		return nil
 	})
 
-	dhi.parent = h
-	dhi.dirName = dirName
-	dhi.currentFiles = make(map[string]string)
-
 	return dhi
 }
 
-func (hi *DirectoryInfo) keyOf(fi os.FileInfo) string {
+// Found returns true if the database contained information about the directory.
+func (hi *DirectoryInfo) Found() bool {
+	return hi.found
+}
+
+func (hi *DirectoryInfo) keyOf(md *fs.EntryMetadata, id fs.LocalIdentity) string {
 	h := sha1.New()
-	fmt.Fprintf(h, "%v/%v/%v @ %v", fi.ModTime().UnixNano(), int(fi.Mode()), fi.Size(), fi.Name())
+	fmt.Fprintf(h, "%v/%v/%v/%v/%v @ %v", md.ModTime.UnixNano(), int(md.FileMode()), md.FileSize, id.Inode, id.ChangeTime.UnixNano(), md.Name)
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-// Lookup fetches the ObjectID corresponding to the given FileInfo in a directory, if present.
-func (hi *DirectoryInfo) Lookup(fi os.FileInfo) (repo.ObjectID, bool) {
-	k := hi.keyOf(fi)
-	s, ok := hi.previousFiles[k]
+// Lookup fetches the ObjectID corresponding to the given entry in a directory, if present.
+func (hi *DirectoryInfo) Lookup(md *fs.EntryMetadata, id fs.LocalIdentity) (repo.ObjectID, bool) {
+	k := hi.keyOf(md, id)
+
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+
+	s, ok := hi.previous.Files[k]
 	if !ok {
 		return repo.NullObjectID, false
 	}
@@ -108,43 +131,77 @@ func (hi *DirectoryInfo) Lookup(fi os.FileInfo) (repo.ObjectID, bool) {
 		return repo.NullObjectID, false
 	}
 
-	hi.currentFiles[k] = s
+	hi.current.Files[k] = s
 	return oid, true
 }
 
-// Set associates ObjectID with a FileInfo that will be persisted on Save().
-func (hi *DirectoryInfo) Set(fi os.FileInfo, oid repo.ObjectID) {
-	k := hi.keyOf(fi)
+// Set associates ObjectID with an entry that will be persisted on Save().
+func (hi *DirectoryInfo) Set(md *fs.EntryMetadata, id fs.LocalIdentity, oid repo.ObjectID) {
+	k := hi.keyOf(md, id)
 	new := oid.String()
-	hi.currentFiles[k] = new
-	hi.dirty = true
+
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+
+	if hi.current.Files[k] != new {
+		hi.current.Files[k] = new
+		hi.dirty = true
+	}
 }
 
-// Save writes the current associations of FileInfo->ObjectID for the directory to the databsase.
+// LookupDir fetches the ObjectID of the directory itself if it has been saved with the same hash of its contents.
+func (hi *DirectoryInfo) LookupDir(dirHash uint64) (repo.ObjectID, bool) {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+
+	if dirHash == 0 || hi.previous.DirHash != dirHash {
+		return repo.NullObjectID, false
+	}
+
+	oid, err := repo.ParseObjectID(hi.previous.DirObjectID)
+	if err != nil {
+		return repo.NullObjectID, false
+	}
+
+	return oid, true
+}
+
+// SetDir associates ObjectID of the directory itself with the hash of its contents.
+func (hi *DirectoryInfo) SetDir(dirHash uint64, oid repo.ObjectID) {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+
+	hi.current.DirHash = dirHash
+	hi.current.DirObjectID = oid.String()
+}
+
+// Save writes the current associations of entries to ObjectIDs for the directory to the databsase.
 // Any entries for which neither Lookup() nor Set() has been called are assumed to be removed.
 func (hi *DirectoryInfo) Save() error {
-	if len(hi.previousFiles) == len(hi.currentFiles) && !hi.dirty {
+	hi.mu.Lock()
+	defer hi.mu.Unlock()
+
+	if len(hi.previous.Files) == len(hi.current.Files) &&
+		hi.previous.DirHash == hi.current.DirHash &&
+		hi.previous.DirObjectID == hi.current.DirObjectID &&
+		hi.found && !hi.dirty {
 		return nil
 	}
 
 	var buf bytes.Buffer
 	gz := gzip.NewWriter(&buf)
 
-	if err := json.NewEncoder(gz).Encode(hi.currentFiles); err != nil {
+	if err := json.NewEncoder(gz).Encode(&hi.current); err != nil {
 		return err
 	}
-	gz.Flush()
+	gz.Close()
 
 	value := buf.Bytes()
 
 	err := hi.parent.db.Update(func(t *bolt.Tx) error {
-		b := t.Bucket(hashcacheBucket)
-		if b == nil {
-			var err error
-			b, err = t.CreateBucket(hashcacheBucket)
-			if err != nil {
-				return err
-			}
+		b, err := t.CreateBucketIfNotExists(hashcacheBucket)
+		if err != nil {
+			return err
 		}
 
 		return b.Put(hi.parent.keyOf(hi.dirName), value)
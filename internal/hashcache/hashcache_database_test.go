@@ -0,0 +1,98 @@
+package hashcache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/repo"
+)
+
+func TestHashCacheDB(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "kopia-hashcache")
+	if err != nil {
+		t.Fatalf("unable to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filename := filepath.Join(tmpDir, "hashcache.db")
+	db, err := NewHashCacheDB(filename)
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+
+	md1 := &fs.EntryMetadata{Name: "f1", FileSize: 100, ModTime: time.Unix(1000, 0)}
+	md2 := &fs.EntryMetadata{Name: "f2", FileSize: 200, ModTime: time.Unix(2000, 0)}
+	id1 := fs.LocalIdentity{Inode: 1, ChangeTime: time.Unix(1001, 0)}
+	id2 := fs.LocalIdentity{Inode: 2, ChangeTime: time.Unix(2001, 0)}
+	oid1 := repo.ObjectID{StorageBlock: "block1"}
+	oid2 := repo.ObjectID{StorageBlock: "block2"}
+	dirOID := repo.ObjectID{StorageBlock: "dirblock"}
+
+	di := db.OpenDir("/some/dir")
+	if di.Found() {
+		t.Errorf("unexpected directory found in empty database")
+	}
+
+	if _, ok := di.Lookup(md1, id1); ok {
+		t.Errorf("unexpected entry found in empty database")
+	}
+
+	di.Set(md1, id1, oid1)
+	di.Set(md2, id2, oid2)
+	di.SetDir(123, dirOID)
+	if err := di.Save(); err != nil {
+		t.Fatalf("unable to save: %v", err)
+	}
+
+	// Reopen the database and verify that entries have been persisted.
+	db.Close()
+	db, err = NewHashCacheDB(filename)
+	if err != nil {
+		t.Fatalf("unable to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	di = db.OpenDir("/some/dir")
+	if !di.Found() {
+		t.Errorf("directory not found")
+	}
+
+	if oid, ok := di.Lookup(md1, id1); !ok || oid.String() != oid1.String() {
+		t.Errorf("unexpected lookup result for f1: %v %v", oid, ok)
+	}
+
+	if oid, ok := di.LookupDir(123); !ok || oid.String() != dirOID.String() {
+		t.Errorf("unexpected directory lookup result: %v %v", oid, ok)
+	}
+
+	if _, ok := di.LookupDir(124); ok {
+		t.Errorf("unexpected directory lookup result for different hash")
+	}
+
+	// Changed inode or change time must not match.
+	if _, ok := di.Lookup(md2, fs.LocalIdentity{Inode: 3, ChangeTime: id2.ChangeTime}); ok {
+		t.Errorf("unexpected match of different inode")
+	}
+
+	if _, ok := di.Lookup(md2, fs.LocalIdentity{Inode: id2.Inode, ChangeTime: time.Unix(3000, 0)}); ok {
+		t.Errorf("unexpected match of different change time")
+	}
+
+	// f2 has not been looked up successfully, so it gets removed.
+	if err := di.Save(); err != nil {
+		t.Fatalf("unable to save: %v", err)
+	}
+
+	di = db.OpenDir("/some/dir")
+	if _, ok := di.Lookup(md2, id2); ok {
+		t.Errorf("unexpected entry found after removal")
+	}
+
+	if _, ok := di.Lookup(md1, id1); !ok {
+		t.Errorf("entry not found")
+	}
+}
@@ -11,6 +11,7 @@ import (
 type Reader interface {
 	FindEntry(relativeName string) *Entry
 	CopyTo(w Writer) error
+	CopyUntil(w Writer, lastName string) error
 }
 
 type reader struct {
@@ -49,6 +50,18 @@ func (hcr *reader) CopyTo(w Writer) error {
 	return nil
 }
 
+// CopyUntil copies remaining entries with names up to and including lastName.
+func (hcr *reader) CopyUntil(w Writer, lastName string) error {
+	for hcr.nextEntry != nil && isLessOrEqual(hcr.nextEntry.Name, lastName) {
+		if err := w.WriteEntry(*hcr.nextEntry); err != nil {
+			return err
+		}
+		hcr.readahead()
+	}
+
+	return nil
+}
+
 func (hcr *reader) readahead() {
 	if hcr.reader != nil {
 		hcr.nextEntry = nil
@@ -84,6 +97,10 @@ func (*nullReader) CopyTo(w Writer) error {
 	return nil
 }
 
+func (*nullReader) CopyUntil(w Writer, lastName string) error {
+	return nil
+}
+
 // Open starts reading hash cache content.
 func Open(r io.Reader) Reader {
 	if r == nil {
@@ -0,0 +1,222 @@
+// Package ignore implements matching of paths against rules read from .kopiaignore files,
+// which use the syntax of .gitignore files.
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/kopia/kopia/fs"
+)
+
+// FileName is the name of the file containing ignore rules for the directory and all its subdirectories.
+const FileName = ".kopiaignore"
+
+// rule is a single pattern read from an ignore file.
+type rule struct {
+	segments []string // pattern split into path segments, "**" matches zero or more segments
+	negate   bool     // the pattern re-includes previously ignored paths
+	dirOnly  bool     // the pattern matches only directories
+	anchored bool     // the pattern is matched against the path relative to the directory containing the ignore file
+}
+
+// Matcher evaluates ignore rules of a directory and all its parent directories.
+// The zero value of *Matcher (nil) does not ignore anything.
+type Matcher struct {
+	parent *Matcher
+	dir    string // path of the directory containing the rules, relative to the root
+	rules  []rule
+}
+
+// Child returns a Matcher for the subdirectory at the specified path relative to the root, which adds rules
+// parsed from the contents of its ignore file to the rules of m. Rules of the subdirectory take precedence.
+func (m *Matcher) Child(dir string, contents []byte) *Matcher {
+	rules := parseRules(contents)
+	if len(rules) == 0 {
+		return m
+	}
+
+	return &Matcher{
+		parent: m,
+		dir:    path.Clean(dir),
+		rules:  rules,
+	}
+}
+
+// ForDirectory returns a Matcher for the directory at the specified path relative to the root,
+// which includes rules from the ignore file found among the directory entries, if any.
+func (m *Matcher) ForDirectory(dir string, entries fs.Entries) (*Matcher, error) {
+	for _, e := range entries {
+		f, ok := e.(fs.File)
+		if !ok || e.Metadata().Name != FileName {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return m, fmt.Errorf("unable to open %v: %v", path.Join(dir, FileName), err)
+		}
+		defer r.Close()
+
+		contents, err := ioutil.ReadAll(r)
+		if err != nil {
+			return m, fmt.Errorf("unable to read %v: %v", path.Join(dir, FileName), err)
+		}
+
+		return m.Child(dir, contents), nil
+	}
+
+	return m, nil
+}
+
+// Ignored determines whether the entry at the specified path relative to the root should be ignored.
+// Both "./a/b" and "a/b" forms of the path are accepted.
+func (m *Matcher) Ignored(relativePath string, isDir bool) bool {
+	p := path.Clean(relativePath)
+
+	for l := m; l != nil; l = l.parent {
+		rel := p
+		if l.dir != "." {
+			if !strings.HasPrefix(p, l.dir+"/") {
+				continue
+			}
+			rel = p[len(l.dir)+1:]
+		}
+
+		// The last matching rule wins.
+		for i := len(l.rules) - 1; i >= 0; i-- {
+			if l.rules[i].matches(rel, isDir) {
+				return !l.rules[i].negate
+			}
+		}
+	}
+
+	return false
+}
+
+// PathMatches determines whether the path relative to the root matches the pattern anchored at the root,
+// which may contain "**" to match any number of directories.
+func PathMatches(pattern string, relativePath string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	return segmentsMatch(strings.Split(pattern, "/"), strings.Split(path.Clean(relativePath), "/"))
+}
+
+func (r *rule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	if !r.anchored {
+		return segmentMatches(r.segments[0], path.Base(rel))
+	}
+
+	return segmentsMatch(r.segments, strings.Split(rel, "/"))
+}
+
+func segmentsMatch(pattern []string, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Trailing "**" matches everything inside, but not the directory itself.
+			if len(pattern) == 1 {
+				return len(segments) > 0
+			}
+
+			// "**" matches zero or more segments.
+			for i := 0; i <= len(segments); i++ {
+				if segmentsMatch(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(segments) == 0 || !segmentMatches(pattern[0], segments[0]) {
+			return false
+		}
+
+		pattern = pattern[1:]
+		segments = segments[1:]
+	}
+
+	return len(segments) == 0
+}
+
+func segmentMatches(pattern string, name string) bool {
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
+
+func parseRules(contents []byte) []rule {
+	var rules []rule
+
+	s := bufio.NewScanner(bytes.NewReader(contents))
+	for s.Scan() {
+		if r, ok := parseRule(s.Text()); ok {
+			rules = append(rules, r)
+		}
+	}
+
+	return rules
+}
+
+func parseRule(line string) (rule, bool) {
+	var r rule
+
+	line = strings.TrimSuffix(line, "\r")
+	line = trimTrailingSpaces(line)
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return r, false
+	}
+
+	switch {
+	case strings.HasPrefix(line, "!"):
+		r.negate = true
+		line = line[1:]
+
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// Patterns containing a slash anywhere but at the end are relative to the directory containing the ignore file.
+	if strings.Contains(line, "/") {
+		r.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return r, false
+	}
+
+	r.segments = strings.Split(line, "/")
+
+	// "**" on its own matches everything at any level.
+	if !r.anchored && r.segments[0] == "**" {
+		r.anchored = true
+	}
+
+	return r, true
+}
+
+// trimTrailingSpaces removes trailing spaces, unless they are escaped with a backslash.
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") {
+		if strings.HasSuffix(line, `\ `) {
+			return line[:len(line)-2] + " "
+		}
+
+		line = line[:len(line)-1]
+	}
+
+	return line
+}
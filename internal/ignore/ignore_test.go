@@ -0,0 +1,75 @@
+package ignore
+
+import "testing"
+
+func TestMatcher(t *testing.T) {
+	var root *Matcher
+	root = root.Child(".", []byte(`
+# comment
+*.log
+!keep.log
+/build
+tmp/
+docs/**/*.pdf
+**/cache
+a/**
+\#literal
+trailing\  
+`))
+	sub := root.Child("./src", []byte(`
+!*.log
+/generated
+`))
+
+	cases := []struct {
+		m       *Matcher
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{root, "./x.log", false, true},
+		{root, "./dir/x.log", false, true},
+		{root, "./keep.log", false, false},
+		{root, "./dir/keep.log", false, false},
+		{root, "./x.txt", false, false},
+		{root, "./build", true, true},
+		{root, "./dir/build", true, false},
+		{root, "./tmp", true, true},
+		{root, "./tmp", false, false},
+		{root, "./dir/tmp", true, true},
+		{root, "./docs/a.pdf", false, true},
+		{root, "./docs/x/y/a.pdf", false, true},
+		{root, "./x/docs/a.pdf", false, false},
+		{root, "./cache", true, true},
+		{root, "./x/y/cache", false, true},
+		{root, "./a", true, false},
+		{root, "./a/b", false, true},
+		{root, "./a/b/c", false, true},
+		{root, "./#literal", false, true},
+		{root, "./trailing ", false, true},
+		{root, "./comment", false, false},
+		{sub, "./src/x.log", false, false},
+		{sub, "./x.log", false, true},
+		{sub, "./src/generated", true, true},
+		{sub, "./src/x/generated", true, false},
+		{sub, "src/generated", false, true},
+		{sub, "./generated", false, false},
+	}
+
+	for _, tc := range cases {
+		if got := tc.m.Ignored(tc.path, tc.isDir); got != tc.ignored {
+			t.Errorf("unexpected result for %q (dir: %v): %v, expected %v", tc.path, tc.isDir, got, tc.ignored)
+		}
+	}
+}
+
+func TestMatcher_Empty(t *testing.T) {
+	var root *Matcher
+	if root.Ignored("./x", false) {
+		t.Errorf("nil matcher ignores files")
+	}
+
+	if m := root.Child(".", []byte("# comment only\n\n")); m != root {
+		t.Errorf("unexpected matcher for empty rules")
+	}
+}
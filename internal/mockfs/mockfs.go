@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/kopia/kopia/fs"
 )
@@ -84,6 +85,24 @@ func (imd *Directory) AddDir(name string, permissions fs.Permissions) *Directory
 	return subdir
 }
 
+// AddSpecial adds a mock device, named pipe or socket with a given name, type and permissions.
+func (imd *Directory) AddSpecial(name string, entryType fs.EntryType, permissions fs.Permissions) fs.Entry {
+	imd, name = imd.resolveSubdir(name)
+
+	special := &entry{
+		parent: imd,
+		metadata: &fs.EntryMetadata{
+			Name:        name,
+			Type:        entryType,
+			Permissions: permissions,
+		},
+	}
+
+	imd.addChild(special)
+
+	return special
+}
+
 func (imd *Directory) addChild(e fs.Entry) {
 	if strings.Contains(e.Metadata().Name, "/") {
 		panic("child name cannot contain '/'")
@@ -149,7 +168,28 @@ type File struct {
 	entry
 
 	source     func() (io.ReadCloser, error)
+	openError  error
 	closeError error
+
+	changesWhileReading int
+	changedMetadata     *fs.EntryMetadata
+}
+
+// ChangeWhileReading causes the modification time of the file to change while it's being read
+// by the specified number of subsequent Open() calls.
+func (imf *File) ChangeWhileReading(times int) {
+	imf.changesWhileReading = times
+}
+
+// ChangeMetadataWhileReading causes the metadata of the file to be replaced with the specified one by the subsequent Open() call,
+// such as when data is written into holes of a sparse file while it's being read.
+func (imf *File) ChangeMetadataWhileReading(md *fs.EntryMetadata) {
+	imf.changedMetadata = md
+}
+
+// FailOpen causes the subsequent Open() calls to fail with the specified error.
+func (imf *File) FailOpen(err error) {
+	imf.openError = err
 }
 
 type fileReader struct {
@@ -163,11 +203,27 @@ func (ifr *fileReader) EntryMetadata() (*fs.EntryMetadata, error) {
 
 // Open opens the file for reading, optionally simulating error.
 func (imf *File) Open() (fs.Reader, error) {
+	if imf.openError != nil {
+		return nil, imf.openError
+	}
+
 	r, err := imf.source()
 	if err != nil {
 		return nil, err
 	}
 
+	if imf.changedMetadata != nil {
+		imf.metadata = imf.changedMetadata
+		imf.changedMetadata = nil
+	}
+
+	if imf.changesWhileReading > 0 {
+		imf.changesWhileReading--
+		md := *imf.metadata
+		md.ModTime = md.ModTime.Add(time.Second)
+		imf.metadata = &md
+	}
+
 	return &fileReader{
 		ReadCloser: r,
 		metadata:   imf.metadata,
@@ -2,6 +2,7 @@
 package retry
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
@@ -21,8 +22,8 @@ type IsRetriableFunc func(err error) bool
 
 // WithExponentialBackoff runs the provided attempt until it succeeds, retrying on all errors that are
 // deemed retriable by the provided function. The delay between retries grows exponentially up to
-// a certain limit.
-func WithExponentialBackoff(desc string, attempt AttemptFunc, isRetriableError IsRetriableFunc) (interface{}, error) {
+// a certain limit. Retrying stops early when the provided context is cancelled.
+func WithExponentialBackoff(ctx context.Context, desc string, attempt AttemptFunc, isRetriableError IsRetriableFunc) (interface{}, error) {
 	sleepAmount := retryInitialSleepAmount
 	for i := 0; i < maxAttempts; i++ {
 		v, err := attempt()
@@ -30,7 +31,11 @@ func WithExponentialBackoff(desc string, attempt AttemptFunc, isRetriableError I
 			return v, err
 		}
 		log.Printf("got error %v when %v (#%v), sleeping for %v before retrying", err, desc, i, sleepAmount)
-		time.Sleep(sleepAmount)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(sleepAmount):
+		}
 		sleepAmount *= 2
 		if sleepAmount > retryMaxSleepAmount {
 			sleepAmount = retryMaxSleepAmount
@@ -0,0 +1,145 @@
+package repo
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ObjectFormatBenchmark contains throughput of a single object format in bytes per second.
+// Encryption and decryption throughput of unencrypted formats is zero.
+type ObjectFormatBenchmark struct {
+	ObjectFormat string  `json:"objectFormat"`
+	Hashing      float64 `json:"hashing"`
+	Encryption   float64 `json:"encryption"`
+	Decryption   float64 `json:"decryption"`
+}
+
+// BenchmarkObjectFormats measures hashing, encryption and decryption throughput of all supported object formats
+// by processing an in-memory block of the specified size repeatedly.
+func BenchmarkObjectFormats(blockSize int, repeat int) ([]ObjectFormatBenchmark, error) {
+	data := make([]byte, blockSize)
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+
+	var result []ObjectFormatBenchmark
+	for _, name := range SupportedObjectFormats {
+		f := repositoryObjectFormatFromOptions(&NewRepositoryOptions{ObjectFormat: name})
+		formatter, err := objectFormatterFactories[name](&f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create object format %v: %v", name, err)
+		}
+
+		_, unencrypted := formatter.(*unencryptedFormat)
+		b := ObjectFormatBenchmark{ObjectFormat: name}
+		buf := make([]byte, blockSize)
+		var oid ObjectID
+		var hashing, encryption, decryption time.Duration
+
+		for i := 0; i < repeat; i++ {
+			t0 := time.Now()
+			oid = formatter.ComputeObjectID(data)
+			hashing += time.Since(t0)
+
+			if unencrypted {
+				continue
+			}
+
+			// Encrypt() and Decrypt() may reuse the input slice.
+			copy(buf, data)
+			t0 = time.Now()
+			cipherText, err := formatter.Encrypt(buf, oid, 0)
+			encryption += time.Since(t0)
+			if err != nil {
+				return nil, fmt.Errorf("unable to encrypt using %v: %v", name, err)
+			}
+
+			t0 = time.Now()
+			_, err = formatter.Decrypt(cipherText, oid, 0)
+			decryption += time.Since(t0)
+			if err != nil {
+				return nil, fmt.Errorf("unable to decrypt using %v: %v", name, err)
+			}
+		}
+
+		total := float64(blockSize) * float64(repeat)
+		b.Hashing = bytesPerSecond(total, hashing)
+		b.Encryption = bytesPerSecond(total, encryption)
+		b.Decryption = bytesPerSecond(total, decryption)
+		result = append(result, b)
+	}
+
+	return result, nil
+}
+
+// ObjectSplitterBenchmark contains throughput of a single object splitter and sizes of blocks it produced.
+type ObjectSplitterBenchmark struct {
+	Splitter       string  `json:"splitter"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+	BlockSizes     []int   `json:"blockSizes"` // sorted in ascending order
+}
+
+// BlockSizePercentile returns the p-th percentile of block sizes produced by the splitter.
+func (b *ObjectSplitterBenchmark) BlockSizePercentile(p int) int {
+	if len(b.BlockSizes) == 0 {
+		return 0
+	}
+
+	rank := (p*len(b.BlockSizes) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return b.BlockSizes[rank-1]
+}
+
+// BenchmarkObjectSplitters splits the specified amount of random in-memory data using all supported object splitters
+// configured with block sizes from the provided options.
+func BenchmarkObjectSplitters(opt *NewRepositoryOptions, dataSize int) ([]ObjectSplitterBenchmark, error) {
+	if opt == nil {
+		opt = &NewRepositoryOptions{}
+	}
+
+	data := make([]byte, dataSize)
+	if _, err := rand.Read(data); err != nil {
+		return nil, err
+	}
+
+	f := repositoryObjectFormatFromOptions(opt)
+
+	var result []ObjectSplitterBenchmark
+	for _, name := range SupportedObjectSplitters {
+		splitter := objectSplitterFactories[name](&f)
+		b := ObjectSplitterBenchmark{Splitter: name}
+
+		t0 := time.Now()
+		last := 0
+		for i, d := range data {
+			if splitter.add(d) {
+				b.BlockSizes = append(b.BlockSizes, i+1-last)
+				last = i + 1
+			}
+		}
+		dt := time.Since(t0)
+
+		if last < len(data) {
+			b.BlockSizes = append(b.BlockSizes, len(data)-last)
+		}
+
+		sort.Ints(b.BlockSizes)
+		b.BytesPerSecond = bytesPerSecond(float64(dataSize), dt)
+		result = append(result, b)
+	}
+
+	return result, nil
+}
+
+func bytesPerSecond(bytes float64, dt time.Duration) float64 {
+	if dt <= 0 {
+		return 0
+	}
+
+	return bytes / dt.Seconds()
+}
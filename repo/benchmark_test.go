@@ -0,0 +1,56 @@
+package repo
+
+import "testing"
+
+func TestBenchmarkObjectFormats(t *testing.T) {
+	res, err := BenchmarkObjectFormats(10000, 3)
+	if err != nil {
+		t.Fatalf("benchmark failed: %v", err)
+	}
+
+	if len(res) != len(SupportedObjectFormats) {
+		t.Errorf("unexpected number of results: %v, expected %v", len(res), len(SupportedObjectFormats))
+	}
+}
+
+func TestBenchmarkObjectSplitters(t *testing.T) {
+	const dataSize = 100000
+
+	res, err := BenchmarkObjectSplitters(&NewRepositoryOptions{
+		MinBlockSize: 1000,
+		AvgBlockSize: 2000,
+		MaxBlockSize: 4000,
+	}, dataSize)
+	if err != nil {
+		t.Fatalf("benchmark failed: %v", err)
+	}
+
+	for _, b := range res {
+		total := 0
+		for _, s := range b.BlockSizes {
+			total += s
+		}
+
+		if total != dataSize {
+			t.Errorf("block sizes of %v add up to %v, expected %v", b.Splitter, total, dataSize)
+		}
+
+		switch b.Splitter {
+		case "NEVER":
+			if len(b.BlockSizes) != 1 {
+				t.Errorf("unexpected number of blocks produced by %v: %v", b.Splitter, len(b.BlockSizes))
+			}
+
+		case "FIXED":
+			if got := b.BlockSizePercentile(50); got != 4000 {
+				t.Errorf("unexpected median block size of %v: %v", b.Splitter, got)
+			}
+
+		case "DYNAMIC":
+			// only the last block may be smaller than minimum
+			if min, max := b.BlockSizes[1], b.BlockSizePercentile(100); min < 1000 || max > 4000 {
+				t.Errorf("block sizes of %v out of bounds: %v..%v", b.Splitter, min, max)
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package repo
 
 import (
+	"context"
 	"log"
 	"sync"
 
@@ -8,7 +9,8 @@ import (
 )
 
 type blockSizeCache struct {
-	storage blob.Storage
+	ctx     context.Context
+	storage blob.StreamingStorage
 
 	mu        sync.Mutex
 	cache     map[string]int64
@@ -43,7 +45,7 @@ func (c *blockSizeCache) getSize(blockID string) (int64, error) {
 		return 0, blob.ErrBlockNotFound
 	}
 
-	s, err := c.storage.BlockSize(blockID)
+	s, err := c.storage.BlockSize(c.ctx, blockID)
 	if err == nil {
 		c.mu.Lock()
 		c.cache[blockID] = size
@@ -54,7 +56,7 @@ func (c *blockSizeCache) getSize(blockID string) (int64, error) {
 }
 
 func (c *blockSizeCache) populate(prefix string) {
-	ch, cancel := c.storage.ListBlocks(prefix)
+	ch, cancel := c.storage.ListBlocks(c.ctx, prefix)
 	defer cancel()
 
 	m := map[string]int64{}
@@ -75,8 +77,9 @@ func (c *blockSizeCache) populate(prefix string) {
 	c.mu.Unlock()
 }
 
-func newBlockSizeCache(s blob.Storage) *blockSizeCache {
+func newBlockSizeCache(ctx context.Context, s blob.StreamingStorage) *blockSizeCache {
 	c := &blockSizeCache{
+		ctx:       ctx,
 		storage:   s,
 		cache:     map[string]int64{},
 		completed: map[string]bool{},
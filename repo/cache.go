@@ -0,0 +1,51 @@
+package repo
+
+import (
+	"strings"
+	"sync"
+)
+
+// metadataPackBlockPrefix is a prefix of storage blocks holding packs of non-default pack groups,
+// which are used for directory listings and hash caches.
+const metadataPackBlockPrefix = "P"
+
+// hashCacheBlockPrefix is a prefix of storage blocks holding hash caches written by snapshot uploads.
+const hashCacheBlockPrefix = "H"
+
+var (
+	immutableMetadataPrefixesMutex sync.RWMutex
+	immutableMetadataPrefixes      = []string{packIDPrefix}
+)
+
+// RegisterImmutableMetadataPrefix declares that metadata items with the specified prefix are never modified
+// after they have been written, which allows them to be cached locally.
+func RegisterImmutableMetadataPrefix(prefix string) {
+	immutableMetadataPrefixesMutex.Lock()
+	defer immutableMetadataPrefixesMutex.Unlock()
+
+	immutableMetadataPrefixes = append(immutableMetadataPrefixes, prefix)
+}
+
+// isImmutableMetadata determines whether the metadata item has a prefix registered with RegisterImmutableMetadataPrefix.
+func isImmutableMetadata(itemID string) bool {
+	immutableMetadataPrefixesMutex.RLock()
+	defer immutableMetadataPrefixesMutex.RUnlock()
+
+	for _, p := range immutableMetadataPrefixes {
+		if strings.HasPrefix(itemID, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shouldCacheBlock determines whether the storage block should be cached locally.
+// Only immutable blocks that are read repeatedly when browsing snapshots are cached.
+func shouldCacheBlock(blockID string) bool {
+	if strings.HasPrefix(blockID, MetadataBlockPrefix) {
+		return isImmutableMetadata(blockID[len(MetadataBlockPrefix):])
+	}
+
+	return strings.HasPrefix(blockID, metadataPackBlockPrefix) || strings.HasPrefix(blockID, hashCacheBlockPrefix)
+}
@@ -0,0 +1,23 @@
+package repo
+
+import "testing"
+
+func TestShouldCacheBlock(t *testing.T) {
+	RegisterImmutableMetadataPrefix("Z")
+
+	cases := map[string]bool{
+		MetadataBlockPrefix + packIDPrefix + "1234": true,
+		MetadataBlockPrefix + "Z1234":               true,
+		MetadataBlockPrefix + "Q1234":               false,
+		MetadataBlockPrefix + "format":              false,
+		metadataPackBlockPrefix + "1234":            true,
+		hashCacheBlockPrefix + "1234":               true,
+		"1234abcd":                                  false,
+	}
+
+	for blockID, want := range cases {
+		if got := shouldCacheBlock(blockID); got != want {
+			t.Errorf("invalid result of shouldCacheBlock(%q): %v, want %v", blockID, got, want)
+		}
+	}
+}
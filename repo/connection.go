@@ -6,12 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 
 	"github.com/kopia/kopia/auth"
 	"github.com/kopia/kopia/blob"
+	"github.com/kopia/kopia/blob/caching"
 	"github.com/kopia/kopia/blob/logging"
+	"github.com/kopia/kopia/blob/metrics"
+	"github.com/kopia/kopia/blob/throttling"
 	"github.com/kopia/kopia/internal/config"
 
 	// Register well-known blob storage providers
@@ -21,10 +25,13 @@ import (
 
 // Options provides configuration parameters for connection to a repository.
 type Options struct {
-	CredentialsCallback func() (auth.Credentials, error)    // Provides credentials required to open the repository if not persisted.
-	TraceStorage        func(f string, args ...interface{}) // Logs all storage access using provided Printf-style function
-	TraceObjectManager  func(f string, args ...interface{}) // Logs all object manager activity using provided Printf-style function
-	WriteBack           int                                 // Causes all object writes to be asynchronous with the specified number of workers.
+	CredentialsCallback   func() (auth.Credentials, error)    // Provides credentials required to open the repository if not persisted.
+	TraceStorage          func(f string, args ...interface{}) // Logs all storage access using provided Printf-style function
+	TraceObjectManager    func(f string, args ...interface{}) // Logs all object manager activity using provided Printf-style function
+	WriteBack             int                                 // Causes all object writes to be asynchronous with the specified number of workers.
+	Throttling            *throttling.Limits                  // Limits upload and download speeds, overrides limits persisted in the configuration file.
+	StorageMetrics        *metrics.Collector                  // Collects metrics of all storage operations.
+	PrivilegedCredentials auth.Credentials                    // Bypasses write-once restrictions of repositories that require them.
 }
 
 // Open opens a Repository specified in the configuration file.
@@ -62,14 +69,26 @@ func Open(ctx context.Context, configFile string, options *Options) (*Repository
 		return nil, fmt.Errorf("cannot open storage: %v", err)
 	}
 
-	r, err := connect(ctx, st, creds, options)
+	if options.Throttling == nil && lc.Throttling != nil {
+		o := *options
+		o.Throttling = lc.Throttling
+		options = &o
+	}
+
+	cacheDirectory := applyDefaultString(lc.CacheDirectory, filepath.Join(filepath.Dir(configFile), "cache"))
+
+	r, err := connect(ctx, st, creds, options, &caching.Options{
+		CacheDir:       filepath.Join(cacheDirectory, "blocks"),
+		CacheSizeBytes: lc.MaxCacheSizeBytes,
+		ShouldCache:    shouldCacheBlock,
+	})
 	if err != nil {
 		st.Close()
 		return nil, err
 	}
 
 	r.ConfigFile = configFile
-	r.CacheDirectory = applyDefaultString(lc.CacheDirectory, filepath.Join(filepath.Dir(configFile), "cache"))
+	r.CacheDirectory = cacheDirectory
 
 	return r, nil
 }
@@ -78,11 +97,13 @@ func Open(ctx context.Context, configFile string, options *Options) (*Repository
 type ConnectOptions struct {
 	PersistCredentials bool
 	CacheDirectory     string
+	MaxCacheSizeBytes  int64
+	Throttling         *throttling.Limits
 }
 
 // Connect connects to the repository in the specified storage and persists the configuration and credentials in the file provided.
 func Connect(ctx context.Context, configFile string, st blob.Storage, creds auth.Credentials, opt ConnectOptions) error {
-	r, err := connect(ctx, st, creds, nil)
+	r, err := connect(ctx, st, creds, &Options{Throttling: opt.Throttling}, nil)
 	if err != nil {
 		return err
 	}
@@ -94,6 +115,9 @@ func Connect(ctx context.Context, configFile string, st blob.Storage, creds auth
 
 	var lc config.LocalConfig
 	lc.Connection = cfg
+	lc.CacheDirectory = opt.CacheDirectory
+	lc.MaxCacheSizeBytes = opt.MaxCacheSizeBytes
+	lc.Throttling = opt.Throttling
 
 	if !opt.PersistCredentials {
 		lc.Connection.Key = nil
@@ -111,20 +135,51 @@ func Connect(ctx context.Context, configFile string, st blob.Storage, creds auth
 	return ioutil.WriteFile(configFile, d, 0600)
 }
 
-func connect(ctx context.Context, st blob.Storage, creds auth.Credentials, options *Options) (*Repository, error) {
+// connect opens the repository in the provided storage. When cacheOptions are provided, blocks
+// accepted by cacheOptions.ShouldCache are cached locally, otherwise the cache is disabled.
+func connect(ctx context.Context, s blob.Storage, creds auth.Credentials, options *Options, cacheOptions *caching.Options) (*Repository, error) {
 	if options == nil {
 		options = &Options{}
 	}
+
+	st := blob.ToStreaming(s)
+	if options.StorageMetrics != nil {
+		st = metrics.NewWrapper(st, options.StorageMetrics)
+	}
+
+	if options.Throttling != nil {
+		var err error
+		if st, err = throttling.NewWrapper(st, *options.Throttling); err != nil {
+			return nil, fmt.Errorf("invalid throttling limits: %v", err)
+		}
+	}
+
+	var cache caching.StatsProvider
+	if cacheOptions != nil {
+		cst, err := caching.NewWrapper(ctx, st, cacheOptions)
+		if err == nil {
+			st = cst
+			cache, _ = cst.(caching.StatsProvider)
+		} else {
+			log.Printf("warning: unable to open local cache, continuing without it: %v", err)
+		}
+	}
+
 	if options.TraceStorage != nil {
 		st = logging.NewWrapper(st, logging.Prefix("[STORAGE] "), logging.Output(options.TraceStorage))
 	}
 
-	mm, err := newMetadataManager(st, creds)
+	mm, err := newMetadataManager(ctx, st, creds)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open metadata manager: %v", err)
 	}
 
-	om, err := newObjectManager(st, mm.repoConfig.Format, options)
+	if st, err = applyWriteOnce(st, &mm.format, options.PrivilegedCredentials); err != nil {
+		return nil, err
+	}
+	mm.storage = st
+
+	om, err := newObjectManager(ctx, st, mm.repoConfig.Format, options)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open object manager: %v", err)
 	}
@@ -133,6 +188,7 @@ func connect(ctx context.Context, st blob.Storage, creds auth.Credentials, optio
 		ObjectManager:   om,
 		MetadataManager: mm,
 		Storage:         st,
+		cache:           cache,
 	}
 
 	r.initPackManager()
@@ -0,0 +1,212 @@
+package repo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kopia/kopia/blob"
+)
+
+// ErrIncompatibleObjectFormat is returned when objects can't be copied between repositories without changing their IDs.
+var ErrIncompatibleObjectFormat = errors.New("repositories have incompatible object formats")
+
+// CopyStats contains statistics of objects copied between repositories.
+type CopyStats struct {
+	CopiedBlocks  int64 `json:"copiedBlocks"`
+	CopiedBytes   int64 `json:"copiedBytes"`
+	SkippedBlocks int64 `json:"skippedBlocks"`
+}
+
+// ObjectCopier copies objects between repositories with compatible object formats while preserving their IDs.
+// Storage blocks are decrypted using the source repository keys and re-encrypted using destination keys
+// without being split and hashed again, and blocks already present in the destination are skipped.
+type ObjectCopier struct {
+	stats CopyStats // must be first for alignment of atomic access
+
+	src *Repository
+	dst *Repository
+
+	mu      sync.Mutex
+	visited map[string]bool
+}
+
+// NewObjectCopier returns an ObjectCopier that copies objects from src to dst repository or ErrIncompatibleObjectFormat
+// if the repositories compute different IDs for the same content or split objects differently.
+func NewObjectCopier(dst, src *Repository) (*ObjectCopier, error) {
+	if err := checkObjectFormatsCompatible(dst.ObjectManager, src.ObjectManager); err != nil {
+		return nil, err
+	}
+
+	return &ObjectCopier{
+		src:     src,
+		dst:     dst,
+		visited: map[string]bool{},
+	}, nil
+}
+
+// Copy copies all storage blocks of the specified object.
+func (c *ObjectCopier) Copy(oid ObjectID) error {
+	switch {
+	case oid.Section != nil:
+		return c.Copy(oid.Section.Base)
+
+	case oid.Indirect != nil:
+		if err := c.Copy(*oid.Indirect); err != nil {
+			return err
+		}
+
+		rd, err := c.src.Open(*oid.Indirect)
+		if err != nil {
+			return err
+		}
+		defer rd.Close()
+
+		seekTable, err := c.src.flattenListChunk(rd)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range seekTable {
+			if err := c.Copy(e.Object); err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	case oid.StorageBlock != "":
+		return c.copyBlock(oid.StorageBlock)
+
+	default:
+		// inline content
+		return nil
+	}
+}
+
+func (c *ObjectCopier) copyBlock(blockID string) error {
+	c.mu.Lock()
+	visited := c.visited[blockID]
+	c.visited[blockID] = true
+	c.mu.Unlock()
+
+	if visited {
+		return nil
+	}
+
+	exists, err := c.dst.blockExists(blockID)
+	if err != nil {
+		return fmt.Errorf("unable to determine whether block %v exists: %v", blockID, err)
+	}
+
+	if exists {
+		atomic.AddInt64(&c.stats.SkippedBlocks, 1)
+		return nil
+	}
+
+	data, err := c.src.readBlock(blockID)
+	if err != nil {
+		return fmt.Errorf("unable to read block %v: %v", blockID, err)
+	}
+
+	packGroup, packed, err := c.src.packMgr.packGroupOf(blockID)
+	if err != nil {
+		return err
+	}
+
+	length := int64(len(data))
+	if err := c.dst.writeBlock(packGroup, packed, blockID, data); err != nil {
+		return fmt.Errorf("unable to write block %v: %v", blockID, err)
+	}
+
+	atomic.AddInt64(&c.stats.CopiedBlocks, 1)
+	atomic.AddInt64(&c.stats.CopiedBytes, length)
+	return nil
+}
+
+// Stats returns statistics of copied objects.
+func (c *ObjectCopier) Stats() CopyStats {
+	return CopyStats{
+		CopiedBlocks:  atomic.LoadInt64(&c.stats.CopiedBlocks),
+		CopiedBytes:   atomic.LoadInt64(&c.stats.CopiedBytes),
+		SkippedBlocks: atomic.LoadInt64(&c.stats.SkippedBlocks),
+	}
+}
+
+// blockExists determines whether the specified storage block exists in the repository, either packed or standalone.
+func (r *ObjectManager) blockExists(blockID string) (bool, error) {
+	if _, packed, err := r.packMgr.blockIDToPackSection(blockID); err != nil || packed {
+		return packed, err
+	}
+
+	switch _, err := r.blockSizeCache.getSize(blockID); err {
+	case nil:
+		return true, nil
+	case blob.ErrBlockNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// writeBlock encrypts and writes the storage block with the specified ID, which must match its contents.
+// Blocks that were packed in the source repository are added to the same pack group if packing is enabled.
+func (r *ObjectManager) writeBlock(packGroup string, packed bool, blockID string, data []byte) error {
+	if err := r.verifyChecksum(data, blockID); err != nil {
+		return err
+	}
+
+	if packed && r.packMgr.enabled() {
+		_, err := r.packMgr.AddToPack(packGroup, blockID, data)
+		return err
+	}
+
+	atomic.AddInt64(&r.stats.EncryptedBytes, int64(len(data)))
+	data, err := r.formatter.Encrypt(data, ObjectID{StorageBlock: blockID}, 0)
+	if err != nil {
+		return err
+	}
+
+	atomic.AddInt32(&r.stats.WrittenBlocks, int32(1))
+	atomic.AddInt64(&r.stats.WrittenBytes, int64(len(data)))
+
+	return r.storage.PutBlock(r.ctx, blockID, bytes.NewReader(data))
+}
+
+// checkObjectFormatsCompatible verifies that both object managers compute the same object IDs
+// for the same content, so that objects can be copied without rewriting objects that reference them.
+func checkObjectFormatsCompatible(dst, src *ObjectManager) error {
+	for _, probe := range [][]byte{nil, []byte("kopia"), bytes.Repeat([]byte{0xaa}, 1000)} {
+		if dst.formatter.ComputeObjectID(probe).StorageBlock != src.formatter.ComputeObjectID(probe).StorageBlock {
+			return ErrIncompatibleObjectFormat
+		}
+	}
+
+	df, sf := dst.format, src.format
+	if applyDefaultString(df.Splitter, "FIXED") != applyDefaultString(sf.Splitter, "FIXED") ||
+		df.MinBlockSize != sf.MinBlockSize ||
+		df.AvgBlockSize != sf.AvgBlockSize ||
+		df.MaxBlockSize != sf.MaxBlockSize {
+		return ErrIncompatibleObjectFormat
+	}
+
+	return nil
+}
+
+// CompatibleRepositoryOptions returns options for creating a new repository, to which objects can be copied
+// from this repository using ObjectCopier. The new repository will use different encryption keys.
+func (r *Repository) CompatibleRepositoryOptions() *NewRepositoryOptions {
+	f := r.ObjectManager.format
+	return &NewRepositoryOptions{
+		ObjectFormat:           f.ObjectFormat,
+		ObjectHMACSecret:       f.HMACSecret,
+		Splitter:               f.Splitter,
+		MinBlockSize:           f.MinBlockSize,
+		AvgBlockSize:           f.AvgBlockSize,
+		MaxBlockSize:           f.MaxBlockSize,
+		MaxPackedContentLength: f.MaxPackedContentLength,
+		MaxPackFileLength:      f.MaxPackFileLength,
+	}
+}
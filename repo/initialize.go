@@ -1,10 +1,13 @@
 package repo
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/kopia/kopia/auth"
 	"github.com/kopia/kopia/blob"
@@ -29,17 +32,23 @@ type NewRepositoryOptions struct {
 	MaxPackedContentLength int    // maximum size of object to be considered for storage in a pack
 	MaxPackFileLength      int    // maximum length of a single pack file
 
+	WriteOnce             bool             // require all clients to access the repository in write-once mode
+	MinRetentionPeriod    time.Duration    // minimum age of blocks that can be deleted in write-once mode, zero means never
+	PrivilegedCredentials auth.Credentials // credentials that bypass write-once restrictions, none if nil
+
 	// test-only
 	noHMAC bool // disable HMAC
 }
 
 // Initialize creates initial repository data structures in the specified storage with given credentials.
-func Initialize(st blob.Storage, opt *NewRepositoryOptions, creds auth.Credentials) error {
+func Initialize(ctx context.Context, s blob.Storage, opt *NewRepositoryOptions, creds auth.Credentials) error {
 	if opt == nil {
 		opt = &NewRepositoryOptions{}
 	}
 
+	st := blob.ToStreaming(s)
 	mm := MetadataManager{
+		ctx:     ctx,
 		storage: st,
 		format:  metadataFormatFromOptions(opt),
 	}
@@ -50,12 +59,21 @@ func Initialize(st blob.Storage, opt *NewRepositoryOptions, creds auth.Credentia
 		return err
 	}
 
+	if opt.WriteOnce {
+		mm.format.WriteOnce, err = writeOnceFormatFromOptions(opt, mm.format.SecurityOptions, mm.masterKey)
+		if err != nil {
+			return fmt.Errorf("invalid write-once options: %v", err)
+		}
+
+		mm.format.RequiredFeatures = append(mm.format.RequiredFeatures, featureWriteOnce)
+	}
+
 	formatBytes, err := json.Marshal(&mm.format)
 	if err != nil {
 		return err
 	}
 
-	if err := st.PutBlock(MetadataBlockPrefix+formatBlockID, formatBytes); err != nil {
+	if err := st.PutBlock(ctx, MetadataBlockPrefix+formatBlockID, bytes.NewReader(formatBytes)); err != nil {
 		return err
 	}
 
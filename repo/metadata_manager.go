@@ -1,6 +1,8 @@
 package repo
 
 import (
+	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -58,7 +60,8 @@ func init() {
 // MetadataManager manages JSON metadata, such as snapshot manifests, policies, object format etc.
 // in a repository.
 type MetadataManager struct {
-	storage    blob.Storage
+	ctx        context.Context
+	storage    blob.StreamingStorage
 	format     config.MetadataFormat
 	repoConfig config.EncryptedRepositoryConfig
 
@@ -94,11 +97,11 @@ func (mm *MetadataManager) writeEncryptedBlock(itemID string, content []byte) er
 		content = nonce[0 : nonceLength+len(b)]
 	}
 
-	return mm.storage.PutBlock(MetadataBlockPrefix+itemID, content)
+	return mm.storage.PutBlock(mm.ctx, MetadataBlockPrefix+itemID, bytes.NewReader(content))
 }
 
 func (mm *MetadataManager) readEncryptedBlock(itemID string) ([]byte, error) {
-	content, err := mm.storage.GetBlock(MetadataBlockPrefix+itemID, 0, -1)
+	content, err := blob.GetBlockBytes(mm.ctx, mm.storage, MetadataBlockPrefix+itemID, 0, -1)
 	if err != nil {
 		if err == blob.ErrBlockNotFound {
 			return nil, ErrMetadataNotFound
@@ -207,7 +210,7 @@ func (mm *MetadataManager) putJSON(id string, content interface{}) error {
 func (mm *MetadataManager) ListMetadata(prefix string, limit int) ([]string, error) {
 	var result []string
 
-	ch, cancel := mm.storage.ListBlocks(MetadataBlockPrefix + prefix)
+	ch, cancel := mm.storage.ListBlocks(mm.ctx, MetadataBlockPrefix+prefix)
 	defer cancel()
 	for b := range ch {
 		if limit == 0 {
@@ -260,7 +263,7 @@ func (mm *MetadataManager) RemoveMetadata(itemID string) error {
 		return err
 	}
 
-	return mm.storage.DeleteBlock(MetadataBlockPrefix + itemID)
+	return mm.storage.DeleteBlock(mm.ctx, MetadataBlockPrefix+itemID)
 }
 
 // RemoveMany efficiently removes multiple metadata items in parallel.
@@ -294,8 +297,9 @@ func (mm *MetadataManager) RemoveMany(itemIDs []string) error {
 }
 
 // newMetadataManager opens a MetadataManager for given storage and credentials.
-func newMetadataManager(st blob.Storage, creds auth.Credentials) (*MetadataManager, error) {
+func newMetadataManager(ctx context.Context, st blob.StreamingStorage, creds auth.Credentials) (*MetadataManager, error) {
 	mm := MetadataManager{
+		ctx:     ctx,
 		storage: st,
 	}
 
@@ -304,7 +308,7 @@ func newMetadataManager(st blob.Storage, creds auth.Credentials) (*MetadataManag
 	var blocks [4][]byte
 
 	f := func(index int, name string) {
-		blocks[index], _ = st.GetBlock(name, 0, -1)
+		blocks[index], _ = blob.GetBlockBytes(ctx, st, name, 0, -1)
 		wg.Done()
 	}
 
@@ -323,6 +327,10 @@ func newMetadataManager(st blob.Storage, creds auth.Credentials) (*MetadataManag
 		return nil, err
 	}
 
+	if err := checkRequiredFeatures(&mm.format); err != nil {
+		return nil, err
+	}
+
 	mm.masterKey, err = creds.GetMasterKey(mm.format.SecurityOptions)
 	if err != nil {
 		return nil, err
@@ -36,18 +36,19 @@ func TestMetadataManager(t *testing.T) {
 		return
 	}
 
-	if err := Initialize(st, nil, creds); err != nil {
+	ctx := context.Background()
+	if err := Initialize(ctx, st, nil, creds); err != nil {
 		t.Errorf("can't initialize repository: %v", err)
 		return
 	}
 
-	v1, err := newMetadataManager(st, creds)
+	v1, err := newMetadataManager(ctx, blob.ToStreaming(st), creds)
 	if err != nil {
 		t.Errorf("can't open first metadata manager: %v", err)
 		return
 	}
 
-	v2, err := newMetadataManager(st, creds)
+	v2, err := newMetadataManager(ctx, blob.ToStreaming(st), creds)
 	if err != nil {
 		t.Errorf("can't open second metadata manager: %v", err)
 		return
@@ -67,7 +68,7 @@ func TestMetadataManager(t *testing.T) {
 		t.Errorf("configurations are different: %+v vs %+v", cfg, cfg2)
 	}
 
-	_, err = newMetadataManager(st, otherCreds)
+	_, err = newMetadataManager(ctx, blob.ToStreaming(st), otherCreds)
 	if err == nil {
 		t.Errorf("unexpectedly opened repository with invalid credentials")
 		return
@@ -3,6 +3,7 @@ package repo
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -26,7 +27,8 @@ type ObjectReader interface {
 // ObjectManager implements a content-addressable storage on top of blob storage.
 type ObjectManager struct {
 	stats   Stats
-	storage blob.Storage
+	ctx     context.Context
+	storage blob.StreamingStorage
 
 	verbose   bool
 	format    config.RepositoryObjectFormat
@@ -130,20 +132,27 @@ func (r *ObjectManager) FinishPacking() error {
 	return r.packMgr.finishPacking()
 }
 
+// CheckpointPacking writes pending pack files and their indexes, so that objects written so far can be read
+// even if FinishPacking is never called. Packing remains enabled.
+func (r *ObjectManager) CheckpointPacking() error {
+	return r.packMgr.checkpoint()
+}
+
 func nullTrace(message string, args ...interface{}) {
 }
 
 // newObjectManager creates an ObjectManager with the specified storage, format and options.
-func newObjectManager(s blob.Storage, f config.RepositoryObjectFormat, opts *Options) (*ObjectManager, error) {
+func newObjectManager(ctx context.Context, s blob.StreamingStorage, f config.RepositoryObjectFormat, opts *Options) (*ObjectManager, error) {
 	if err := validateFormat(&f); err != nil {
 		return nil, err
 	}
 
 	sf := objectFormatterFactories[f.ObjectFormat]
 	r := &ObjectManager{
+		ctx:            ctx,
 		storage:        s,
 		format:         f,
-		blockSizeCache: newBlockSizeCache(s),
+		blockSizeCache: newBlockSizeCache(ctx, s),
 		trace:          nullTrace,
 	}
 
@@ -221,7 +230,7 @@ func (r *ObjectManager) hashEncryptAndWrite(packGroup string, buffer *bytes.Buff
 	atomic.AddInt32(&r.stats.WrittenBlocks, int32(1))
 	atomic.AddInt64(&r.stats.WrittenBytes, int64(len(data)))
 
-	if err := r.storage.PutBlock(objectID.StorageBlock, data); err != nil {
+	if err := r.storage.PutBlock(r.ctx, objectID.StorageBlock, bytes.NewReader(data)); err != nil {
 		return NullObjectID, err
 	}
 
@@ -255,21 +264,31 @@ func (r *ObjectManager) flattenListChunk(rawReader io.Reader) ([]indirectObjectE
 }
 
 func (r *ObjectManager) newRawReader(objectID ObjectID) (ObjectReader, error) {
+	payload, err := r.readBlock(objectID.StorageBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	return newObjectReaderWithData(payload), nil
+}
+
+// readBlock returns decrypted and verified contents of the specified storage block, which may be packed.
+func (r *ObjectManager) readBlock(blockID string) ([]byte, error) {
 	var payload []byte
 	var err error
-	underlyingObjectID := objectID
+	underlyingObjectID := ObjectID{StorageBlock: blockID}
 	var decryptSkip int
 
-	p, ok, err := r.packMgr.blockIDToPackSection(objectID.StorageBlock)
+	p, ok, err := r.packMgr.blockIDToPackSection(blockID)
 	if err != nil {
 		return nil, err
 	}
 	if ok {
-		payload, err = r.storage.GetBlock(p.Base.StorageBlock, p.Start, p.Length)
+		payload, err = blob.GetBlockBytes(r.ctx, r.storage, p.Base.StorageBlock, p.Start, p.Length)
 		underlyingObjectID = p.Base
 		decryptSkip = int(p.Start)
 	} else {
-		payload, err = r.storage.GetBlock(objectID.StorageBlock, 0, -1)
+		payload, err = blob.GetBlockBytes(r.ctx, r.storage, blockID, 0, -1)
 	}
 
 	if err != nil {
@@ -287,11 +306,11 @@ func (r *ObjectManager) newRawReader(objectID ObjectID) (ObjectReader, error) {
 
 	// Since the encryption key is a function of data, we must be able to generate exactly the same key
 	// after decrypting the content. This serves as a checksum.
-	if err := r.verifyChecksum(payload, objectID.StorageBlock); err != nil {
+	if err := r.verifyChecksum(payload, blockID); err != nil {
 		return nil, err
 	}
 
-	return newObjectReaderWithData(payload), nil
+	return payload, nil
 }
 
 func (r *ObjectManager) verifyChecksum(data []byte, blockID string) error {
@@ -38,11 +38,10 @@ func setupTest(t *testing.T, mods ...func(o *NewRepositoryOptions)) (data map[st
 	for _, m := range mods {
 		m(opt)
 	}
-	Initialize(st, opt, creds)
-
 	ctx := context.Background()
+	Initialize(ctx, st, opt, creds)
 
-	r, err := connect(ctx, st, creds, &Options{})
+	r, err := connect(ctx, st, creds, &Options{}, nil)
 	if err != nil {
 		t.Fatalf("can't connect: %v", err)
 	}
@@ -178,6 +177,45 @@ func TestPackingSimple(t *testing.T) {
 	verify(t, repo, oid3a, []byte(content3), "packed-object-3")
 }
 
+func TestPackingCheckpoint(t *testing.T) {
+	data, repo := setupTest(t, func(n *NewRepositoryOptions) {
+		n.MaxPackFileLength = 10000
+		n.MaxPackedContentLength = 10000
+	})
+
+	if err := repo.BeginPacking(); err != nil {
+		t.Fatalf("error in BeginPacking: %v", err)
+	}
+
+	oid1 := writeObject(t, repo, []byte("hello, how do you do?"), "packed-object-1")
+	if err := repo.CheckpointPacking(); err != nil {
+		t.Fatalf("error in CheckpointPacking: %v", err)
+	}
+
+	oid2 := writeObject(t, repo, []byte("hi, how are you?"), "packed-object-2")
+	if err := repo.CheckpointPacking(); err != nil {
+		t.Fatalf("error in CheckpointPacking: %v", err)
+	}
+
+	oid3 := writeObject(t, repo, []byte("thank you!"), "packed-object-3")
+
+	// Objects written before the last checkpoint are readable by another connection, even though packing hasn't finished.
+	creds, _ := auth.Password("foobarbazfoobarbaz")
+	r2, err := connect(context.Background(), storagetesting.NewMapStorage(data), creds, &Options{}, nil)
+	if err != nil {
+		t.Fatalf("can't connect: %v", err)
+	}
+
+	verify(t, r2, oid1, []byte("hello, how do you do?"), "packed-object-1")
+	verify(t, r2, oid2, []byte("hi, how are you?"), "packed-object-2")
+
+	if err := repo.FinishPacking(); err != nil {
+		t.Fatalf("error in FinishPacking: %v", err)
+	}
+
+	verify(t, repo, oid3, []byte("thank you!"), "packed-object-3")
+}
+
 func verifyIndirectBlock(t *testing.T, r *Repository, oid ObjectID) {
 	for oid.Indirect != nil {
 		direct := *oid.Indirect
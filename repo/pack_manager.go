@@ -28,7 +28,7 @@ type blockLocation struct {
 type packManager struct {
 	metadataManager *MetadataManager
 	objectManager   *ObjectManager
-	storage         blob.Storage
+	storage         blob.StreamingStorage
 
 	mu           sync.RWMutex
 	blockToIndex map[string]*packIndex
@@ -50,6 +50,10 @@ func (p *packManager) blockIDToPackSection(blockID string) (ObjectIDSection, boo
 		return ObjectIDSection{}, false, fmt.Errorf("can't load pack index: %v", err)
 	}
 
+	// Indexes of current packs are modified by concurrent writers.
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	ndx := pi[blockID]
 	if ndx == nil {
 		return ObjectIDSection{}, false, nil
@@ -77,8 +81,30 @@ func (p *packManager) blockIDToPackSection(blockID string) (ObjectIDSection, boo
 	return ObjectIDSection{}, false, fmt.Errorf("invalid pack index for %q", blockID)
 }
 
+// packGroupOf returns the pack group of the specified block and whether the block is packed at all.
+func (p *packManager) packGroupOf(blockID string) (string, bool, error) {
+	pi, err := p.ensurePackIndexesLoaded()
+	if err != nil {
+		return "", false, fmt.Errorf("can't load pack index: %v", err)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	ndx := pi[blockID]
+	if ndx == nil {
+		return "", false, nil
+	}
+
+	return ndx.PackGroup, true, nil
+}
+
 func (p *packManager) begin() error {
 	p.ensurePackIndexesLoaded()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	p.pendingPackIndexes = make(packIndexes)
 	return nil
 }
@@ -139,6 +165,26 @@ func (p *packManager) finishPacking() error {
 	return nil
 }
 
+func (p *packManager) checkpoint() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pendingPackIndexes == nil {
+		return nil
+	}
+
+	if err := p.finishCurrentPackLocked(); err != nil {
+		return err
+	}
+
+	if err := p.savePackIndexes(); err != nil {
+		return err
+	}
+
+	p.pendingPackIndexes = make(packIndexes)
+	return nil
+}
+
 func (p *packManager) savePackIndexes() error {
 	if len(p.pendingPackIndexes) == 0 {
 		return nil
@@ -174,10 +220,16 @@ func (p *packManager) finishPackLocked(g *packInfo) error {
 	if g.currentPackIndex == nil {
 		return nil
 	}
+	var prefix string
+	if g.currentPackIndex.PackGroup != "" {
+		prefix = metadataPackBlockPrefix
+	}
+
 	w := p.objectManager.NewWriter(WriterOptions{
-		Description:    fmt.Sprintf("pack:%v", g.currentPackID),
-		splitter:       newNeverSplitter(),
-		disablePacking: true,
+		Description:     fmt.Sprintf("pack:%v", g.currentPackID),
+		BlockNamePrefix: prefix,
+		splitter:        newNeverSplitter(),
+		disablePacking:  true,
 	})
 	defer w.Close()
 
@@ -208,6 +260,11 @@ func (p *packManager) ensurePackIndexesLoaded() (map[string]*packIndex, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	// Indexes may have been loaded while waiting for the lock.
+	if p.blockToIndex != nil {
+		return p.blockToIndex, nil
+	}
+
 	m, err := p.metadataManager.ListMetadataContents(packIDPrefix, -1)
 	if err != nil {
 		return nil, err
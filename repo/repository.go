@@ -1,17 +1,21 @@
 package repo
 
 import "github.com/kopia/kopia/blob"
+import "github.com/kopia/kopia/blob/caching"
 import "encoding/hex"
 import "fmt"
+import "time"
 
 // Repository represents storage where both content-addressable and user-addressable data is kept.
 type Repository struct {
 	*ObjectManager
 	*MetadataManager
-	Storage blob.Storage
+	Storage blob.StreamingStorage
 
 	ConfigFile     string
 	CacheDirectory string
+
+	cache caching.StatsProvider
 }
 
 // StatusInfo stores a snapshot of repository-wide statistics plus some general information about repository configuration.
@@ -22,6 +26,9 @@ type StatusInfo struct {
 	MetadataEncryptionAlgorithm string
 	UniqueID                    string
 	KeyDerivationAlgorithm      string
+	RequiredFeatures            []string
+	WriteOnce                   bool
+	MinRetentionPeriod          time.Duration
 
 	ObjectManagerVersion   string
 	ObjectFormat           string
@@ -37,18 +44,19 @@ type StatusInfo struct {
 
 // Stats returns repository-wide statistics.
 func (r *Repository) Stats() Stats {
-	return r.ObjectManager.stats
+	return r.ObjectManager.stats.snapshot()
 }
 
 // Status returns a snapshot of repository-wide statistics plus some general information about repository configuration.
 func (r *Repository) Status() StatusInfo {
 	s := StatusInfo{
-		Stats: r.ObjectManager.stats,
+		Stats: r.ObjectManager.stats.snapshot(),
 
 		MetadataManagerVersion:      r.MetadataManager.format.Version,
 		UniqueID:                    hex.EncodeToString(r.MetadataManager.format.UniqueID),
 		MetadataEncryptionAlgorithm: r.MetadataManager.format.EncryptionAlgorithm,
 		KeyDerivationAlgorithm:      r.MetadataManager.format.KeyDerivationAlgorithm,
+		RequiredFeatures:            r.MetadataManager.format.RequiredFeatures,
 
 		ObjectManagerVersion: fmt.Sprintf("%v", r.ObjectManager.format.Version),
 		ObjectFormat:         r.ObjectManager.format.ObjectFormat,
@@ -61,6 +69,11 @@ func (r *Repository) Status() StatusInfo {
 		MaxPackedContentLength: r.ObjectManager.format.MaxPackedContentLength,
 	}
 
+	if wo := r.MetadataManager.format.WriteOnce; wo != nil {
+		s.WriteOnce = true
+		s.MinRetentionPeriod = time.Duration(wo.MinRetentionSeconds) * time.Second
+	}
+
 	if s.Splitter == "" {
 		s.Splitter = "FIXED"
 	}
@@ -68,6 +81,15 @@ func (r *Repository) Status() StatusInfo {
 	return s
 }
 
+// CacheStats returns statistics of the local cache, if the cache is enabled.
+func (r *Repository) CacheStats() (caching.Stats, bool) {
+	if r.cache == nil {
+		return caching.Stats{}, false
+	}
+
+	return r.cache.CacheStats(), true
+}
+
 // Close closes the repository and releases all resources.
 func (r *Repository) Close() error {
 	if err := r.ObjectManager.Close(); err != nil {
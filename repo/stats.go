@@ -1,5 +1,7 @@
 package repo
 
+import "sync/atomic"
+
 // Stats exposes statistics about Repository operation.
 type Stats struct {
 	// Keep int64 fields first to ensure they get aligned to at least 64-bit boundaries
@@ -23,3 +25,22 @@ type Stats struct {
 func (s *Stats) Reset() {
 	*s = Stats{}
 }
+
+// snapshot returns a copy of statistics that may be concurrently updated.
+func (s *Stats) snapshot() Stats {
+	return Stats{
+		ReadBytes:      atomic.LoadInt64(&s.ReadBytes),
+		WrittenBytes:   atomic.LoadInt64(&s.WrittenBytes),
+		DecryptedBytes: atomic.LoadInt64(&s.DecryptedBytes),
+		EncryptedBytes: atomic.LoadInt64(&s.EncryptedBytes),
+		HashedBytes:    atomic.LoadInt64(&s.HashedBytes),
+
+		ReadBlocks:    atomic.LoadInt32(&s.ReadBlocks),
+		WrittenBlocks: atomic.LoadInt32(&s.WrittenBlocks),
+		CheckedBlocks: atomic.LoadInt32(&s.CheckedBlocks),
+		HashedBlocks:  atomic.LoadInt32(&s.HashedBlocks),
+		InvalidBlocks: atomic.LoadInt32(&s.InvalidBlocks),
+		PresentBlocks: atomic.LoadInt32(&s.PresentBlocks),
+		ValidBlocks:   atomic.LoadInt32(&s.ValidBlocks),
+	}
+}
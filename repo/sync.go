@@ -0,0 +1,295 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kopia/kopia/blob"
+)
+
+const defaultSyncParallelism = 10
+
+// ErrDifferentRepository is returned when the destination of synchronization already contains a different repository.
+var ErrDifferentRepository = errors.New("destination storage contains a different repository")
+
+// SyncOptions specifies options for SyncStorage.
+type SyncOptions struct {
+	Parallelism int             // number of blocks copied in parallel
+	Progress    func(SyncStats) // invoked concurrently after each block has been copied or deleted
+	Delete      bool            // delete blocks missing in the source from the destination after copying all blocks
+}
+
+// SyncStats contains statistics of storage synchronization.
+type SyncStats struct {
+	SourceBlocks  int64 `json:"sourceBlocks"`
+	SkippedBlocks int64 `json:"skippedBlocks"`
+
+	BlocksToCopy int64 `json:"blocksToCopy"`
+	BytesToCopy  int64 `json:"bytesToCopy"`
+
+	CopiedBlocks int64 `json:"copiedBlocks"`
+	CopiedBytes  int64 `json:"copiedBytes"`
+
+	BlocksToDelete int64 `json:"blocksToDelete"`
+	DeletedBlocks  int64 `json:"deletedBlocks"`
+}
+
+// SyncStorage copies raw repository blocks that are missing in the destination storage or have different sizes,
+// as well as metadata blocks that have been modified in the source since they were copied.
+//
+// Blocks are copied in stages, so that the destination repository remains consistent if synchronization is
+// interrupted: data blocks are copied first, followed by metadata referencing them, and finally the format blocks
+// that make the destination usable as a repository. Interrupted synchronization resumes when invoked again,
+// since blocks that have been fully copied are skipped and partially written blocks have different sizes.
+// When requested, blocks missing in the source are deleted from the destination last, in the reverse order of stages.
+func SyncStorage(ctx context.Context, src, dst blob.Storage, opt *SyncOptions) (SyncStats, error) {
+	if opt == nil {
+		opt = &SyncOptions{}
+	}
+
+	s := &storageSyncer{
+		src:         blob.ToStreaming(src),
+		dst:         blob.ToStreaming(dst),
+		parallelism: opt.Parallelism,
+		progress:    opt.Progress,
+		delete:      opt.Delete,
+	}
+
+	if s.parallelism <= 0 {
+		s.parallelism = defaultSyncParallelism
+	}
+
+	if err := s.ensureSameRepository(ctx); err != nil {
+		return SyncStats{}, err
+	}
+
+	err := s.run(ctx)
+	return s.snapshot(), err
+}
+
+type storageSyncer struct {
+	stats SyncStats // must be first for alignment of atomic access
+
+	src         blob.StreamingStorage
+	dst         blob.StreamingStorage
+	parallelism int
+	progress    func(SyncStats)
+	delete      bool
+}
+
+// ensureSameRepository verifies that the destination is either empty or contains the same repository.
+func (s *storageSyncer) ensureSameRepository(ctx context.Context) error {
+	srcFormat, err := blob.GetBlockBytes(ctx, s.src, MetadataBlockPrefix+formatBlockID, 0, -1)
+	if err != nil {
+		return fmt.Errorf("unable to read source repository format: %v", err)
+	}
+
+	dstFormat, err := blob.GetBlockBytes(ctx, s.dst, MetadataBlockPrefix+formatBlockID, 0, -1)
+	switch err {
+	case nil:
+		if !bytes.Equal(srcFormat, dstFormat) {
+			return ErrDifferentRepository
+		}
+		return nil
+
+	case blob.ErrBlockNotFound:
+		return nil
+
+	default:
+		return fmt.Errorf("unable to read destination repository format: %v", err)
+	}
+}
+
+func (s *storageSyncer) run(ctx context.Context) error {
+	srcBlocks, err := listBlocks(ctx, s.src)
+	if err != nil {
+		return fmt.Errorf("unable to list source blocks: %v", err)
+	}
+
+	dstBlocks, err := listBlocks(ctx, s.dst)
+	if err != nil {
+		return fmt.Errorf("unable to list destination blocks: %v", err)
+	}
+
+	var stages [syncStageCount][]string
+	for id, bm := range srcBlocks {
+		s.stats.SourceBlocks++
+
+		if dbm, ok := dstBlocks[id]; ok && !blockChanged(bm, dbm) {
+			s.stats.SkippedBlocks++
+			continue
+		}
+
+		s.stats.BlocksToCopy++
+		s.stats.BytesToCopy += bm.Length
+
+		st := syncStage(id)
+		stages[st] = append(stages[st], id)
+	}
+
+	var deleteStages [syncStageCount][]string
+	if s.delete {
+		for id := range dstBlocks {
+			if _, ok := srcBlocks[id]; !ok {
+				s.stats.BlocksToDelete++
+
+				st := syncStage(id)
+				deleteStages[st] = append(deleteStages[st], id)
+			}
+		}
+	}
+
+	for _, ids := range stages {
+		sort.Strings(ids)
+		if err := s.forEachBlock(ctx, ids, func(id string) error {
+			if err := s.copyBlock(ctx, id); err != nil {
+				return fmt.Errorf("unable to copy block %v: %v", id, err)
+			}
+
+			atomic.AddInt64(&s.stats.CopiedBlocks, 1)
+			atomic.AddInt64(&s.stats.CopiedBytes, srcBlocks[id].Length)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	// Metadata is deleted before data blocks it may reference.
+	for i := len(deleteStages) - 1; i >= 0; i-- {
+		ids := deleteStages[i]
+		sort.Strings(ids)
+		if err := s.forEachBlock(ctx, ids, func(id string) error {
+			if err := s.dst.DeleteBlock(ctx, id); err != nil {
+				return fmt.Errorf("unable to delete block %v: %v", id, err)
+			}
+
+			atomic.AddInt64(&s.stats.DeletedBlocks, 1)
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// blockChanged determines whether the source block must be copied over the existing destination block.
+// Other than metadata, blocks are named after hashes of their contents and can only differ when partially copied.
+func blockChanged(src, dst blob.BlockMetadata) bool {
+	if src.Length != dst.Length {
+		return true
+	}
+
+	return isMutableBlock(src.BlockID) && src.TimeStamp.After(dst.TimeStamp)
+}
+
+// forEachBlock invokes the function for all blocks in parallel, stopping at the first error.
+func (s *storageSyncer) forEachBlock(ctx context.Context, ids []string, fn func(id string) error) error {
+	ch := make(chan string)
+	errch := make(chan error, s.parallelism)
+	var wg sync.WaitGroup
+
+	for i := 0; i < s.parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for id := range ch {
+				if err := fn(id); err != nil {
+					errch <- err
+					return
+				}
+
+				if s.progress != nil {
+					s.progress(s.snapshot())
+				}
+			}
+		}()
+	}
+
+	var err error
+
+feed:
+	for _, id := range ids {
+		select {
+		case ch <- id:
+		case err = <-errch:
+			break feed
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+
+	close(ch)
+	wg.Wait()
+	close(errch)
+
+	if err != nil {
+		return err
+	}
+
+	return <-errch
+}
+
+func (s *storageSyncer) copyBlock(ctx context.Context, id string) error {
+	r, err := s.src.GetBlock(ctx, id, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return s.dst.PutBlock(ctx, id, r)
+}
+
+func (s *storageSyncer) snapshot() SyncStats {
+	return SyncStats{
+		SourceBlocks:  s.stats.SourceBlocks,
+		SkippedBlocks: s.stats.SkippedBlocks,
+		BlocksToCopy:  s.stats.BlocksToCopy,
+		BytesToCopy:   s.stats.BytesToCopy,
+		CopiedBlocks:  atomic.LoadInt64(&s.stats.CopiedBlocks),
+		CopiedBytes:   atomic.LoadInt64(&s.stats.CopiedBytes),
+
+		BlocksToDelete: s.stats.BlocksToDelete,
+		DeletedBlocks:  atomic.LoadInt64(&s.stats.DeletedBlocks),
+	}
+}
+
+const syncStageCount = 4
+
+// syncStage returns the stage in which the block is copied during synchronization.
+func syncStage(blockID string) int {
+	switch {
+	case blockID == MetadataBlockPrefix+formatBlockID:
+		return 3
+	case blockID == MetadataBlockPrefix+repositoryConfigBlockID:
+		return 2
+	case strings.HasPrefix(blockID, MetadataBlockPrefix):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func listBlocks(ctx context.Context, st blob.StreamingStorage) (map[string]blob.BlockMetadata, error) {
+	ch, cancel := st.ListBlocks(ctx, "")
+	defer cancel()
+
+	result := map[string]blob.BlockMetadata{}
+	for bm := range ch {
+		if bm.Error != nil {
+			return nil, bm.Error
+		}
+
+		result[bm.BlockID] = bm
+	}
+
+	return result, nil
+}
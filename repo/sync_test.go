@@ -0,0 +1,196 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/auth"
+	"github.com/kopia/kopia/blob"
+	"github.com/kopia/kopia/internal/storagetesting"
+)
+
+// orderCheckingStorage verifies that the format block is written after all other blocks
+// and overwrites existing blocks, which map storage does not do.
+type orderCheckingStorage struct {
+	blob.Storage
+	t   *testing.T
+	src map[string][]byte
+	dst map[string][]byte
+}
+
+func (s *orderCheckingStorage) PutBlock(id string, data []byte) error {
+	if id == MetadataBlockPrefix+formatBlockID {
+		for k := range s.src {
+			if _, ok := s.dst[k]; !ok && k != id {
+				s.t.Errorf("format block written before %v", k)
+			}
+		}
+	}
+
+	s.Storage.DeleteBlock(id)
+	return s.Storage.PutBlock(id, data)
+}
+
+// timestampedStorage overwrites existing blocks and reports the order, in which blocks have been written,
+// as their timestamps, which map storage does not do.
+type timestampedStorage struct {
+	blob.Storage
+	clock *int64 // shared by all storages, whose timestamps are compared
+
+	mu    sync.Mutex
+	times map[string]time.Time
+}
+
+func newTimestampedStorage(st blob.Storage, clock *int64) *timestampedStorage {
+	return &timestampedStorage{Storage: st, clock: clock, times: map[string]time.Time{}}
+}
+
+func (s *timestampedStorage) PutBlock(id string, data []byte) error {
+	s.Storage.DeleteBlock(id)
+	if err := s.Storage.PutBlock(id, data); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.times[id] = time.Unix(atomic.AddInt64(s.clock, 1), 0)
+	return nil
+}
+
+func (s *timestampedStorage) ListBlocks(prefix string) (chan blob.BlockMetadata, blob.CancelFunc) {
+	ch, cancel := s.Storage.ListBlocks(prefix)
+	result := make(chan blob.BlockMetadata)
+
+	go func() {
+		defer close(result)
+		for bm := range ch {
+			s.mu.Lock()
+			bm.TimeStamp = s.times[bm.BlockID]
+			s.mu.Unlock()
+			result <- bm
+		}
+	}()
+
+	return result, cancel
+}
+
+func TestSyncStorage(t *testing.T) {
+	ctx := context.Background()
+	var clock int64
+	srcData := map[string][]byte{}
+	src := newTimestampedStorage(storagetesting.NewMapStorage(srcData), &clock)
+
+	creds, _ := auth.Password("foo.bar.baz.foo.bar.baz")
+	if err := Initialize(ctx, src, &NewRepositoryOptions{MaxPackedContentLength: -1}, creds); err != nil {
+		t.Fatalf("can't initialize repository: %v", err)
+	}
+
+	r, err := connect(ctx, src, creds, &Options{}, nil)
+	if err != nil {
+		t.Fatalf("can't connect: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		w := r.NewWriter(WriterOptions{})
+		w.Write(bytes.Repeat([]byte{byte(i)}, 1000+i))
+		if _, err := w.Result(); err != nil {
+			t.Fatalf("can't write object: %v", err)
+		}
+	}
+
+	if err := r.PutMetadata("foo", []byte("bar")); err != nil {
+		t.Fatalf("can't put metadata: %v", err)
+	}
+
+	dstData := map[string][]byte{}
+	dst := &orderCheckingStorage{Storage: newTimestampedStorage(storagetesting.NewMapStorage(dstData), &clock), t: t, src: srcData, dst: dstData}
+
+	stats, err := SyncStorage(ctx, src, dst, &SyncOptions{Parallelism: 3})
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	if got, want := stats.CopiedBlocks, int64(len(srcData)); got != want {
+		t.Errorf("unexpected number of copied blocks: %v, want %v", got, want)
+	}
+
+	if !reflect.DeepEqual(srcData, dstData) {
+		t.Errorf("destination is different from source after sync")
+	}
+
+	// Simulate interrupted copy of one block and missing another one.
+	var dataBlocks []string
+	for k := range dstData {
+		if !strings.HasPrefix(k, MetadataBlockPrefix) {
+			dataBlocks = append(dataBlocks, k)
+		}
+	}
+
+	dstData[dataBlocks[0]] = dstData[dataBlocks[0]][0:1]
+	delete(dstData, dataBlocks[1])
+
+	stats, err = SyncStorage(ctx, src, dst, nil)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	if stats.CopiedBlocks != 2 || stats.SkippedBlocks != int64(len(srcData)-2) {
+		t.Errorf("unexpected stats of incremental sync: %+v", stats)
+	}
+
+	if !reflect.DeepEqual(srcData, dstData) {
+		t.Errorf("destination is different from source after incremental sync")
+	}
+
+	if _, err := connect(ctx, dst, creds, &Options{}, nil); err != nil {
+		t.Errorf("can't connect to destination repository: %v", err)
+	}
+
+	// Modified metadata is copied again even though its size is the same, blocks missing in the source
+	// are deleted only when requested.
+	if err := r.PutMetadata("foo", []byte("baz")); err != nil {
+		t.Fatalf("can't put metadata: %v", err)
+	}
+
+	dstData["extra"] = []byte{1, 2, 3}
+	dstData[MetadataBlockPrefix+"extra"] = []byte{4, 5, 6}
+
+	stats, err = SyncStorage(ctx, src, dst, nil)
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	if stats.CopiedBlocks != 1 || stats.BlocksToDelete != 0 || dstData["extra"] == nil {
+		t.Errorf("unexpected stats of sync of modified metadata: %+v", stats)
+	}
+
+	stats, err = SyncStorage(ctx, src, dst, &SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+
+	if stats.CopiedBlocks != 0 || stats.DeletedBlocks != 2 {
+		t.Errorf("unexpected stats of sync with deletion: %+v", stats)
+	}
+
+	if !reflect.DeepEqual(srcData, dstData) {
+		t.Errorf("destination is different from source after sync with deletion")
+	}
+
+	otherData := map[string][]byte{}
+	other := storagetesting.NewMapStorage(otherData)
+	if err := Initialize(ctx, other, nil, creds); err != nil {
+		t.Fatalf("can't initialize repository: %v", err)
+	}
+
+	if _, err := SyncStorage(ctx, src, other, nil); err != ErrDifferentRepository {
+		t.Errorf("unexpected error when syncing to a different repository: %v", err)
+	}
+}
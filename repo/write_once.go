@@ -0,0 +1,136 @@
+package repo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/kopia/kopia/auth"
+	"github.com/kopia/kopia/blob"
+	"github.com/kopia/kopia/blob/writeonce"
+	"github.com/kopia/kopia/internal/config"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// featureWriteOnce is a required feature of repositories that must only be accessed through write-once storage.
+const featureWriteOnce = "write-once"
+
+var supportedFeatures = map[string]bool{
+	featureWriteOnce: true,
+}
+
+var purposePrivilegedKey = []byte("PRIVILEGED")
+
+// ErrInvalidPrivilegedCredentials is returned when privileged credentials don't match the repository.
+var ErrInvalidPrivilegedCredentials = errors.New("invalid privileged credentials")
+
+func checkRequiredFeatures(f *config.MetadataFormat) error {
+	for _, feature := range f.RequiredFeatures {
+		if !supportedFeatures[feature] {
+			return fmt.Errorf("repository requires feature %q which is not supported by this version", feature)
+		}
+
+		if feature == featureWriteOnce && f.WriteOnce == nil {
+			return errors.New("missing write-once options in repository format")
+		}
+	}
+
+	return nil
+}
+
+// privilegedKeyChecksum derives the checksum of the privileged key, which is persisted
+// in the repository format without revealing the key itself.
+func privilegedKeyChecksum(key []byte, so auth.SecurityOptions) ([]byte, error) {
+	checksum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, so.UniqueID, purposePrivilegedKey), checksum); err != nil {
+		return nil, err
+	}
+
+	return checksum, nil
+}
+
+// writeOnceFormatFromOptions returns the write-once format of a new repository with a given master key.
+func writeOnceFormatFromOptions(opt *NewRepositoryOptions, so auth.SecurityOptions, masterKey []byte) (*config.WriteOnceFormat, error) {
+	f := &config.WriteOnceFormat{
+		MinRetentionSeconds: int64(opt.MinRetentionPeriod / time.Second),
+	}
+
+	if opt.PrivilegedCredentials == nil {
+		return f, nil
+	}
+
+	key, err := opt.PrivilegedCredentials.GetMasterKey(so)
+	if err != nil {
+		return nil, err
+	}
+
+	if hmac.Equal(key, masterKey) {
+		return nil, errors.New("privileged credentials must be different from repository credentials")
+	}
+
+	f.PrivilegedKeyChecksum, err = privilegedKeyChecksum(key, so)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// isMutableBlock determines whether the storage block holds metadata, such as policies, which is modified and removed
+// during normal operation. Format of the repository and metadata registered as immutable, such as pack indexes
+// and snapshot manifests, are kept for the minimum retention period. Other blocks are named after hashes of their contents.
+func isMutableBlock(id string) bool {
+	if !strings.HasPrefix(id, MetadataBlockPrefix) {
+		return false
+	}
+
+	switch itemID := strings.TrimPrefix(id, MetadataBlockPrefix); {
+	case itemID == formatBlockID, itemID == repositoryConfigBlockID, isImmutableMetadata(itemID):
+		return false
+	default:
+		return true
+	}
+}
+
+// applyWriteOnce wraps the storage to enforce write-once restrictions recorded in the repository format
+// unless valid privileged credentials have been provided.
+//
+// Restrictions are enforced by the client only. The format, which records them along with the checksum of the privileged key,
+// is stored without authentication, so clients that don't honor it can't be prevented from modifying the repository.
+func applyWriteOnce(st blob.StreamingStorage, f *config.MetadataFormat, privilegedCreds auth.Credentials) (blob.StreamingStorage, error) {
+	if f.WriteOnce == nil {
+		return st, nil
+	}
+
+	if privilegedCreds != nil {
+		if len(f.WriteOnce.PrivilegedKeyChecksum) == 0 {
+			return nil, ErrInvalidPrivilegedCredentials
+		}
+
+		key, err := privilegedCreds.GetMasterKey(f.SecurityOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		checksum, err := privilegedKeyChecksum(key, f.SecurityOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		if !hmac.Equal(checksum, f.WriteOnce.PrivilegedKeyChecksum) {
+			return nil, ErrInvalidPrivilegedCredentials
+		}
+
+		return st, nil
+	}
+
+	return writeonce.NewWrapper(st, writeonce.Options{
+		MinRetentionPeriod: time.Duration(f.WriteOnce.MinRetentionSeconds) * time.Second,
+		IsMutable:          isMutableBlock,
+	}), nil
+}
@@ -0,0 +1,126 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kopia/kopia/auth"
+	"github.com/kopia/kopia/blob/writeonce"
+	"github.com/kopia/kopia/internal/storagetesting"
+)
+
+func TestWriteOnceRepository(t *testing.T) {
+	ctx := context.Background()
+	data := map[string][]byte{}
+	st := storagetesting.NewMapStorage(data)
+
+	creds, _ := auth.Password("foo.bar.baz.foo.bar.baz")
+	privilegedCreds, _ := auth.Password("foo.bar.baz.foo.bar.baz.privileged")
+	otherCreds, _ := auth.Password("foo.bar.baz.foo.bar.baz.other")
+
+	if err := Initialize(ctx, st, &NewRepositoryOptions{
+		WriteOnce:             true,
+		PrivilegedCredentials: creds,
+	}, creds); err == nil {
+		t.Errorf("expected error when privileged credentials are the same as repository credentials")
+	}
+
+	if err := Initialize(ctx, st, &NewRepositoryOptions{
+		WriteOnce:             true,
+		PrivilegedCredentials: privilegedCreds,
+	}, creds); err != nil {
+		t.Fatalf("can't initialize repository: %v", err)
+	}
+
+	r, err := connect(ctx, st, creds, &Options{}, nil)
+	if err != nil {
+		t.Fatalf("can't connect: %v", err)
+	}
+
+	if s := r.Status(); !s.WriteOnce || s.MinRetentionPeriod != 0 {
+		t.Errorf("unexpected write-once status: %+v", s)
+	}
+
+	if err := r.PutMetadata("foo", []byte("bar")); err != nil {
+		t.Fatalf("can't put metadata: %v", err)
+	}
+
+	// Metadata, such as policies, can be modified, format of the repository, immutable metadata and stored objects can't.
+	if err := r.PutMetadata("foo", []byte("baz")); err != nil {
+		t.Errorf("can't overwrite metadata: %v", err)
+	}
+
+	if err := r.RemoveMetadata("foo"); err != nil {
+		t.Errorf("can't remove metadata: %v", err)
+	}
+
+	if err := r.PutMetadata("foo", []byte("bar")); err != nil {
+		t.Fatalf("can't put metadata: %v", err)
+	}
+
+	if err := r.PutMetadata("Zfoo", []byte("bar")); err != nil {
+		t.Fatalf("can't put immutable metadata: %v", err)
+	}
+
+	if err := r.PutMetadata("Zfoo", []byte("baz")); err != writeonce.ErrBlockExists {
+		t.Errorf("unexpected error when overwriting immutable metadata: %v", err)
+	}
+
+	if err := r.RemoveMetadata("Zfoo"); err != writeonce.ErrDeleteNotAllowed {
+		t.Errorf("unexpected error when removing immutable metadata: %v", err)
+	}
+
+	fb := MetadataBlockPrefix + formatBlockID
+	if err := r.Storage.PutBlock(ctx, fb, bytes.NewReader(data[fb])); err != writeonce.ErrBlockExists {
+		t.Errorf("unexpected error when overwriting format: %v", err)
+	}
+
+	w := r.NewWriter(WriterOptions{})
+	w.Write([]byte("some data"))
+	oid, err := w.Result()
+	if err != nil {
+		t.Fatalf("can't write object: %v", err)
+	}
+
+	if err := r.Storage.DeleteBlock(ctx, oid.StorageBlock); err != writeonce.ErrDeleteNotAllowed {
+		t.Errorf("unexpected error when deleting object: %v", err)
+	}
+
+	if _, err := connect(ctx, st, creds, &Options{PrivilegedCredentials: otherCreds}, nil); err != ErrInvalidPrivilegedCredentials {
+		t.Errorf("unexpected error when connecting with invalid privileged credentials: %v", err)
+	}
+
+	pr, err := connect(ctx, st, creds, &Options{PrivilegedCredentials: privilegedCreds}, nil)
+	if err != nil {
+		t.Fatalf("can't connect with privileged credentials: %v", err)
+	}
+
+	if err := pr.RemoveMetadata("foo"); err != nil {
+		t.Errorf("can't remove metadata with privileged credentials: %v", err)
+	}
+
+	if err := pr.RemoveMetadata("Zfoo"); err != nil {
+		t.Errorf("can't remove immutable metadata with privileged credentials: %v", err)
+	}
+}
+
+func TestUnsupportedRequiredFeature(t *testing.T) {
+	ctx := context.Background()
+	data := map[string][]byte{}
+	st := storagetesting.NewMapStorage(data)
+
+	creds, _ := auth.Password("foo.bar.baz.foo.bar.baz")
+	if err := Initialize(ctx, st, nil, creds); err != nil {
+		t.Fatalf("can't initialize repository: %v", err)
+	}
+
+	// Simulate repository created by a newer version.
+	fb := MetadataBlockPrefix + formatBlockID
+	data[fb] = []byte(strings.Replace(string(data[fb]), `"version"`, `"requiredFeatures":["some-future-feature"],"version"`, 1))
+
+	if _, err := connect(ctx, st, creds, &Options{}, nil); err == nil || !strings.Contains(err.Error(), "some-future-feature") {
+		t.Errorf("unexpected error when connecting to repository with unsupported feature: %v", err)
+	}
+}
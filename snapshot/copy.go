@@ -0,0 +1,97 @@
+package snapshot
+
+import (
+	"fmt"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/dir"
+	"github.com/kopia/kopia/repo"
+)
+
+// Copier copies snapshots between repositories with compatible object formats without re-reading,
+// splitting and hashing their files again. Copied directory and file objects retain their IDs.
+type Copier struct {
+	src     *Manager
+	dst     *Manager
+	objects *repo.ObjectCopier
+}
+
+// NewCopier creates a Copier of snapshots from src to dst or returns repo.ErrIncompatibleObjectFormat
+// if objects can't be copied between the repositories.
+func NewCopier(dst, src *Manager) (*Copier, error) {
+	oc, err := repo.NewObjectCopier(dst.repository, src.repository)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Copier{src: src, dst: dst, objects: oc}, nil
+}
+
+// Copy copies all objects of the snapshot, saves its manifest in the destination repository and returns its ID.
+// Callers are responsible for skipping snapshots, which have already been copied. Objects present
+// in the destination repository are not copied again.
+func (c *Copier) Copy(m *Manifest) (string, error) {
+	if err := c.dst.repository.BeginPacking(); err != nil {
+		return "", err
+	}
+
+	if m.RootEntryType() == fs.EntryTypeDirectory {
+		if err := c.copyDirectory(m.RootObjectID); err != nil {
+			return "", err
+		}
+	} else if err := c.objects.Copy(m.RootObjectID); err != nil {
+		return "", fmt.Errorf("unable to copy root object %v: %v", m.RootObjectID, err)
+	}
+
+	if err := c.objects.Copy(m.HashCacheID); err != nil {
+		return "", fmt.Errorf("unable to copy hash cache: %v", err)
+	}
+
+	if err := c.dst.repository.FinishPacking(); err != nil {
+		return "", err
+	}
+
+	return c.dst.SaveSnapshot(m)
+}
+
+func (c *Copier) copyDirectory(oid repo.ObjectID) error {
+	if err := c.objects.Copy(oid); err != nil {
+		return fmt.Errorf("unable to copy directory %v: %v", oid, err)
+	}
+
+	r, err := c.src.repository.Open(oid)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	entries, err := dir.ReadEntries(r)
+	if err != nil {
+		return fmt.Errorf("unable to read directory %v: %v", oid, err)
+	}
+
+	for _, e := range entries {
+		if e.Type == fs.EntryTypeDirectory {
+			if err := c.copyDirectory(e.ObjectID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if e.Type.IsSpecial() {
+			// Special files have no contents.
+			continue
+		}
+
+		if err := c.objects.Copy(e.ObjectID); err != nil {
+			return fmt.Errorf("unable to copy %v: %v", e.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Stats returns statistics of copied objects.
+func (c *Copier) Stats() repo.CopyStats {
+	return c.objects.Stats()
+}
@@ -0,0 +1,180 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kopia/kopia/auth"
+	"github.com/kopia/kopia/blob/filesystem"
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/dir"
+	"github.com/kopia/kopia/internal/mockfs"
+	"github.com/kopia/kopia/repo"
+)
+
+func newCopyTestRepository(t *testing.T, opt *repo.NewRepositoryOptions, password string) (*repo.Repository, string) {
+	ctx := context.Background()
+	repoDir, err := ioutil.TempDir("", "kopia-repo")
+	if err != nil {
+		t.Fatalf("cannot create temp directory: %v", err)
+	}
+
+	storage, err := filesystem.New(ctx, &filesystem.Options{Path: repoDir})
+	if err != nil {
+		t.Fatalf("cannot create storage: %v", err)
+	}
+
+	creds, _ := auth.Password(password)
+	if err := repo.Initialize(ctx, storage, opt, creds); err != nil {
+		t.Fatalf("unable to create repository: %v", err)
+	}
+
+	configFile := filepath.Join(repoDir, ".kopia.config")
+	if err := repo.Connect(ctx, configFile, storage, creds, repo.ConnectOptions{PersistCredentials: true}); err != nil {
+		t.Fatalf("unable to connect to repository: %v", err)
+	}
+
+	r, err := repo.Open(ctx, configFile, nil)
+	if err != nil {
+		t.Fatalf("unable to open repository: %v", err)
+	}
+
+	return r, repoDir
+}
+
+// readTree returns contents of all files in the directory tree keyed by their paths.
+func readTree(t *testing.T, r *repo.Repository, oid repo.ObjectID, prefix string, result map[string][]byte) {
+	rd, err := r.Open(oid)
+	if err != nil {
+		t.Fatalf("unable to open directory %v: %v", prefix, err)
+	}
+	defer rd.Close()
+
+	entries, err := dir.ReadEntries(rd)
+	if err != nil {
+		t.Fatalf("unable to read directory %v: %v", prefix, err)
+	}
+
+	for _, e := range entries {
+		if e.Type == fs.EntryTypeDirectory {
+			readTree(t, r, e.ObjectID, prefix+e.Name+"/", result)
+			continue
+		}
+
+		fr, err := r.Open(e.ObjectID)
+		if err != nil {
+			t.Fatalf("unable to open file %v: %v", prefix+e.Name, err)
+		}
+
+		b, err := ioutil.ReadAll(fr)
+		fr.Close()
+		if err != nil {
+			t.Fatalf("unable to read file %v: %v", prefix+e.Name, err)
+		}
+
+		result[prefix+e.Name] = b
+	}
+}
+
+func TestCopySnapshot(t *testing.T) {
+	srcRepo, srcDir := newCopyTestRepository(t, &repo.NewRepositoryOptions{
+		Splitter:     "FIXED",
+		MaxBlockSize: 1000,
+	}, "source-password")
+	defer os.RemoveAll(srcDir)
+
+	sourceDir := mockfs.NewDirectory()
+	sourceDir.AddFile("f1", []byte{1, 2, 3}, 0777)
+	sourceDir.AddFile("f2", bytes.Repeat([]byte{1, 2, 3, 4}, 2000), 0777)
+	sourceDir.AddDir("d1", 0777)
+	sourceDir.AddFile("d1/f1", bytes.Repeat([]byte{5}, 10), 0777)
+
+	srcSM := NewManager(srcRepo)
+	m, err := NewUploader(srcRepo).Upload(sourceDir, &SourceInfo{Host: "host", UserName: "user", Path: "/path"}, nil)
+	if err != nil {
+		t.Fatalf("upload error: %v", err)
+	}
+
+	if _, err := srcSM.SaveSnapshot(m); err != nil {
+		t.Fatalf("unable to save snapshot: %v", err)
+	}
+
+	incompatibleRepo, incompatibleDir := newCopyTestRepository(t, nil, "other-password")
+	defer os.RemoveAll(incompatibleDir)
+
+	if _, err := NewCopier(NewManager(incompatibleRepo), srcSM); err != repo.ErrIncompatibleObjectFormat {
+		t.Errorf("unexpected error when copying to incompatible repository: %v", err)
+	}
+
+	dstRepo, dstDir := newCopyTestRepository(t, srcRepo.CompatibleRepositoryOptions(), "other-password")
+	defer os.RemoveAll(dstDir)
+
+	dstSM := NewManager(dstRepo)
+	c, err := NewCopier(dstSM, srcSM)
+	if err != nil {
+		t.Fatalf("unable to create copier: %v", err)
+	}
+
+	manifestID, err := c.Copy(m)
+	if err != nil {
+		t.Fatalf("copy error: %v", err)
+	}
+
+	if stats := c.Stats(); stats.CopiedBlocks == 0 {
+		t.Errorf("no blocks copied: %+v", stats)
+	}
+
+	if saved, err := dstSM.LoadSnapshot(manifestID); err != nil || saved.RootObjectID.String() != m.RootObjectID.String() {
+		t.Errorf("unexpected saved manifest %v: %+v %v", manifestID, saved, err)
+	}
+
+	copied, err := dstSM.ListSnapshots(&m.Source, -1)
+	if err != nil || len(copied) != 1 {
+		t.Fatalf("unexpected snapshots in destination: %v %v", copied, err)
+	}
+
+	if got, want := copied[0].RootObjectID.String(), m.RootObjectID.String(); got != want {
+		t.Errorf("unexpected root object ID: %v, want %v", got, want)
+	}
+
+	srcFiles := map[string][]byte{}
+	readTree(t, srcRepo, m.RootObjectID, "", srcFiles)
+
+	dstFiles := map[string][]byte{}
+	readTree(t, dstRepo, copied[0].RootObjectID, "", dstFiles)
+
+	if len(srcFiles) != 3 || len(dstFiles) != len(srcFiles) {
+		t.Fatalf("unexpected files: %v and %v", len(srcFiles), len(dstFiles))
+	}
+
+	for k, v := range srcFiles {
+		if !bytes.Equal(v, dstFiles[k]) {
+			t.Errorf("file %v is different after copying", k)
+		}
+	}
+
+	// Roots of snapshots of data streamed from standard input are files.
+	stdin := sourceDir.AddFile("stdin", bytes.Repeat([]byte{6, 7}, 3000), 0600)
+	fm, err := NewUploader(srcRepo).Upload(stdin, &SourceInfo{Host: "host", UserName: "user", Path: StdinSourcePrefix + "stdin"}, nil)
+	if err != nil {
+		t.Fatalf("upload error: %v", err)
+	}
+
+	if _, err := c.Copy(fm); err != nil {
+		t.Fatalf("copy error: %v", err)
+	}
+
+	r, err := dstRepo.Open(fm.RootObjectID)
+	if err != nil {
+		t.Fatalf("unable to open copied file: %v", err)
+	}
+	defer r.Close()
+
+	if data, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(data, bytes.Repeat([]byte{6, 7}, 3000)) {
+		t.Errorf("unexpected contents of copied file: %v", err)
+	}
+}
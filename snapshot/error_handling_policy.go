@@ -0,0 +1,74 @@
+package snapshot
+
+import "os"
+
+// Categories of errors reading files and directories.
+const (
+	ErrorCategoryPermissionDenied = "permission-denied"
+	ErrorCategoryVanished         = "vanished"
+	ErrorCategoryIO               = "io-error"
+)
+
+// MaxRecordedEntryErrors is the maximum number of entries, which couldn't be read, recorded in a snapshot manifest.
+// All of them are counted in Stats.ReadErrors.
+const MaxRecordedEntryErrors = 100
+
+// EntryError describes a file or directory, which couldn't be read and has been omitted from the snapshot.
+type EntryError struct {
+	Path     string `json:"path"`
+	Category string `json:"category"`
+	Error    string `json:"error"`
+}
+
+// ErrorCategory returns the category of an error encountered while reading a file or directory.
+func ErrorCategory(err error) string {
+	switch {
+	case os.IsPermission(err):
+		return ErrorCategoryPermissionDenied
+
+	case os.IsNotExist(err):
+		// The entry has been removed after its directory has been listed.
+		return ErrorCategoryVanished
+
+	default:
+		return ErrorCategoryIO
+	}
+}
+
+// ErrorHandlingPolicy describes which categories of errors reading files and directories fail the snapshot.
+// Entries failing with other errors are omitted from the snapshot and recorded in its manifest.
+type ErrorHandlingPolicy struct {
+	FailOnPermissionDenied *bool `json:"failOnPermissionDenied,omitempty"`
+	FailOnVanished         *bool `json:"failOnVanished,omitempty"`
+	FailOnIOError          *bool `json:"failOnIOError,omitempty"`
+}
+
+// FailsSnapshot determines whether errors of the specified category fail the snapshot.
+func (p *ErrorHandlingPolicy) FailsSnapshot(category string) bool {
+	var v *bool
+
+	switch category {
+	case ErrorCategoryPermissionDenied:
+		v = p.FailOnPermissionDenied
+	case ErrorCategoryVanished:
+		v = p.FailOnVanished
+	case ErrorCategoryIO:
+		v = p.FailOnIOError
+	}
+
+	return v != nil && *v
+}
+
+func mergeErrorHandlingPolicy(dst, src *ErrorHandlingPolicy) {
+	if dst.FailOnPermissionDenied == nil {
+		dst.FailOnPermissionDenied = src.FailOnPermissionDenied
+	}
+
+	if dst.FailOnVanished == nil {
+		dst.FailOnVanished = src.FailOnVanished
+	}
+
+	if dst.FailOnIOError == nil {
+		dst.FailOnIOError = src.FailOnIOError
+	}
+}
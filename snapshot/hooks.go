@@ -0,0 +1,209 @@
+package snapshot
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultHookTimeout is the timeout of hook commands, which don't specify their own.
+const DefaultHookTimeout = 5 * time.Minute
+
+// Phases of a snapshot, in which hooks are executed.
+const (
+	HookPhaseBeforeSnapshot = "before-snapshot"
+	HookPhaseAfterSnapshot  = "after-snapshot"
+)
+
+// HookCommand describes a command executed before or after taking a snapshot.
+// The command is executed directly, without a shell.
+type HookCommand struct {
+	Command        string        `json:"command"`
+	Arguments      []string      `json:"args,omitempty"`
+	Timeout        time.Duration `json:"timeout,omitempty"`
+	AbortOnFailure bool          `json:"abortOnFailure,omitempty"`
+}
+
+func (h *HookCommand) String() string {
+	return strings.Join(append([]string{h.Command}, h.Arguments...), " ")
+}
+
+// HooksPolicy describes commands executed around taking a snapshot.
+type HooksPolicy struct {
+	BeforeSnapshot []HookCommand `json:"beforeSnapshot,omitempty"`
+	AfterSnapshot  []HookCommand `json:"afterSnapshot,omitempty"`
+}
+
+// HookResult describes the outcome of a single hook command, which is recorded in the snapshot manifest.
+type HookResult struct {
+	Phase     string        `json:"phase"`
+	Command   string        `json:"command"`
+	StartTime time.Time     `json:"startTime"`
+	Duration  time.Duration `json:"duration"`
+	ExitCode  int           `json:"exitCode"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// RunBeforeSnapshot executes hooks before taking a snapshot of the source.
+// Returns an error if a hook, which aborts the snapshot on failure, has failed. Remaining hooks are not executed in this case.
+func (p *HooksPolicy) RunBeforeSnapshot(src *SourceInfo) ([]HookResult, error) {
+	env := hookEnvironment(HookPhaseBeforeSnapshot, src, nil, nil)
+
+	var results []HookResult
+	for _, h := range p.BeforeSnapshot {
+		r := runHook(HookPhaseBeforeSnapshot, &h, env)
+		results = append(results, r)
+		if err := checkHookResult(&h, &r); err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// RunAfterSnapshot executes hooks after a snapshot of the source has been taken or has failed with snapshotErr,
+// in which case the manifest is nil. All hooks are executed, the returned error indicates that a hook,
+// which aborts the snapshot on failure, has failed.
+func (p *HooksPolicy) RunAfterSnapshot(src *SourceInfo, manifest *Manifest, snapshotErr error) ([]HookResult, error) {
+	env := hookEnvironment(HookPhaseAfterSnapshot, src, manifest, snapshotErr)
+
+	var results []HookResult
+	var firstErr error
+	for _, h := range p.AfterSnapshot {
+		r := runHook(HookPhaseAfterSnapshot, &h, env)
+		results = append(results, r)
+		if err := checkHookResult(&h, &r); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return results, firstErr
+}
+
+// checkHookResult returns an error if the hook has failed and should abort the snapshot, otherwise failures are only logged.
+func checkHookResult(h *HookCommand, r *HookResult) error {
+	if r.Error == "" {
+		return nil
+	}
+
+	if h.AbortOnFailure {
+		return fmt.Errorf("%v hook %q failed: %v", r.Phase, r.Command, r.Error)
+	}
+
+	log.Printf("warning: %v hook %q failed: %v", r.Phase, r.Command, r.Error)
+	return nil
+}
+
+func runHook(phase string, h *HookCommand, env []string) HookResult {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	r := HookResult{
+		Phase:     phase,
+		Command:   h.String(),
+		StartTime: time.Now(),
+	}
+
+	// Output is written to a file rather than a pipe, so that waiting for the command after timeout
+	// doesn't block on its child processes, which may still have the output open.
+	output, err := ioutil.TempFile("", "kopia-hook")
+	if err != nil {
+		r.ExitCode = -1
+		r.Error = fmt.Sprintf("unable to create output file: %v", err)
+		return r
+	}
+	defer os.Remove(output.Name())
+	defer output.Close()
+
+	cmd := exec.CommandContext(ctx, h.Command, h.Arguments...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	log.Printf("Running %v hook %q", phase, r.Command)
+	err = cmd.Run()
+	r.Duration = time.Since(r.StartTime)
+
+	if _, serr := output.Seek(0, io.SeekStart); serr == nil {
+		s := bufio.NewScanner(output)
+		for s.Scan() {
+			if line := s.Text(); line != "" {
+				log.Printf("[%v] %v", phase, line)
+			}
+		}
+	}
+
+	r.ExitCode = -1 // the command could not be started
+	if cmd.ProcessState != nil {
+		r.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		r.Error = fmt.Sprintf("timed out after %v", timeout)
+
+	case err != nil:
+		r.Error = err.Error()
+	}
+
+	return r
+}
+
+// hookEnvironment returns environment variables describing the source and the snapshot passed to hook commands.
+func hookEnvironment(phase string, src *SourceInfo, manifest *Manifest, snapshotErr error) []string {
+	env := []string{
+		"KOPIA_HOOK_PHASE=" + phase,
+		"KOPIA_SOURCE_HOST=" + src.Host,
+		"KOPIA_SOURCE_USER=" + src.UserName,
+		"KOPIA_SOURCE_PATH=" + src.Path,
+	}
+
+	if phase != HookPhaseAfterSnapshot {
+		return env
+	}
+
+	switch {
+	case snapshotErr != nil:
+		env = append(env, "KOPIA_SNAPSHOT_STATUS=failed", "KOPIA_SNAPSHOT_ERROR="+snapshotErr.Error())
+
+	case manifest.IncompleteReason != "":
+		env = append(env, "KOPIA_SNAPSHOT_STATUS=incomplete", "KOPIA_SNAPSHOT_INCOMPLETE_REASON="+manifest.IncompleteReason)
+
+	default:
+		env = append(env, "KOPIA_SNAPSHOT_STATUS=complete")
+	}
+
+	if manifest != nil {
+		env = append(env,
+			"KOPIA_SNAPSHOT_ROOT="+manifest.RootObjectID.String(),
+			"KOPIA_SNAPSHOT_START_TIME="+manifest.StartTime.Format(time.RFC3339),
+			"KOPIA_SNAPSHOT_END_TIME="+manifest.EndTime.Format(time.RFC3339),
+			fmt.Sprintf("KOPIA_SNAPSHOT_FILE_COUNT=%v", manifest.Stats.TotalFileCount),
+			fmt.Sprintf("KOPIA_SNAPSHOT_TOTAL_SIZE=%v", manifest.Stats.TotalFileSize),
+		)
+	}
+
+	return env
+}
+
+func mergeHooksPolicy(dst, src *HooksPolicy) {
+	if len(dst.BeforeSnapshot) == 0 {
+		dst.BeforeSnapshot = src.BeforeSnapshot
+	}
+
+	if len(dst.AfterSnapshot) == 0 {
+		dst.AfterSnapshot = src.AfterSnapshot
+	}
+}
@@ -0,0 +1,104 @@
+package snapshot
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func shellHook(script string, abortOnFailure bool) HookCommand {
+	return HookCommand{
+		Command:        "sh",
+		Arguments:      []string{"-c", script},
+		AbortOnFailure: abortOnFailure,
+	}
+}
+
+func TestHooks_BeforeSnapshot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook tests require a POSIX shell")
+	}
+
+	src := &SourceInfo{Host: "host", UserName: "user", Path: "/some/path"}
+
+	p := &HooksPolicy{
+		BeforeSnapshot: []HookCommand{
+			shellHook(`test "$KOPIA_SOURCE_PATH" = /some/path -a "$KOPIA_HOOK_PHASE" = before-snapshot`, true),
+			shellHook("exit 3", false),
+		},
+	}
+
+	results, err := p.RunBeforeSnapshot(src)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(results) != 2 || results[0].Error != "" || results[1].ExitCode != 3 || results[1].Error == "" {
+		t.Errorf("unexpected results: %+v", results)
+	}
+
+	// Failure of a hook, which aborts the snapshot, skips remaining hooks.
+	p.BeforeSnapshot = []HookCommand{
+		shellHook("exit 1", true),
+		shellHook("exit 0", false),
+	}
+
+	results, err = p.RunBeforeSnapshot(src)
+	if err == nil {
+		t.Errorf("expected error")
+	}
+
+	if len(results) != 1 {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
+
+func TestHooks_AfterSnapshot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook tests require a POSIX shell")
+	}
+
+	src := &SourceInfo{Host: "host", UserName: "user", Path: "/some/path"}
+	p := &HooksPolicy{
+		AfterSnapshot: []HookCommand{
+			shellHook("exit 1", true),
+			shellHook(`test "$KOPIA_SNAPSHOT_STATUS" = incomplete -a "$KOPIA_SNAPSHOT_INCOMPLETE_REASON" = canceled`, true),
+		},
+	}
+
+	// All after-snapshot hooks are executed even if one of them fails.
+	results, err := p.RunAfterSnapshot(src, &Manifest{IncompleteReason: "canceled"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "exit 1") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if len(results) != 2 || results[0].Error == "" || results[1].Error != "" {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
+
+func TestHooks_Timeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook tests require a POSIX shell")
+	}
+
+	h := shellHook("sleep 10", false)
+	h.Timeout = 100 * time.Millisecond
+
+	p := &HooksPolicy{BeforeSnapshot: []HookCommand{h}}
+
+	t0 := time.Now()
+	results, err := p.RunBeforeSnapshot(&SourceInfo{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if time.Since(t0) > 5*time.Second {
+		t.Errorf("hook has not been stopped after timeout")
+	}
+
+	if len(results) != 1 || !strings.Contains(results[0].Error, "timed out") {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
@@ -18,6 +18,11 @@ import (
 const snapshotPrefix = "S"
 const policyPrefix = "P"
 
+func init() {
+	// Snapshot manifests are never modified after they have been written.
+	repo.RegisterImmutableMetadataPrefix(snapshotPrefix)
+}
+
 // ErrPolicyNotFound is returned when the policy is not found.
 var ErrPolicyNotFound = errors.New("policy not found")
 
@@ -103,7 +108,12 @@ func (m *Manager) SaveSnapshot(manifest *Manifest) (string, error) {
 		return "", err
 	}
 
-	return "", nil
+	return manifestID, nil
+}
+
+// DeleteSnapshot removes the snapshot manifest with a given ID.
+func (m *Manager) DeleteSnapshot(manifestID string) error {
+	return m.repository.RemoveMetadata(manifestID)
 }
 
 // LoadSnapshots efficiently loads and parses a given list of snapshot IDs.
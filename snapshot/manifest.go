@@ -3,6 +3,7 @@ package snapshot
 import (
 	"time"
 
+	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/repo"
 )
 
@@ -15,10 +16,28 @@ type Manifest struct {
 	EndTime     time.Time `json:"endTime"`
 
 	RootObjectID        repo.ObjectID `json:"root"`
+	RootType            fs.EntryType  `json:"rootType,omitempty"`
 	HashCacheID         repo.ObjectID `json:"hashCache"`
 	HashCacheCutoffTime time.Time     `json:"hashCacheCutoff"`
 
-	Stats Stats `json:"stats"`
+	Stats       Stats        `json:"stats"`
+	EntryErrors []EntryError `json:"entryErrors,omitempty"`
 
 	IncompleteReason string `json:"incomplete,omitempty"`
+
+	Hooks []HookResult `json:"hooks,omitempty"`
+}
+
+// RootEntryType returns the type of the root entry of the snapshot. Snapshots, which don't record it,
+// have directory roots unless they hold data streamed from standard input.
+func (m *Manifest) RootEntryType() fs.EntryType {
+	if m.RootType != fs.EntryTypeUnknown {
+		return m.RootType
+	}
+
+	if _, ok := m.Source.StdinName(); ok {
+		return fs.EntryTypeFile
+	}
+
+	return fs.EntryTypeDirectory
 }
@@ -3,10 +3,13 @@ package snapshot
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"log"
 	"path/filepath"
+	"time"
 
 	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/ignore"
 )
 
 // ExpirationPolicy describes snapshot expiration policy.
@@ -30,13 +33,56 @@ var defaultExpirationPolicy = &ExpirationPolicy{
 
 // FilesPolicy describes files to be uploaded when taking snapshots
 type FilesPolicy struct {
-	Include []string `json:"include,omitempty"`
-	Exclude []string `json:"exclude,omitempty"`
-	MaxSize *int     `json:"maxSize,omitempty"`
+	Include       []string       `json:"include,omitempty"`
+	Exclude       []string       `json:"exclude,omitempty"`
+	ExcludePaths  []string       `json:"excludePaths,omitempty"`
+	MaxSize       *int           `json:"maxSize,omitempty"`
+	MinSize       *int           `json:"minSize,omitempty"`
+	MinAge        *time.Duration `json:"minAge,omitempty"`
+	MaxAge        *time.Duration `json:"maxAge,omitempty"`
+	ExcludeCaches *bool          `json:"excludeCaches,omitempty"`
+	MarkerFiles   []string       `json:"markerFiles,omitempty"`
+	OneFileSystem *bool          `json:"oneFileSystem,omitempty"`
 }
 
-// ShouldInclude determines whether given filesystem entry should be included based on the policy.
-func (p *FilesPolicy) ShouldInclude(e *fs.EntryMetadata) bool {
+// Names of rules returned by ExcludeReason() and ExcludeContentsReason().
+const (
+	ExcludedByInclude       = "include"
+	ExcludedByExclude       = "exclude"
+	ExcludedByExcludePath   = "exclude-path"
+	ExcludedByMaxSize       = "max-size"
+	ExcludedByMinSize       = "min-size"
+	ExcludedByMinAge        = "min-age"
+	ExcludedByMaxAge        = "max-age"
+	ExcludedByOneFileSystem = "one-file-system"
+	ExcludedByCacheDirTag   = "cache-dir-tag"
+	ExcludedByMarkerFile    = "marker-file"
+)
+
+// cacheDirTagName is the name of the file marking cache directories, see http://www.brynosaurus.com/cachedir/
+const cacheDirTagName = "CACHEDIR.TAG"
+
+var cacheDirTagSignature = []byte("Signature: 8a477f597d28d172789f06886806bc55")
+
+// ExcludeReason returns the name of the rule, which excludes the entry at the specified path relative to the snapshot root
+// or an empty string if the entry should be included. Age of files is computed relative to the provided time.
+func (p *FilesPolicy) ExcludeReason(relativePath string, e fs.Entry, root fs.Entry, now time.Time) string {
+	if r := p.excludeReasonByMetadata(relativePath, e.Metadata(), now); r != "" {
+		return r
+	}
+
+	if p.OneFileSystem != nil && *p.OneFileSystem && e.Metadata().Type == fs.EntryTypeDirectory {
+		le, ok1 := e.(fs.LocalEntry)
+		lr, ok2 := root.(fs.LocalEntry)
+		if ok1 && ok2 && le.LocalIdentity().Device != lr.LocalIdentity().Device {
+			return ExcludedByOneFileSystem
+		}
+	}
+
+	return ""
+}
+
+func (p *FilesPolicy) excludeReasonByMetadata(relativePath string, e *fs.EntryMetadata, now time.Time) string {
 	if len(p.Include) > 0 {
 		include := false
 		for _, i := range p.Include {
@@ -47,33 +93,94 @@ func (p *FilesPolicy) ShouldInclude(e *fs.EntryMetadata) bool {
 		}
 		if !include {
 			// have include rules, but none of them matched
-			return false
+			return ExcludedByInclude
+		}
+	}
+
+	for _, ex := range p.Exclude {
+		if fileNameMatches(e.Name, ex) {
+			return ExcludedByExclude
 		}
 	}
 
-	if len(p.Exclude) > 0 {
-		for _, ex := range p.Exclude {
-			if fileNameMatches(e.Name, ex) {
-				return false
+	for _, ex := range p.ExcludePaths {
+		if ignore.PathMatches(ex, relativePath) {
+			return ExcludedByExcludePath
+		}
+	}
+
+	if e.Type != fs.EntryTypeFile {
+		return ""
+	}
+
+	if p.MaxSize != nil && e.FileSize > int64(*p.MaxSize) {
+		return ExcludedByMaxSize
+	}
+
+	if p.MinSize != nil && e.FileSize < int64(*p.MinSize) {
+		return ExcludedByMinSize
+	}
+
+	if p.MinAge != nil && now.Sub(e.ModTime) < *p.MinAge {
+		return ExcludedByMinAge
+	}
+
+	if p.MaxAge != nil && now.Sub(e.ModTime) > *p.MaxAge {
+		return ExcludedByMaxAge
+	}
+
+	return ""
+}
+
+// ExcludeContentsReason returns the name of the rule, which excludes all entries of a directory,
+// or an empty string if entries of the directory should be considered individually.
+func (p *FilesPolicy) ExcludeContentsReason(entries fs.Entries) string {
+	for _, e := range entries {
+		name := e.Metadata().Name
+
+		for _, m := range p.MarkerFiles {
+			if name == m {
+				return ExcludedByMarkerFile
+			}
+		}
+
+		if p.ExcludeCaches != nil && *p.ExcludeCaches && name == cacheDirTagName {
+			if f, ok := e.(fs.File); ok && hasCacheDirTagSignature(f) {
+				return ExcludedByCacheDirTag
 			}
 		}
 	}
 
-	if p.MaxSize != nil && e.Type == fs.EntryTypeFile && e.FileSize > int64(*p.MaxSize) {
+	return ""
+}
+
+func hasCacheDirTagSignature(f fs.File) bool {
+	r, err := f.Open()
+	if err != nil {
+		log.Printf("warning: unable to open %v: %v", cacheDirTagName, err)
+		return false
+	}
+	defer r.Close()
+
+	sig := make([]byte, len(cacheDirTagSignature))
+	if _, err := io.ReadFull(r, sig); err != nil {
 		return false
 	}
 
-	return true
+	return bytes.Equal(sig, cacheDirTagSignature)
 }
 
 var defaultFilesPolicy = &FilesPolicy{}
 
 // Policy describes snapshot policy for a single source.
 type Policy struct {
-	Source           SourceInfo       `json:"source"`
-	ExpirationPolicy ExpirationPolicy `json:"expiration"`
-	FilesPolicy      FilesPolicy      `json:"files"`
-	NoParent         bool             `json:"noParent,omitempty"`
+	Source              SourceInfo          `json:"source"`
+	ExpirationPolicy    ExpirationPolicy    `json:"expiration"`
+	FilesPolicy         FilesPolicy         `json:"files"`
+	SchedulingPolicy    SchedulingPolicy    `json:"scheduling"`
+	HooksPolicy         HooksPolicy         `json:"hooks"`
+	ErrorHandlingPolicy ErrorHandlingPolicy `json:"errorHandling"`
+	NoParent            bool                `json:"noParent,omitempty"`
 }
 
 func (p *Policy) String() string {
@@ -105,6 +212,9 @@ func mergePolicies(policies []*Policy) *Policy {
 
 		mergeExpirationPolicy(&merged.ExpirationPolicy, &p.ExpirationPolicy)
 		mergeFilesPolicy(&merged.FilesPolicy, &p.FilesPolicy)
+		mergeSchedulingPolicy(&merged.SchedulingPolicy, &p.SchedulingPolicy)
+		mergeHooksPolicy(&merged.HooksPolicy, &p.HooksPolicy)
+		mergeErrorHandlingPolicy(&merged.ErrorHandlingPolicy, &p.ErrorHandlingPolicy)
 	}
 
 	// Merge default expiration policy.
@@ -140,6 +250,26 @@ func mergeFilesPolicy(dst, src *FilesPolicy) {
 		dst.MaxSize = src.MaxSize
 	}
 
+	if dst.MinSize == nil {
+		dst.MinSize = src.MinSize
+	}
+
+	if dst.MinAge == nil {
+		dst.MinAge = src.MinAge
+	}
+
+	if dst.MaxAge == nil {
+		dst.MaxAge = src.MaxAge
+	}
+
+	if dst.ExcludeCaches == nil {
+		dst.ExcludeCaches = src.ExcludeCaches
+	}
+
+	if dst.OneFileSystem == nil {
+		dst.OneFileSystem = src.OneFileSystem
+	}
+
 	if len(dst.Include) == 0 {
 		dst.Include = src.Include
 	}
@@ -147,6 +277,14 @@ func mergeFilesPolicy(dst, src *FilesPolicy) {
 	if len(dst.Exclude) == 0 {
 		dst.Exclude = src.Exclude
 	}
+
+	if len(dst.ExcludePaths) == 0 {
+		dst.ExcludePaths = src.ExcludePaths
+	}
+
+	if len(dst.MarkerFiles) == 0 {
+		dst.MarkerFiles = src.MarkerFiles
+	}
 }
 
 func intPtr(n int) *int {
@@ -0,0 +1,136 @@
+package snapshot
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/mockfs"
+)
+
+func TestFilesPolicy_ExcludeReason(t *testing.T) {
+	root := mockfs.NewDirectory()
+	small := root.AddFile("small.txt", []byte{1}, 0777)
+	large := root.AddFile("large.txt", make([]byte, 1000), 0777)
+	logs := root.AddDir("logs", 0777)
+	nested := root.AddFile("logs/x.bin", []byte{1, 2, 3}, 0777)
+
+	now := time.Now()
+	small.Metadata().ModTime = now.Add(-1 * time.Minute)
+	large.Metadata().ModTime = now.Add(-48 * time.Hour)
+	nested.Metadata().ModTime = now.Add(-1 * time.Hour)
+
+	minAge := 10 * time.Minute
+	maxAge := 24 * time.Hour
+
+	cases := []struct {
+		policy FilesPolicy
+		path   string
+		entry  fs.Entry
+		reason string
+	}{
+		{FilesPolicy{}, "./small.txt", small, ""},
+		{FilesPolicy{Exclude: []string{"*.txt"}}, "./small.txt", small, ExcludedByExclude},
+		{FilesPolicy{Include: []string{"*.bin"}}, "./small.txt", small, ExcludedByInclude},
+		{FilesPolicy{ExcludePaths: []string{"logs"}}, "./logs", logs, ExcludedByExcludePath},
+		{FilesPolicy{ExcludePaths: []string{"/logs/*.bin"}}, "./logs/x.bin", nested, ExcludedByExcludePath},
+		{FilesPolicy{ExcludePaths: []string{"**/*.bin"}}, "./logs/x.bin", nested, ExcludedByExcludePath},
+		{FilesPolicy{ExcludePaths: []string{"*.bin"}}, "./logs/x.bin", nested, ""},
+		{FilesPolicy{MaxSize: intPtr(100)}, "./large.txt", large, ExcludedByMaxSize},
+		{FilesPolicy{MaxSize: intPtr(100)}, "./small.txt", small, ""},
+		{FilesPolicy{MinSize: intPtr(100)}, "./small.txt", small, ExcludedByMinSize},
+		{FilesPolicy{MinSize: intPtr(100)}, "./logs", logs, ""},
+		{FilesPolicy{MinAge: &minAge}, "./small.txt", small, ExcludedByMinAge},
+		{FilesPolicy{MinAge: &minAge}, "./logs/x.bin", nested, ""},
+		{FilesPolicy{MaxAge: &maxAge}, "./large.txt", large, ExcludedByMaxAge},
+		{FilesPolicy{MaxAge: &maxAge}, "./logs/x.bin", nested, ""},
+	}
+
+	for _, tc := range cases {
+		if reason := tc.policy.ExcludeReason(tc.path, tc.entry, root, now); reason != tc.reason {
+			t.Errorf("unexpected exclude reason for %v with %+v: %q, expected %q", tc.path, tc.policy, reason, tc.reason)
+		}
+	}
+}
+
+func TestFilesPolicy_ExcludeContentsReason(t *testing.T) {
+	cacheDir := mockfs.NewDirectory()
+	cacheDir.AddFile("CACHEDIR.TAG", []byte("Signature: 8a477f597d28d172789f06886806bc55\n# comment"), 0777)
+	cacheDir.AddFile("data", []byte{1, 2, 3}, 0777)
+
+	fakeCacheDir := mockfs.NewDirectory()
+	fakeCacheDir.AddFile("CACHEDIR.TAG", []byte("not a tag"), 0777)
+
+	markedDir := mockfs.NewDirectory()
+	markedDir.AddFile(".nobackup", nil, 0777)
+
+	cases := []struct {
+		policy FilesPolicy
+		dir    *mockfs.Directory
+		reason string
+	}{
+		{FilesPolicy{}, cacheDir, ""},
+		{FilesPolicy{ExcludeCaches: boolPtr(true)}, cacheDir, ExcludedByCacheDirTag},
+		{FilesPolicy{ExcludeCaches: boolPtr(false)}, cacheDir, ""},
+		{FilesPolicy{ExcludeCaches: boolPtr(true)}, fakeCacheDir, ""},
+		{FilesPolicy{MarkerFiles: []string{".nobackup"}}, markedDir, ExcludedByMarkerFile},
+		{FilesPolicy{MarkerFiles: []string{".nobackup"}}, cacheDir, ""},
+	}
+
+	for i, tc := range cases {
+		entries, err := tc.dir.Readdir()
+		if err != nil {
+			t.Fatalf("unable to read directory: %v", err)
+		}
+
+		if reason := tc.policy.ExcludeContentsReason(entries); reason != tc.reason {
+			t.Errorf("case %v: unexpected exclude reason: %q, expected %q", i, reason, tc.reason)
+		}
+	}
+}
+
+func TestMergeFilesPolicy(t *testing.T) {
+	minAge := time.Hour
+	merged := mergePolicies([]*Policy{
+		{FilesPolicy: FilesPolicy{MinSize: intPtr(10), MarkerFiles: []string{".nobackup"}}},
+		{FilesPolicy: FilesPolicy{MinSize: intPtr(20), MinAge: &minAge, OneFileSystem: boolPtr(true)}},
+	})
+
+	if *merged.FilesPolicy.MinSize != 10 || *merged.FilesPolicy.MinAge != minAge || !*merged.FilesPolicy.OneFileSystem {
+		t.Errorf("unexpected merged policy: %v", merged)
+	}
+
+	if len(merged.FilesPolicy.MarkerFiles) != 1 || merged.FilesPolicy.MarkerFiles[0] != ".nobackup" {
+		t.Errorf("unexpected merged marker files: %v", merged.FilesPolicy.MarkerFiles)
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestErrorHandlingPolicy(t *testing.T) {
+	merged := mergePolicies([]*Policy{
+		{ErrorHandlingPolicy: ErrorHandlingPolicy{FailOnVanished: boolPtr(false)}},
+		{ErrorHandlingPolicy: ErrorHandlingPolicy{FailOnVanished: boolPtr(true), FailOnIOError: boolPtr(true)}},
+	})
+
+	p := &merged.ErrorHandlingPolicy
+	if p.FailsSnapshot(ErrorCategoryPermissionDenied) || p.FailsSnapshot(ErrorCategoryVanished) || !p.FailsSnapshot(ErrorCategoryIO) {
+		t.Errorf("unexpected merged policy: %v", merged)
+	}
+
+	cases := map[error]string{
+		&os.PathError{Op: "open", Path: "x", Err: os.ErrPermission}: ErrorCategoryPermissionDenied,
+		&os.PathError{Op: "open", Path: "x", Err: os.ErrNotExist}:   ErrorCategoryVanished,
+		io.ErrUnexpectedEOF: ErrorCategoryIO,
+	}
+
+	for err, category := range cases {
+		if got := ErrorCategory(err); got != category {
+			t.Errorf("unexpected category of %v: %v, expected %v", err, got, category)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeOfDay represents a local time of day.
+type TimeOfDay struct {
+	Hour   int `json:"hour"`
+	Minute int `json:"min"`
+}
+
+func (t TimeOfDay) String() string {
+	return fmt.Sprintf("%02v:%02v", t.Hour, t.Minute)
+}
+
+// minutes returns the number of minutes since midnight.
+func (t TimeOfDay) minutes() int {
+	return t.Hour*60 + t.Minute
+}
+
+// on returns the time on the day of the specified time.
+func (t TimeOfDay) on(day time.Time) time.Time {
+	y, m, d := day.Date()
+	return time.Date(y, m, d, t.Hour, t.Minute, 0, 0, day.Location())
+}
+
+// ParseTimeOfDay parses time of day in HH:MM format.
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	var t TimeOfDay
+	if _, err := fmt.Sscanf(s, "%d:%d", &t.Hour, &t.Minute); err != nil {
+		return t, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+
+	if t.Hour < 0 || t.Hour > 23 || t.Minute < 0 || t.Minute > 59 {
+		return t, fmt.Errorf("time of day out of range: %q", s)
+	}
+
+	return t, nil
+}
+
+// TimeWindow represents a daily window of local time. Windows ending before they start span midnight.
+type TimeWindow struct {
+	Start TimeOfDay `json:"start"`
+	End   TimeOfDay `json:"end"`
+}
+
+func (w TimeWindow) String() string {
+	return w.Start.String() + "-" + w.End.String()
+}
+
+// contains determines whether the window contains the specified time.
+func (w TimeWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.Start.minutes() <= w.End.minutes() {
+		return m >= w.Start.minutes() && m < w.End.minutes()
+	}
+
+	return m >= w.Start.minutes() || m < w.End.minutes()
+}
+
+// ParseTimeWindow parses time window in HH:MM-HH:MM format.
+func ParseTimeWindow(s string) (TimeWindow, error) {
+	var w TimeWindow
+
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return w, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", s)
+	}
+
+	var err error
+	if w.Start, err = ParseTimeOfDay(parts[0]); err != nil {
+		return w, err
+	}
+
+	if w.End, err = ParseTimeOfDay(parts[1]); err != nil {
+		return w, err
+	}
+
+	return w, nil
+}
+
+// SchedulingPolicy describes when snapshots of a source are taken automatically.
+type SchedulingPolicy struct {
+	Interval   *time.Duration `json:"interval,omitempty"`
+	TimesOfDay []TimeOfDay    `json:"timeOfDay,omitempty"`
+	Windows    []TimeWindow   `json:"windows,omitempty"`
+}
+
+// IsScheduled returns true if the policy requests snapshots to be taken automatically.
+func (p *SchedulingPolicy) IsScheduled() bool {
+	return (p.Interval != nil && *p.Interval > 0) || len(p.TimesOfDay) > 0
+}
+
+// NextSnapshotTime returns the time, at which the next snapshot is due, given the start time of the previous snapshot,
+// which is zero if there were none. The returned time may be in the past, in which case the snapshot is overdue,
+// except when the policy has windows, in which case overdue snapshots are due at the earliest allowed time from now on.
+// Returns false if snapshots are not scheduled.
+func (p *SchedulingPolicy) NextSnapshotTime(previous, now time.Time) (time.Time, bool) {
+	if !p.IsScheduled() {
+		return time.Time{}, false
+	}
+
+	var next time.Time
+
+	if p.Interval != nil && *p.Interval > 0 {
+		if previous.IsZero() {
+			next = now
+		} else {
+			next = previous.Add(*p.Interval)
+		}
+	}
+
+	after := previous
+	if after.IsZero() {
+		after = now
+	}
+
+	for _, tod := range p.TimesOfDay {
+		t := tod.on(after)
+		if !t.After(after) {
+			t = tod.on(after.AddDate(0, 0, 1))
+		}
+
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+
+	// Overdue snapshot can't be taken in a window, which has already passed.
+	if len(p.Windows) > 0 && next.Before(now) {
+		next = now
+	}
+
+	return p.nextAllowedTime(next), true
+}
+
+// ResumeTime returns the time, at which a scheduled snapshot interrupted after saving a checkpoint is resumed,
+// which is now or the start of the next window. Returns false if snapshots are not scheduled.
+func (p *SchedulingPolicy) ResumeTime(now time.Time) (time.Time, bool) {
+	if !p.IsScheduled() {
+		return time.Time{}, false
+	}
+
+	return p.nextAllowedTime(now), true
+}
+
+// nextAllowedTime returns the earliest time at or after t, which falls in one of the windows.
+func (p *SchedulingPolicy) nextAllowedTime(t time.Time) time.Time {
+	if len(p.Windows) == 0 || p.IsAllowed(t) {
+		return t
+	}
+
+	var result time.Time
+	for _, w := range p.Windows {
+		s := w.Start.on(t)
+		if s.Before(t) {
+			s = w.Start.on(t.AddDate(0, 0, 1))
+		}
+
+		if result.IsZero() || s.Before(result) {
+			result = s
+		}
+	}
+
+	return result
+}
+
+// IsAllowed determines whether snapshots may be taken at the specified time.
+func (p *SchedulingPolicy) IsAllowed(t time.Time) bool {
+	if len(p.Windows) == 0 {
+		return true
+	}
+
+	for _, w := range p.Windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mergeSchedulingPolicy(dst, src *SchedulingPolicy) {
+	if dst.Interval == nil {
+		dst.Interval = src.Interval
+	}
+
+	if len(dst.TimesOfDay) == 0 {
+		dst.TimesOfDay = src.TimesOfDay
+	}
+
+	if len(dst.Windows) == 0 {
+		dst.Windows = src.Windows
+	}
+}
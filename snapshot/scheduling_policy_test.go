@@ -0,0 +1,95 @@
+package snapshot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulingPolicy_NextSnapshotTime(t *testing.T) {
+	hour := time.Hour
+	day := func(h, m int) time.Time {
+		return time.Date(2018, 3, 10, h, m, 0, 0, time.Local)
+	}
+
+	cases := []struct {
+		policy   SchedulingPolicy
+		previous time.Time
+		now      time.Time
+		next     time.Time
+		ok       bool
+	}{
+		{SchedulingPolicy{}, day(10, 0), day(12, 0), time.Time{}, false},
+
+		// interval
+		{SchedulingPolicy{Interval: &hour}, time.Time{}, day(12, 0), day(12, 0), true},
+		{SchedulingPolicy{Interval: &hour}, day(11, 30), day(12, 0), day(12, 30), true},
+		{SchedulingPolicy{Interval: &hour}, day(3, 0), day(12, 0), day(4, 0), true},
+
+		// times of day
+		{SchedulingPolicy{TimesOfDay: []TimeOfDay{{2, 0}, {14, 30}}}, day(3, 0), day(12, 0), day(14, 30), true},
+		{SchedulingPolicy{TimesOfDay: []TimeOfDay{{2, 0}}}, day(3, 0), day(12, 0), day(2, 0).AddDate(0, 0, 1), true},
+		{SchedulingPolicy{TimesOfDay: []TimeOfDay{{2, 0}}}, day(1, 0), day(12, 0), day(2, 0), true},
+		{SchedulingPolicy{TimesOfDay: []TimeOfDay{{2, 0}}}, time.Time{}, day(12, 0), day(2, 0).AddDate(0, 0, 1), true},
+
+		// interval and times of day, earlier wins
+		{SchedulingPolicy{Interval: &hour, TimesOfDay: []TimeOfDay{{11, 15}}}, day(11, 0), day(11, 0), day(11, 15), true},
+
+		// windows
+		{SchedulingPolicy{Interval: &hour, Windows: []TimeWindow{{TimeOfDay{22, 0}, TimeOfDay{6, 0}}}}, day(11, 0), day(11, 0), day(22, 0), true},
+		{SchedulingPolicy{Interval: &hour, Windows: []TimeWindow{{TimeOfDay{22, 0}, TimeOfDay{6, 0}}}}, day(1, 0), day(3, 0), day(3, 0), true},
+		{SchedulingPolicy{Interval: &hour, Windows: []TimeWindow{{TimeOfDay{22, 0}, TimeOfDay{6, 0}}}}, day(1, 0), day(11, 0), day(22, 0), true},
+		{SchedulingPolicy{Interval: &hour, Windows: []TimeWindow{{TimeOfDay{9, 0}, TimeOfDay{10, 0}}, {TimeOfDay{13, 0}, TimeOfDay{14, 0}}}}, day(10, 30), day(11, 0), day(13, 0), true},
+	}
+
+	for i, tc := range cases {
+		next, ok := tc.policy.NextSnapshotTime(tc.previous, tc.now)
+		if ok != tc.ok || !next.Equal(tc.next) {
+			t.Errorf("case %v: unexpected next snapshot time: %v %v, expected %v %v", i, next, ok, tc.next, tc.ok)
+		}
+	}
+}
+
+func TestSchedulingPolicy_ResumeTime(t *testing.T) {
+	day := func(h, m int) time.Time {
+		return time.Date(2018, 3, 10, h, m, 0, 0, time.Local)
+	}
+
+	cases := []struct {
+		policy SchedulingPolicy
+		now    time.Time
+		next   time.Time
+		ok     bool
+	}{
+		{SchedulingPolicy{}, day(12, 0), time.Time{}, false},
+
+		// interrupted snapshots are resumed immediately even if the next time of day is tomorrow
+		{SchedulingPolicy{TimesOfDay: []TimeOfDay{{2, 0}}}, day(12, 0), day(12, 0), true},
+
+		// but not outside of windows
+		{SchedulingPolicy{TimesOfDay: []TimeOfDay{{2, 0}}, Windows: []TimeWindow{{TimeOfDay{22, 0}, TimeOfDay{6, 0}}}}, day(12, 0), day(22, 0), true},
+	}
+
+	for i, tc := range cases {
+		next, ok := tc.policy.ResumeTime(tc.now)
+		if ok != tc.ok || !next.Equal(tc.next) {
+			t.Errorf("case %v: unexpected resume time: %v %v, expected %v %v", i, next, ok, tc.next, tc.ok)
+		}
+	}
+}
+
+func TestParseTimeWindow(t *testing.T) {
+	w, err := ParseTimeWindow("22:30-06:15")
+	if err != nil {
+		t.Fatalf("unable to parse time window: %v", err)
+	}
+
+	if w.String() != "22:30-06:15" {
+		t.Errorf("unexpected time window: %v", w)
+	}
+
+	for _, s := range []string{"", "22:30", "25:00-01:00", "10:00-10:60", "a-b"} {
+		if _, err := ParseTimeWindow(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
@@ -20,6 +20,54 @@ type SourceInfo struct {
 	Path     string `json:"path"`
 }
 
+// StdinSourcePrefix is the prefix of paths of synthetic sources of data streamed from standard input.
+const StdinSourcePrefix = "stdin:"
+
+// StdinSourceInfo returns the synthetic source of named data streamed from standard input.
+func StdinSourceInfo(name string, hostname string, username string) SourceInfo {
+	return SourceInfo{
+		Host:     hostname,
+		UserName: username,
+		Path:     StdinSourcePrefix + name,
+	}
+}
+
+// StdinName returns the name of data streamed from standard input if the source is a synthetic stdin source.
+func (ssi SourceInfo) StdinName() (string, bool) {
+	if !strings.HasPrefix(ssi.Path, StdinSourcePrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(ssi.Path, StdinSourcePrefix), true
+}
+
+// TarSourcePrefix is the prefix of paths of synthetic sources of contents of tar archives.
+const TarSourcePrefix = "tar:"
+
+// TarSourceInfo returns the synthetic source of contents of the tar archive with the specified absolute path.
+func TarSourceInfo(archivePath string, hostname string, username string) SourceInfo {
+	return SourceInfo{
+		Host:     hostname,
+		UserName: username,
+		Path:     TarSourcePrefix + archivePath,
+	}
+}
+
+// TarPath returns the path of the tar archive if the source is a synthetic tar source.
+func (ssi SourceInfo) TarPath() (string, bool) {
+	if !strings.HasPrefix(ssi.Path, TarSourcePrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(ssi.Path, TarSourcePrefix), true
+}
+
+// IsSynthetic returns true if the source is not a local file or directory, but data streamed
+// from standard input or contents of a tar archive.
+func (ssi SourceInfo) IsSynthetic() bool {
+	return strings.HasPrefix(ssi.Path, StdinSourcePrefix) || strings.HasPrefix(ssi.Path, TarSourcePrefix)
+}
+
 func (ssi SourceInfo) String() string {
 	if ssi.Host == "" && ssi.Path == "" && ssi.UserName == "" {
 		return "(global)"
@@ -63,6 +111,19 @@ func ParseSourceInfo(path string, hostname string, username string) (SourceInfo,
 		return SourceInfo{}, fmt.Errorf("invalid hostname in %q", path)
 	}
 
+	if strings.HasPrefix(path, StdinSourcePrefix) {
+		return StdinSourceInfo(strings.TrimPrefix(path, StdinSourcePrefix), hostname, username), nil
+	}
+
+	if strings.HasPrefix(path, TarSourcePrefix) {
+		archivePath, err := filepath.Abs(strings.TrimPrefix(path, TarSourcePrefix))
+		if err != nil {
+			return SourceInfo{}, fmt.Errorf("invalid archive path: '%s': %s", path, err)
+		}
+
+		return TarSourceInfo(filepath.Clean(archivePath), hostname, username), nil
+	}
+
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		return SourceInfo{}, fmt.Errorf("invalid directory: '%s': %s", path, err)
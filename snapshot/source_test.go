@@ -0,0 +1,54 @@
+package snapshot
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSourceInfo_Stdin(t *testing.T) {
+	si, err := ParseSourceInfo("stdin:dump.sql", "host", "user")
+	if err != nil {
+		t.Fatalf("unable to parse: %v", err)
+	}
+
+	if si != StdinSourceInfo("dump.sql", "host", "user") {
+		t.Errorf("unexpected source: %v", si)
+	}
+
+	if name, ok := si.StdinName(); !ok || name != "dump.sql" {
+		t.Errorf("unexpected stdin name: %v %v", name, ok)
+	}
+
+	si, err = ParseSourceInfo("other@otherhost:stdin:dump.sql", "host", "user")
+	if err != nil {
+		t.Fatalf("unable to parse: %v", err)
+	}
+
+	if name, ok := si.StdinName(); !ok || name != "dump.sql" || si.Host != "otherhost" {
+		t.Errorf("unexpected source: %v", si)
+	}
+
+	if _, ok := (SourceInfo{Host: "host", UserName: "user", Path: "/tmp"}).StdinName(); ok {
+		t.Errorf("local path treated as stdin")
+	}
+}
+
+func TestParseSourceInfo_Tar(t *testing.T) {
+	si, err := ParseSourceInfo("tar:data.tar", "host", "user")
+	if err != nil {
+		t.Fatalf("unable to parse: %v", err)
+	}
+
+	expectedPath, _ := filepath.Abs("data.tar")
+	if p, ok := si.TarPath(); !ok || p != expectedPath || !si.IsSynthetic() {
+		t.Errorf("unexpected source: %v", si)
+	}
+
+	if (SourceInfo{Host: "host", UserName: "user", Path: "/tmp"}).IsSynthetic() {
+		t.Errorf("local path treated as synthetic")
+	}
+
+	if !StdinSourceInfo("dump.sql", "host", "user").IsSynthetic() {
+		t.Errorf("stdin source not treated as synthetic")
+	}
+}
@@ -13,8 +13,11 @@ type Stats struct {
 	ExcludedFileCount     int   `json:"excludedFileCount"`
 	ExcludedTotalFileSize int64 `json:"excludedTotalSize"`
 
-	CachedFiles    int `json:"cachedFiles"`
-	NonCachedFiles int `json:"nonCachedFiles"`
+	CachedFiles       int `json:"cachedFiles"`
+	NonCachedFiles    int `json:"nonCachedFiles"`
+	CachedDirectories int `json:"cachedDirs"`
 
-	ReadErrors int `json:"readErrors"`
+	ReadErrors        int `json:"readErrors"`
+	InconsistentFiles int `json:"inconsistentFiles"`
+	HardLinkedFiles   int `json:"hardLinkedFiles"`
 }
@@ -7,30 +7,88 @@ import (
 	"fmt"
 	"hash/fnv"
 	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/kopia/kopia/fs"
 	"github.com/kopia/kopia/internal/dir"
 	"github.com/kopia/kopia/internal/hashcache"
+	"github.com/kopia/kopia/internal/ignore"
 	"github.com/kopia/kopia/repo"
 )
 
-func hashEntryMetadata(w io.Writer, e *fs.EntryMetadata) {
-	binary.Write(w, binary.LittleEndian, e.Name)
-	binary.Write(w, binary.LittleEndian, e.ModTime.UnixNano())
-	binary.Write(w, binary.LittleEndian, e.FileMode())
-	binary.Write(w, binary.LittleEndian, e.FileSize)
-	binary.Write(w, binary.LittleEndian, e.UserID)
-	binary.Write(w, binary.LittleEndian, e.GroupID)
+// hashEntryMetadata writes metadata of the entry, which determines whether its contents must be read again, to the hash.
+// Names are included, so that directories containing renamed entries don't match their hash cache entries.
+func hashEntryMetadata(w io.Writer, e *fs.EntryMetadata) error {
+	if _, err := io.WriteString(w, e.Name); err != nil {
+		return err
+	}
+
+	for _, v := range []interface{}{e.ModTime.UnixNano(), e.FileMode(), e.FileSize, e.UserID, e.GroupID} {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+
+	// Attributes, which most entries don't have, don't affect their hashes when absent,
+	// so that hash caches of snapshots taken before they were captured remain valid.
+	if e.Device != nil {
+		if err := binary.Write(w, binary.LittleEndian, e.Device.Major); err != nil {
+			return err
+		}
+
+		if err := binary.Write(w, binary.LittleEndian, e.Device.Minor); err != nil {
+			return err
+		}
+	}
+
+	var names []string
+	for n := range e.ExtendedAttributes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		if _, err := io.WriteString(w, n); err != nil {
+			return err
+		}
+
+		if _, err := w.Write(e.ExtendedAttributes[n]); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range []string{e.ACL, e.DefaultACL, e.HardLinkID} {
+		if _, err := io.WriteString(w, s); err != nil {
+			return err
+		}
+	}
+
+	for _, h := range e.Holes {
+		if err := binary.Write(w, binary.LittleEndian, h.Offset); err != nil {
+			return err
+		}
+
+		if err := binary.Write(w, binary.LittleEndian, h.Length); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func metadataHash(e *fs.EntryMetadata) uint64 {
+func metadataHash(e *fs.EntryMetadata) (uint64, error) {
 	h := fnv.New64a()
-	hashEntryMetadata(h, e)
-	return h.Sum64()
+	if err := hashEntryMetadata(h, e); err != nil {
+		return 0, fmt.Errorf("unable to hash metadata of %v: %v", e.Name, err)
+	}
+
+	return h.Sum64(), nil
 }
 
 var errCancelled = errors.New("cancelled")
@@ -42,10 +100,13 @@ type Uploader struct {
 	// specifies criteria for including and excluding files.
 	FilesPolicy FilesPolicy
 
+	// specifies categories of errors reading files and directories, which fail the upload even if IgnoreFileErrors is set.
+	ErrorHandlingPolicy ErrorHandlingPolicy
+
 	// automatically cancel the Upload after certain number of bytes
 	MaxUploadBytes int64
 
-	// ignore file read errors
+	// ignore file read errors, which are recorded in the manifest instead
 	IgnoreFileErrors bool
 
 	// probability with hich hashcache entries will be ignored, must be [0..100]
@@ -57,14 +118,41 @@ type Uploader struct {
 	// Protects from accidentally caching incorrect hashes of files that are being modified.
 	HashCacheMinAge time.Duration
 
-	uploadBuf   []byte
+	// number of times a file, which changes while being read, is read again before storing it as possibly inconsistent
+	ChangedFileRetries int
+
+	// number of files hashed and uploaded concurrently, files are uploaded one at a time when less than 2
+	ParallelUploads int
+
+	// optional local database of hashes of local files, used instead of the hash cache of the previous snapshot
+	// when it contains the uploaded directory
+	LocalHashCache *hashcache.DB
+
+	// interval between checkpoints, which save incomplete snapshots of directories uploaded so far, 0 disables checkpoints
+	CheckpointInterval time.Duration
+
+	// invoked with the incomplete manifest of each checkpoint, which must be saved so that the upload can be resumed
+	CheckpointFunc func(m *Manifest) error
+
 	repo        *repo.Repository
+	progress    UploadProgress
 	cacheWriter hashcache.Writer
 	cacheReader hashcache.Reader
 
+	useLocalHashCache bool
+
 	hashCacheCutoff time.Time
+	root            fs.Entry       // uploaded source, used to apply FilesPolicy
+	sourceInfo      *SourceInfo    // source of the upload, used in manifests of checkpoints
+	startTime       time.Time      // time used to compute age of files by FilesPolicy
+	oldHashCacheID  *repo.ObjectID // hash cache of the previous snapshot, copied to checkpoints
+	checkpoint      uploadCheckpoint
+	hardLinks       map[string]*uploadItem     // first item of each group of hard links, keyed by HardLinkID
+	pendingDirInfos []*hashcache.DirectoryInfo // local hash cache of committed directories, saved once their objects are stored
 	stats           Stats
+	entryErrors     []EntryError
 	cancelled       int32
+	aborted         int32
 }
 
 // IsCancelled returns true if the upload is cancelled.
@@ -84,10 +172,13 @@ func (u *Uploader) cancelReason() string {
 	return ""
 }
 
-func (u *Uploader) uploadFileInternal(f fs.File, relativePath string) (*dir.Entry, uint64, error) {
+// uploadFileInternal uploads contents of the file except for the specified holes. The returned entry describes the file
+// after reading it, including its holes, which may differ from the skipped ones if the file has changed in the meantime.
+func (u *Uploader) uploadFileInternal(f fs.File, relativePath string, holes []fs.Hole) (*dir.Entry, uint64, error) {
+	// Errors are returned as-is, so that they can be categorized.
 	file, err := f.Open()
 	if err != nil {
-		return nil, 0, fmt.Errorf("unable to open file: %v", err)
+		return nil, 0, err
 	}
 	defer file.Close()
 
@@ -96,39 +187,86 @@ func (u *Uploader) uploadFileInternal(f fs.File, relativePath string) (*dir.Entr
 	})
 	defer writer.Close()
 
-	u.Progress.Started(relativePath, f.Metadata().FileSize)
-	written, err := u.copyWithProgress(relativePath, writer, file, 0, f.Metadata().FileSize)
+	u.progress.Started(relativePath, f.Metadata().FileSize)
+	size, err := u.copyFileContents(relativePath, writer, file, holes, f.Metadata().FileSize)
 	if err != nil {
-		u.Progress.Finished(relativePath, f.Metadata().FileSize, err)
+		u.progress.Finished(relativePath, f.Metadata().FileSize, err)
 		return nil, 0, err
 	}
 
 	e2, err := file.EntryMetadata()
 	if err != nil {
-		u.Progress.Finished(relativePath, f.Metadata().FileSize, err)
+		u.progress.Finished(relativePath, f.Metadata().FileSize, err)
 		return nil, 0, err
 	}
 
 	r, err := writer.Result()
 	if err != nil {
-		u.Progress.Finished(relativePath, f.Metadata().FileSize, err)
+		u.progress.Finished(relativePath, f.Metadata().FileSize, err)
 		return nil, 0, err
 	}
 
 	de := newDirEntry(e2, r)
-	de.FileSize = written
+	de.FileSize = size
 
-	u.Progress.Finished(relativePath, f.Metadata().FileSize, nil)
+	u.progress.Finished(relativePath, f.Metadata().FileSize, nil)
 
-	return de, metadataHash(&de.EntryMetadata), nil
+	hash, err := metadataHash(&de.EntryMetadata)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return de, hash, nil
+}
+
+// uploadFileWithRetries uploads the file and reads it again if its size, modification time or holes have changed since it was listed
+// or since the previous attempt. The file is stored as possibly inconsistent when it keeps changing after all retries,
+// in which case the returned hash is zero, so that it isn't hash-cached.
+func (u *Uploader) uploadFileWithRetries(f fs.File, relativePath string) (*dir.Entry, uint64, error) {
+	before := *f.Metadata()
+
+	for attempt := 0; ; attempt++ {
+		de, hash, err := u.uploadFileInternal(f, relativePath, before.Holes)
+		if err != nil || !fileChanged(&before, &de.EntryMetadata) {
+			return de, hash, err
+		}
+
+		if attempt >= u.ChangedFileRetries {
+			log.Printf("warning: %q has changed while being read, it may be inconsistent", relativePath)
+			// Stored contents are placed around the holes, which were skipped while reading them.
+			de.Holes = before.Holes
+			de.Inconsistent = true
+			return de, 0, nil
+		}
+
+		// The next attempt skips holes found after reading the file, so that data written into former holes isn't lost.
+		log.Printf("%q has changed while being read, reading it again", relativePath)
+		before = de.EntryMetadata
+	}
+}
+
+// fileChanged determines whether the file has been modified, given its metadata before and after reading it.
+func fileChanged(before, after *fs.EntryMetadata) bool {
+	if before.FileSize != after.FileSize || !before.ModTime.Equal(after.ModTime) || len(before.Holes) != len(after.Holes) {
+		return true
+	}
+
+	for i, h := range before.Holes {
+		if h != after.Holes[i] {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (u *Uploader) uploadSymlinkInternal(f fs.Symlink, relativePath string) (*dir.Entry, uint64, error) {
-	u.Progress.Started(relativePath, 1)
+	u.progress.Started(relativePath, 1)
 
 	target, err := f.Readlink()
 	if err != nil {
-		return nil, 0, fmt.Errorf("unable to read symlink: %v", err)
+		u.progress.Finished(relativePath, f.Metadata().FileSize, err)
+		return nil, 0, err
 	}
 
 	writer := u.repo.NewWriter(repo.WriterOptions{
@@ -138,26 +276,37 @@ func (u *Uploader) uploadSymlinkInternal(f fs.Symlink, relativePath string) (*di
 
 	written, err := u.copyWithProgress(relativePath, writer, bytes.NewBufferString(target), 0, f.Metadata().FileSize)
 	if err != nil {
-		u.Progress.Finished(relativePath, f.Metadata().FileSize, err)
+		u.progress.Finished(relativePath, f.Metadata().FileSize, err)
 		return nil, 0, err
 	}
 
 	r, err := writer.Result()
 	if err != nil {
-		u.Progress.Finished(relativePath, f.Metadata().FileSize, err)
+		u.progress.Finished(relativePath, f.Metadata().FileSize, err)
 		return nil, 0, err
 	}
 
 	de := newDirEntry(f.Metadata(), r)
 	de.FileSize = written
-	u.Progress.Finished(relativePath, 1, nil)
-	return de, metadataHash(&de.EntryMetadata), nil
+	u.progress.Finished(relativePath, 1, nil)
+
+	hash, err := metadataHash(&de.EntryMetadata)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return de, hash, nil
+}
+
+var uploadBufPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, 128*1024) // 128 KB buffer
+	},
 }
 
 func (u *Uploader) copyWithProgress(path string, dst io.Writer, src io.Reader, completed int64, length int64) (int64, error) {
-	if u.uploadBuf == nil {
-		u.uploadBuf = make([]byte, 128*1024) // 128 KB buffer
-	}
+	uploadBuf := uploadBufPool.Get().([]byte)
+	defer uploadBufPool.Put(uploadBuf)
 
 	var written int64
 
@@ -166,16 +315,16 @@ func (u *Uploader) copyWithProgress(path string, dst io.Writer, src io.Reader, c
 			return 0, errCancelled
 		}
 
-		readBytes, readErr := src.Read(u.uploadBuf)
+		readBytes, readErr := src.Read(uploadBuf)
 		if readBytes > 0 {
-			wroteBytes, writeErr := dst.Write(u.uploadBuf[0:readBytes])
+			wroteBytes, writeErr := dst.Write(uploadBuf[0:readBytes])
 			if wroteBytes > 0 {
 				written += int64(wroteBytes)
 				completed += int64(wroteBytes)
 				if length < completed {
 					length = completed
 				}
-				u.Progress.Progress(path, completed, length)
+				u.progress.Progress(path, completed, length)
 			}
 			if writeErr != nil {
 				return written, writeErr
@@ -197,6 +346,45 @@ func (u *Uploader) copyWithProgress(path string, dst io.Writer, src io.Reader, c
 	return written, nil
 }
 
+// copyFileContents copies data of the file to dst, skipping its holes, which are neither read (if src can be seeked) nor hashed.
+// It returns the size of the file including holes.
+func (u *Uploader) copyFileContents(path string, dst io.Writer, src io.Reader, holes []fs.Hole, length int64) (int64, error) {
+	var pos int64
+
+	for _, h := range holes {
+		n, err := u.copyWithProgress(path, dst, io.LimitReader(src, h.Offset-pos), pos, length)
+		pos += n
+		if err != nil {
+			return pos, err
+		}
+
+		if pos < h.Offset {
+			// The file has been truncated.
+			return pos, nil
+		}
+
+		if s, ok := src.(io.Seeker); ok {
+			_, err = s.Seek(h.Length, io.SeekCurrent)
+		} else {
+			_, err = io.CopyN(ioutil.Discard, src, h.Length)
+		}
+
+		if err == io.EOF {
+			return pos, nil
+		}
+
+		if err != nil {
+			return pos, err
+		}
+
+		pos += h.Length
+		u.progress.Progress(path, pos, length)
+	}
+
+	n, err := u.copyWithProgress(path, dst, src, pos, length)
+	return pos + n, err
+}
+
 func newDirEntry(md *fs.EntryMetadata, oid repo.ObjectID) *dir.Entry {
 	return &dir.Entry{
 		EntryMetadata: *md,
@@ -204,12 +392,17 @@ func newDirEntry(md *fs.EntryMetadata, oid repo.ObjectID) *dir.Entry {
 	}
 }
 
-// uploadFile uploads the specified File to the repository.
+// uploadFile uploads the specified File to the repository. Manifests don't record metadata of root files,
+// so their holes are stored as zeros.
 func (u *Uploader) uploadFile(file fs.File) (repo.ObjectID, error) {
-	e, _, err := u.uploadFileInternal(file, file.Metadata().Name)
+	e, _, err := u.uploadFileInternal(file, file.Metadata().Name, nil)
 	if err != nil {
 		return repo.NullObjectID, err
 	}
+
+	u.stats.TotalFileCount++
+	u.stats.TotalFileSize += e.FileSize
+	u.stats.NonCachedFiles++
 	return e.ObjectID, nil
 }
 
@@ -230,7 +423,22 @@ func (u *Uploader) uploadDir(dir fs.Directory) (repo.ObjectID, repo.ObjectID, er
 	})
 	defer mw.Close()
 	u.cacheWriter = hashcache.NewWriter(mw)
-	oid, err := uploadDirInternal(u, dir, ".")
+
+	parallelism := u.ParallelUploads
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	items := make(chan *uploadItem, maxPendingUploadItems)
+	workers := make(chan struct{}, parallelism)
+	atomic.StoreInt32(&u.aborted, 0)
+
+	go func() {
+		defer close(items)
+		u.walkDir(dir, ".", nil, items, workers)
+	}()
+
+	oid, err := u.commitItems(items)
 	if u.IsCancelled() {
 		if err := u.cacheReader.CopyTo(u.cacheWriter); err != nil {
 			return repo.NullObjectID, repo.NullObjectID, err
@@ -247,117 +455,503 @@ func (u *Uploader) uploadDir(dir fs.Directory) (repo.ObjectID, repo.ObjectID, er
 	if err := u.repo.FinishPacking(); err != nil {
 		return repo.NullObjectID, repo.NullObjectID, fmt.Errorf("can't finish packing: %v", err)
 	}
+
+	if err := u.repo.Flush(); err != nil {
+		return repo.NullObjectID, repo.NullObjectID, err
+	}
+
+	if err := u.saveLocalHashCache(); err != nil {
+		return repo.NullObjectID, repo.NullObjectID, err
+	}
+
 	return oid, hcid, err
 }
 
-func uploadDirInternal(
-	u *Uploader,
-	directory fs.Directory,
-	relativePath string,
-) (repo.ObjectID, error) {
-	u.Progress.StartedDir(relativePath)
-	defer u.Progress.FinishedDir(relativePath)
+// maxPendingUploadItems limits how far directory traversal can get ahead of committing directory entries.
+const maxPendingUploadItems = 1000
 
+type uploadItemKind int
+
+const (
+	uploadItemEntry uploadItemKind = iota
+	uploadItemDirStart
+	uploadItemDirEnd
+)
+
+// uploadItem is emitted by directory traversal in the order, in which directory entries and hash cache entries
+// must be written. Each directory is emitted twice, before and after its contents.
+type uploadItem struct {
+	kind         uploadItemKind
+	relativePath string
+	metadata     *fs.EntryMetadata
+
+	done chan struct{} // closed when the upload completes, nil if the result is available immediately
+	de   *dir.Entry
+	hash uint64
+	err  error
+
+	cachedObjectID *repo.ObjectID           // object ID of unchanged directory found in hash cache
+	incomplete     bool                     // directory traversal has been interrupted
+	dirInfo        *hashcache.DirectoryInfo // local hash cache of the directory
+	identity       *fs.LocalIdentity        // identity of local file
+	hardLink       *uploadItem              // earlier item of a file, which this item is a hard link to
+}
+
+// dirHashCacheName returns the name of hash cache entry of a directory. The trailing slash makes it
+// sort after all entries within the directory, so it can be looked up after the directory has been traversed.
+func dirHashCacheName(relativePath string) string {
+	return relativePath + "/"
+}
+
+// walkDir traverses the directory depth-first, looking up hash cache entries in order and starting uploads of
+// changed files, which run concurrently up to the capacity of the workers channel.
+// Returns a hash of metadata of all entries within the directory or zero if the directory can't be hash-cached.
+func (u *Uploader) walkDir(directory fs.Directory, relativePath string, ignoreRules *ignore.Matcher, items chan<- *uploadItem, workers chan struct{}) uint64 {
+	md := directory.Metadata()
+
+	var dirInfo *hashcache.DirectoryInfo
+	if le, ok := directory.(fs.LocalEntry); ok && u.LocalHashCache != nil {
+		dirInfo = u.LocalHashCache.OpenDir(le.LocalPath())
+	}
+
+	items <- &uploadItem{kind: uploadItemDirStart, relativePath: relativePath, metadata: md, dirInfo: dirInfo}
+
+	end := &uploadItem{kind: uploadItemDirEnd, relativePath: relativePath, metadata: md, dirInfo: dirInfo}
+	defer func() { items <- end }()
+
+	u.progress.StartedDir(relativePath)
 	u.stats.TotalDirectoryCount++
 
+	end.hash, end.err = u.walkDirEntries(directory, relativePath, ignoreRules, dirInfo, items, workers)
+	end.incomplete = u.IsCancelled() || atomic.LoadInt32(&u.aborted) != 0
+	if end.err != nil || end.hash == 0 {
+		return 0
+	}
+
+	// Unchanged directory can reuse the previous object without writing it again.
+	if cachedEntry := u.maybeIgnoreHashCacheEntry(u.findCachedDir(dirInfo, relativePath, end.hash)); cachedEntry != nil && cachedEntry.Hash == end.hash {
+		oid := cachedEntry.ObjectID
+		end.cachedObjectID = &oid
+	}
+
+	return end.hash
+}
+
+// findCachedEntry looks up the entry in the local hash cache database or in the hash cache of the previous snapshot.
+func (u *Uploader) findCachedEntry(dirInfo *hashcache.DirectoryInfo, entry fs.Entry, relativePath string, hash uint64) *hashcache.Entry {
+	if !u.useLocalHashCache {
+		return u.cacheReader.FindEntry(relativePath)
+	}
+
+	id := localIdentity(entry)
+	if dirInfo == nil || id == nil {
+		return nil
+	}
+
+	if oid, ok := dirInfo.Lookup(entry.Metadata(), *id); ok {
+		return &hashcache.Entry{Name: relativePath, Hash: hash, ObjectID: oid}
+	}
+
+	return nil
+}
+
+// findCachedDir looks up the directory in the local hash cache database or in the hash cache of the previous snapshot.
+func (u *Uploader) findCachedDir(dirInfo *hashcache.DirectoryInfo, relativePath string, hash uint64) *hashcache.Entry {
+	if !u.useLocalHashCache {
+		return u.cacheReader.FindEntry(dirHashCacheName(relativePath))
+	}
+
+	if dirInfo == nil {
+		return nil
+	}
+
+	if oid, ok := dirInfo.LookupDir(hash); ok {
+		return &hashcache.Entry{Name: dirHashCacheName(relativePath), Hash: hash, ObjectID: oid}
+	}
+
+	return nil
+}
+
+func localIdentity(e fs.Entry) *fs.LocalIdentity {
+	if le, ok := e.(fs.LocalEntry); ok {
+		id := le.LocalIdentity()
+		return &id
+	}
+
+	return nil
+}
+
+func (u *Uploader) walkDirEntries(directory fs.Directory, relativePath string, ignoreRules *ignore.Matcher, dirInfo *hashcache.DirectoryInfo, items chan<- *uploadItem, workers chan struct{}) (uint64, error) {
 	entries, err := directory.Readdir()
 	if err != nil {
-		return repo.NullObjectID, err
+		return 0, err
 	}
 
-	writer := u.repo.NewWriter(repo.WriterOptions{
-		Description: "DIR:" + relativePath,
-		PackGroup:   "DIR",
-	})
+	if reason := u.FilesPolicy.ExcludeContentsReason(entries); reason != "" {
+		log.Printf("ignoring contents of %q (%v)", relativePath, reason)
+		for _, entry := range entries {
+			u.stats.ExcludedFileCount++
+			u.stats.ExcludedTotalFileSize += entry.Metadata().FileSize
+		}
+		entries = nil
+	}
 
-	dw := dir.NewWriter(writer)
-	defer writer.Close()
+	ignoreRules, err = ignoreRules.ForDirectory(relativePath, entries)
+	if err != nil {
+		log.Printf("warning: %v", err)
+	}
+
+	dirHash := fnv.New64a()
+	cacheable := true
 
 	for _, entry := range entries {
-		if u.IsCancelled() {
-			break
+		if u.IsCancelled() || atomic.LoadInt32(&u.aborted) != 0 {
+			return 0, nil
 		}
 		e := entry.Metadata()
 		entryRelativePath := relativePath + "/" + e.Name
 
-		if !u.FilesPolicy.ShouldInclude(e) {
-			log.Printf("ignoring %q", entryRelativePath)
+		// Policy is applied first, ignore files can't include entries excluded by the policy.
+		if reason := u.FilesPolicy.ExcludeReason(entryRelativePath, entry, u.root, u.startTime); reason != "" {
+			log.Printf("ignoring %q (%v)", entryRelativePath, reason)
 			u.stats.ExcludedFileCount++
 			u.stats.ExcludedTotalFileSize += e.FileSize
 			continue
 		}
 
-		var de *dir.Entry
-		var hash uint64
+		if ignoreRules.Ignored(entryRelativePath, e.Type == fs.EntryTypeDirectory) {
+			log.Printf("ignoring %q (%v)", entryRelativePath, ignore.FileName)
+			u.stats.ExcludedFileCount++
+			u.stats.ExcludedTotalFileSize += e.FileSize
+			continue
+		}
 
 		// regular file
 		// See if we had this name during previous pass.
-		cachedEntry := u.maybeIgnoreHashCacheEntry(u.cacheReader.FindEntry(entryRelativePath))
+		computedHash, err := metadataHash(e)
+		if err != nil {
+			return 0, err
+		}
+
+		cachedEntry := u.maybeIgnoreHashCacheEntry(u.findCachedEntry(dirInfo, entry, entryRelativePath, computedHash))
 
 		// ... and whether file metadata is identical to the previous one.
-		computedHash := metadataHash(e)
 		cacheMatches := (cachedEntry != nil) && cachedEntry.Hash == computedHash
+		if err := binary.Write(dirHash, binary.LittleEndian, computedHash); err != nil {
+			return 0, err
+		}
 
 		switch entry.(type) {
 		case fs.File:
 			u.stats.TotalFileCount++
 			u.stats.TotalFileSize += e.FileSize
+
+			// Files linked to a file seen earlier are stored with the same object, without reading them again.
+			if first := u.hardLinks[e.HardLinkID]; first != nil {
+				u.stats.HardLinkedFiles++
+				items <- &uploadItem{
+					relativePath: entryRelativePath,
+					metadata:     e,
+					identity:     localIdentity(entry),
+					hardLink:     first,
+				}
+				cacheable = cacheable && e.ModTime.Before(u.hashCacheCutoff)
+				continue
+			}
 		}
 
 		if cacheMatches {
 			u.stats.CachedFiles++
-			u.Progress.Cached(entryRelativePath, entry.Metadata().FileSize)
+			u.progress.Cached(entryRelativePath, e.FileSize)
 			// Avoid hashing by reusing previous object ID.
-			de, hash, err = newDirEntry(e, cachedEntry.ObjectID), cachedEntry.Hash, nil
-		} else {
-			switch entry := entry.(type) {
-			case fs.Directory:
-				var oid repo.ObjectID
-				oid, err = uploadDirInternal(u, entry, entryRelativePath)
-				de = newDirEntry(e, oid)
-				hash = 0
-
-			case fs.Symlink:
-				de, hash, err = u.uploadSymlinkInternal(entry, entryRelativePath)
-
-			case fs.File:
-				u.stats.NonCachedFiles++
-				de, hash, err = u.uploadFileInternal(entry, entryRelativePath)
-
-			default:
-				return repo.NullObjectID, fmt.Errorf("file type %v not supported", entry.Metadata().Type)
+			it := &uploadItem{
+				relativePath: entryRelativePath,
+				metadata:     e,
+				de:           newDirEntry(e, cachedEntry.ObjectID),
+				hash:         cachedEntry.Hash,
+				identity:     localIdentity(entry),
+			}
+			u.rememberHardLink(it)
+			items <- it
+			continue
+		}
+
+		switch entry := entry.(type) {
+		case fs.Directory:
+			subdirHash := u.walkDir(entry, entryRelativePath, ignoreRules, items, workers)
+			if err := binary.Write(dirHash, binary.LittleEndian, subdirHash); err != nil {
+				return 0, err
+			}
+
+			cacheable = cacheable && subdirHash != 0
+
+		case fs.Symlink:
+			items <- u.startUpload(entryRelativePath, e, localIdentity(entry), workers, func() (*dir.Entry, uint64, error) {
+				return u.uploadSymlinkInternal(entry, entryRelativePath)
+			})
+			cacheable = cacheable && e.ModTime.Before(u.hashCacheCutoff)
+
+		case fs.File:
+			u.stats.NonCachedFiles++
+			it := u.startUpload(entryRelativePath, e, localIdentity(entry), workers, func() (*dir.Entry, uint64, error) {
+				return u.uploadFileWithRetries(entry, entryRelativePath)
+			})
+			u.rememberHardLink(it)
+			items <- it
+			cacheable = cacheable && e.ModTime.Before(u.hashCacheCutoff)
+
+		default:
+			if !e.Type.IsSpecial() {
+				return 0, fmt.Errorf("file type %v not supported", e.Type)
+			}
+
+			// Devices, named pipes and sockets have no contents, only their metadata is stored.
+			items <- &uploadItem{
+				relativePath: entryRelativePath,
+				metadata:     e,
+				de:           newDirEntry(e, repo.NullObjectID),
 			}
 		}
+	}
 
-		if err == errCancelled {
-			break
+	if !cacheable {
+		return 0, nil
+	}
+
+	return dirHash.Sum64(), nil
+}
+
+// rememberHardLink remembers the item of a file with multiple links, so that other links to it are not read again.
+func (u *Uploader) rememberHardLink(it *uploadItem) {
+	if id := it.metadata.HardLinkID; id != "" {
+		u.hardLinks[id] = it
+	}
+}
+
+// resolveHardLink completes the item of a hard link using the result of the earlier item it links to,
+// which has already been committed.
+func resolveHardLink(it *uploadItem) {
+	first := it.hardLink
+	if first.err != nil {
+		it.err = first.err
+		return
+	}
+
+	it.de = newDirEntry(it.metadata, first.de.ObjectID)
+	it.de.FileSize = first.de.FileSize
+	it.de.Holes = first.de.Holes
+	it.de.Inconsistent = first.de.Inconsistent
+	if first.hash != 0 {
+		it.hash, it.err = metadataHash(&it.de.EntryMetadata)
+	}
+}
+
+// startUpload runs the upload in a new goroutine as soon as a worker is available.
+func (u *Uploader) startUpload(relativePath string, md *fs.EntryMetadata, id *fs.LocalIdentity, workers chan struct{}, upload func() (*dir.Entry, uint64, error)) *uploadItem {
+	it := &uploadItem{
+		relativePath: relativePath,
+		metadata:     md,
+		done:         make(chan struct{}),
+		identity:     id,
+	}
+
+	workers <- struct{}{}
+	go func() {
+		defer func() {
+			<-workers
+			close(it.done)
+		}()
+
+		it.de, it.hash, it.err = upload()
+	}()
+
+	return it
+}
+
+// pendingDir is a directory whose entries are being committed.
+type pendingDir struct {
+	relativePath string
+	metadata     *fs.EntryMetadata
+	entries      []*dir.Entry
+	incomplete   bool // some entries have been skipped because of errors or cancellation
+	uncacheable  bool // some entries, possibly in subdirectories, are incomplete or inconsistent
+	dirInfo      *hashcache.DirectoryInfo
+}
+
+// commitItems writes directory objects and hash cache entries in the order, in which items are emitted by walkDir
+// and returns the object ID of the top-level directory. After a failure, remaining items are drained and discarded.
+func (u *Uploader) commitItems(items <-chan *uploadItem) (repo.ObjectID, error) {
+	var stack []*pendingDir
+	var rootOID repo.ObjectID
+	var failure error
+
+	for it := range items {
+		if it.done != nil {
+			<-it.done
 		}
 
-		if err != nil {
-			if u.IgnoreFileErrors {
-				u.stats.ReadErrors++
-				log.Printf("warning: unable to hash file %q: %s, ignoring", entryRelativePath, err)
+		if failure != nil {
+			continue
+		}
+
+		u.maybeCheckpoint(stack)
+
+		switch it.kind {
+		case uploadItemDirStart:
+			stack = append(stack, &pendingDir{relativePath: it.relativePath, metadata: it.metadata, dirInfo: it.dirInfo})
+			continue
+
+		case uploadItemDirEnd:
+			d := stack[len(stack)-1]
+			stack = stack[0 : len(stack)-1]
+			it.de, it.err = u.finishDir(d, it)
+			u.progress.FinishedDir(d.relativePath)
+
+			// Directories containing incomplete or inconsistent entries must not be reused by later snapshots,
+			// so that these entries are read again.
+			if len(stack) > 0 && (d.incomplete || d.uncacheable || it.incomplete) {
+				stack[len(stack)-1].uncacheable = true
+			}
+
+			if len(stack) == 0 {
+				if it.err != nil {
+					failure = it.err
+				} else {
+					rootOID = it.de.ObjectID
+				}
 				continue
 			}
-			return repo.NullObjectID, fmt.Errorf("unable to hash file: %s", err)
 		}
 
-		if err := dw.WriteEntry(de); err != nil {
-			return repo.NullObjectID, err
+		if err := u.commitEntry(stack[len(stack)-1], it); err != nil {
+			failure = err
+			atomic.StoreInt32(&u.aborted, 1)
 		}
+	}
 
-		if de.Type != fs.EntryTypeDirectory && hash != 0 && entry.Metadata().ModTime.Before(u.hashCacheCutoff) {
-			if err := u.cacheWriter.WriteEntry(hashcache.Entry{
-				Name:     entryRelativePath,
-				Hash:     hash,
-				ObjectID: de.ObjectID,
-			}); err != nil {
-				return repo.NullObjectID, err
-			}
+	return rootOID, failure
+}
+
+func (u *Uploader) commitEntry(d *pendingDir, it *uploadItem) error {
+	if it.hardLink != nil {
+		resolveHardLink(it)
+	}
+
+	if it.err == errCancelled {
+		d.incomplete = true
+		return nil
+	}
+
+	if it.err != nil {
+		category := ErrorCategory(it.err)
+		if !u.IgnoreFileErrors || u.ErrorHandlingPolicy.FailsSnapshot(category) {
+			return fmt.Errorf("unable to read %q (%v): %s", it.relativePath, category, it.err)
+		}
+
+		u.stats.ReadErrors++
+		if len(u.entryErrors) < MaxRecordedEntryErrors {
+			u.entryErrors = append(u.entryErrors, EntryError{Path: it.relativePath, Category: category, Error: it.err.Error()})
+		}
+		d.incomplete = true
+		log.Printf("warning: unable to read %q (%v): %s, ignoring", it.relativePath, category, it.err)
+		return nil
+	}
+
+	d.entries = append(d.entries, it.de)
+
+	if it.de.Inconsistent {
+		u.stats.InconsistentFiles++
+		d.uncacheable = true
+	}
+
+	if it.de.Type != fs.EntryTypeDirectory && it.hash != 0 && it.metadata.ModTime.Before(u.hashCacheCutoff) {
+		if d.dirInfo != nil && it.identity != nil {
+			d.dirInfo.Set(it.metadata, *it.identity, it.de.ObjectID)
 		}
+
+		return u.writeHashCacheEntry(hashcache.Entry{
+			Name:     it.relativePath,
+			Hash:     it.hash,
+			ObjectID: it.de.ObjectID,
+		})
 	}
 
-	dw.Finalize()
+	return nil
+}
+
+// finishDir returns the entry of the directory, reusing the previous directory object if it hasn't changed
+// and writing a new one otherwise.
+func (u *Uploader) finishDir(d *pendingDir, it *uploadItem) (*dir.Entry, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+
+	incomplete := d.incomplete || it.incomplete
+	reusable := !incomplete && !d.uncacheable
+
+	var oid repo.ObjectID
+	if it.cachedObjectID != nil && reusable {
+		u.stats.CachedDirectories++
+		oid = *it.cachedObjectID
+	} else {
+		var err error
+		if oid, err = u.writeDir(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if it.hash != 0 && reusable {
+		if d.dirInfo != nil {
+			d.dirInfo.SetDir(it.hash, oid)
+		}
+
+		if err := u.writeHashCacheEntry(hashcache.Entry{
+			Name:     dirHashCacheName(d.relativePath),
+			Hash:     it.hash,
+			ObjectID: oid,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	// Entries of incomplete directories would be lost, keep the previous ones instead.
+	if d.dirInfo != nil && !incomplete {
+		u.pendingDirInfos = append(u.pendingDirInfos, d.dirInfo)
+	}
+
+	return newDirEntry(d.metadata, oid), nil
+}
+
+// saveLocalHashCache saves the local hash cache of directories committed so far. It must be called only after their objects
+// have been stored durably, otherwise later uploads could reuse objects, which never made it to the repository.
+func (u *Uploader) saveLocalHashCache() error {
+	for _, di := range u.pendingDirInfos {
+		if err := di.Save(); err != nil {
+			return err
+		}
+	}
+
+	u.pendingDirInfos = nil
+	return nil
+}
+
+func (u *Uploader) writeDir(d *pendingDir) (repo.ObjectID, error) {
+	writer := u.repo.NewWriter(repo.WriterOptions{
+		Description: "DIR:" + d.relativePath,
+		PackGroup:   "DIR",
+	})
+	defer writer.Close()
+
+	dw := dir.NewWriter(writer)
+	for _, de := range d.entries {
+		if err := dw.WriteEntry(de); err != nil {
+			return repo.NullObjectID, err
+		}
+	}
+
+	if err := dw.Finalize(); err != nil {
+		return repo.NullObjectID, err
+	}
 
 	return writer.Result()
 }
@@ -373,10 +967,11 @@ func (u *Uploader) maybeIgnoreHashCacheEntry(e *hashcache.Entry) *hashcache.Entr
 // NewUploader creates new Uploader object for a given repository.
 func NewUploader(r *repo.Repository) *Uploader {
 	return &Uploader{
-		repo:             r,
-		Progress:         &nullUploadProgress{},
-		HashCacheMinAge:  1 * time.Hour,
-		IgnoreFileErrors: true,
+		repo:               r,
+		Progress:           &nullUploadProgress{},
+		HashCacheMinAge:    1 * time.Hour,
+		IgnoreFileErrors:   true,
+		ChangedFileRetries: 3,
 	}
 }
 
@@ -398,23 +993,43 @@ func (u *Uploader) Upload(
 
 	u.cacheReader = hashcache.Open(nil)
 	u.stats = Stats{}
-	if old != nil {
+	u.entryErrors = nil
+	u.hardLinks = map[string]*uploadItem{}
+	u.pendingDirInfos = nil
+	u.progress = &lockedUploadProgress{p: u.Progress}
+
+	// The local hash cache is used only if it contains the uploaded directory, otherwise it gets populated
+	// while using the hash cache of the previous snapshot.
+	u.useLocalHashCache = false
+	if le, ok := source.(fs.LocalEntry); ok && u.LocalHashCache != nil {
+		u.useLocalHashCache = u.LocalHashCache.OpenDir(le.LocalPath()).Found()
+	}
+
+	u.oldHashCacheID = nil
+	if old != nil && !u.useLocalHashCache {
 		if r, err := u.repo.Open(old.HashCacheID); err == nil {
 			u.cacheReader = hashcache.Open(r)
+			u.oldHashCacheID = &old.HashCacheID
 		}
 	}
 
 	var err error
 
 	s.StartTime = time.Now()
+	u.root = source
+	u.sourceInfo = sourceInfo
+	u.startTime = s.StartTime
+	u.checkpoint = uploadCheckpoint{last: s.StartTime}
 	u.hashCacheCutoff = time.Now().Add(-u.HashCacheMinAge)
 	s.HashCacheCutoffTime = u.hashCacheCutoff
 
 	switch entry := source.(type) {
 	case fs.Directory:
+		s.RootType = fs.EntryTypeDirectory
 		s.RootObjectID, s.HashCacheID, err = u.uploadDir(entry)
 
 	case fs.File:
+		s.RootType = fs.EntryTypeFile
 		s.RootObjectID, err = u.uploadFile(entry)
 
 	default:
@@ -427,6 +1042,7 @@ func (u *Uploader) Upload(
 	s.IncompleteReason = u.cancelReason()
 	s.EndTime = time.Now()
 	s.Stats = u.stats
+	s.EntryErrors = u.entryErrors
 	s.Stats.Repository = u.repo.Status().Stats
 
 	return s, nil
@@ -0,0 +1,162 @@
+package snapshot
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/internal/dir"
+	"github.com/kopia/kopia/internal/hashcache"
+	"github.com/kopia/kopia/repo"
+)
+
+// IncompleteReasonCheckpoint is the incomplete reason of manifests saved periodically while the upload is in progress.
+// Uploads, which use such manifest as the previous snapshot, resume where the checkpoint left off.
+const IncompleteReasonCheckpoint = "checkpoint"
+
+// uploadCheckpoint tracks the state of periodic checkpoints of the upload in progress.
+type uploadCheckpoint struct {
+	last time.Time // time of the last checkpoint or start of the upload
+
+	hashCacheID *repo.ObjectID    // hash cache of the last checkpoint, nil before the first checkpoint
+	lastName    string            // name of the last hash cache entry written by the upload before the last checkpoint
+	entries     []hashcache.Entry // hash cache entries written since the last checkpoint
+}
+
+// writeHashCacheEntry writes the entry to the hash cache of the upload and remembers it for the next checkpoint.
+func (u *Uploader) writeHashCacheEntry(e hashcache.Entry) error {
+	if u.CheckpointInterval > 0 {
+		u.checkpoint.entries = append(u.checkpoint.entries, e)
+	}
+
+	return u.cacheWriter.WriteEntry(e)
+}
+
+// maybeCheckpoint saves a checkpoint of directories being committed if the checkpoint interval has elapsed.
+// Failures are logged, but don't abort the upload.
+func (u *Uploader) maybeCheckpoint(stack []*pendingDir) {
+	if u.CheckpointInterval <= 0 || u.CheckpointFunc == nil || len(stack) == 0 || time.Since(u.checkpoint.last) < u.CheckpointInterval {
+		return
+	}
+
+	u.checkpoint.last = time.Now()
+	if err := u.writeCheckpoint(stack); err != nil {
+		log.Printf("warning: unable to save checkpoint: %v", err)
+	}
+}
+
+// writeCheckpoint writes a partial directory tree consisting of entries committed so far and its hash cache,
+// and passes an incomplete manifest referencing them to CheckpointFunc.
+func (u *Uploader) writeCheckpoint(stack []*pendingDir) error {
+	// Directories being committed are written bottom-up, each including the partial directory below it,
+	// whose name sorts after all entries committed so far.
+	var child *dir.Entry
+	for i := len(stack) - 1; i >= 0; i-- {
+		d := *stack[i]
+		if child != nil {
+			d.entries = append(append([]*dir.Entry(nil), d.entries...), child)
+		}
+
+		oid, err := u.writeDir(&d)
+		if err != nil {
+			return fmt.Errorf("unable to write directory %v: %v", d.relativePath, err)
+		}
+
+		child = newDirEntry(d.metadata, oid)
+	}
+
+	hcid, err := u.writeCheckpointHashCache()
+	if err != nil {
+		return fmt.Errorf("unable to write hash cache: %v", err)
+	}
+
+	// Objects must be stored durably before the manifest referencing them is saved.
+	if err := u.repo.CheckpointPacking(); err != nil {
+		return err
+	}
+
+	if err := u.repo.Flush(); err != nil {
+		return err
+	}
+
+	if err := u.saveLocalHashCache(); err != nil {
+		return err
+	}
+
+	return u.CheckpointFunc(&Manifest{
+		Source:              *u.sourceInfo,
+		StartTime:           u.startTime,
+		EndTime:             time.Now(),
+		RootObjectID:        child.ObjectID,
+		RootType:            fs.EntryTypeDirectory,
+		HashCacheID:         hcid,
+		HashCacheCutoffTime: u.hashCacheCutoff,
+		IncompleteReason:    IncompleteReasonCheckpoint,
+	})
+}
+
+// writeCheckpointHashCache writes the hash cache of the checkpoint, which consists of entries written by the upload so far,
+// followed by entries of the previous snapshot, which haven't been reached yet.
+func (u *Uploader) writeCheckpointHashCache() (repo.ObjectID, error) {
+	mw := u.repo.NewWriter(repo.WriterOptions{
+		Description:     "HASHCACHE:checkpoint",
+		BlockNamePrefix: "H",
+		PackGroup:       "HC",
+	})
+	defer mw.Close()
+	w := hashcache.NewWriter(mw)
+
+	cp := &u.checkpoint
+	if cp.hashCacheID != nil {
+		if err := u.copyHashCache(*cp.hashCacheID, func(r hashcache.Reader) error {
+			return r.CopyUntil(w, cp.lastName)
+		}); err != nil {
+			return repo.NullObjectID, err
+		}
+	}
+
+	lastName := cp.lastName
+	for _, e := range cp.entries {
+		if err := w.WriteEntry(e); err != nil {
+			return repo.NullObjectID, err
+		}
+		lastName = e.Name
+	}
+
+	if u.oldHashCacheID != nil {
+		if err := u.copyHashCache(*u.oldHashCacheID, func(r hashcache.Reader) error {
+			if lastName != "" {
+				r.FindEntry(lastName)
+			}
+			return r.CopyTo(w)
+		}); err != nil {
+			return repo.NullObjectID, err
+		}
+	}
+
+	if err := w.Finalize(); err != nil {
+		return repo.NullObjectID, err
+	}
+
+	hcid, err := mw.Result()
+	if err != nil {
+		return repo.NullObjectID, err
+	}
+
+	cp.hashCacheID = &hcid
+	cp.lastName = lastName
+	cp.entries = nil
+	return hcid, nil
+}
+
+// copyHashCache opens the hash cache object and passes its reader to the copy function.
+func (u *Uploader) copyHashCache(oid repo.ObjectID, copy func(r hashcache.Reader) error) error {
+	r, err := u.repo.Open(oid)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return copy(hashcache.Open(r))
+}
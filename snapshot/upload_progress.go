@@ -1,6 +1,9 @@
 package snapshot
 
+import "sync"
+
 // UploadProgress is invoked by by uploader to report status of file and directory uploads.
+// Calls are serialized, but Started/Progress/Finished of concurrently uploaded files may interleave.
 type UploadProgress interface {
 	Cached(path string, length int64)
 
@@ -32,3 +35,45 @@ func (p *nullUploadProgress) StartedDir(path string) {
 
 func (p *nullUploadProgress) FinishedDir(path string) {
 }
+
+// lockedUploadProgress serializes calls to UploadProgress made by concurrent uploads.
+type lockedUploadProgress struct {
+	mu sync.Mutex
+	p  UploadProgress
+}
+
+func (p *lockedUploadProgress) Cached(path string, length int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.p.Cached(path, length)
+}
+
+func (p *lockedUploadProgress) Started(path string, length int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.p.Started(path, length)
+}
+
+func (p *lockedUploadProgress) Progress(path string, completed int64, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.p.Progress(path, completed, total)
+}
+
+func (p *lockedUploadProgress) Finished(path string, length int64, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.p.Finished(path, length, err)
+}
+
+func (p *lockedUploadProgress) StartedDir(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.p.StartedDir(path)
+}
+
+func (p *lockedUploadProgress) FinishedDir(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.p.FinishedDir(path)
+}
@@ -1,15 +1,25 @@
 package snapshot
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/kopia/kopia/blob"
 	"github.com/kopia/kopia/blob/filesystem"
+	"github.com/kopia/kopia/fs"
+	"github.com/kopia/kopia/fs/localfs"
+	"github.com/kopia/kopia/fs/tarfs"
+	"github.com/kopia/kopia/fs/virtualfs"
+	"github.com/kopia/kopia/internal/dir"
+	"github.com/kopia/kopia/internal/hashcache"
 	"github.com/kopia/kopia/internal/mockfs"
 	"github.com/kopia/kopia/repo"
 
@@ -33,7 +43,7 @@ func (th *uploadTestHarness) cleanup() {
 	os.RemoveAll(th.repoDir)
 }
 
-func newUploadTestHarness() *uploadTestHarness {
+func newUploadTestHarness(mods ...func(o *repo.NewRepositoryOptions)) *uploadTestHarness {
 	ctx := context.Background()
 	repoDir, err := ioutil.TempDir("", "kopia-repo")
 	if err != nil {
@@ -53,7 +63,12 @@ func newUploadTestHarness() *uploadTestHarness {
 		panic("unable to create credentials: " + err.Error())
 	}
 
-	if err := repo.Initialize(storage, &repo.NewRepositoryOptions{}, creds); err != nil {
+	opt := &repo.NewRepositoryOptions{}
+	for _, m := range mods {
+		m(opt)
+	}
+
+	if err := repo.Initialize(ctx, storage, opt, creds); err != nil {
 		panic("unable to create repository: " + err.Error())
 	}
 
@@ -188,6 +203,583 @@ func TestUpload(t *testing.T) {
 	}
 }
 
+func TestUpload_RootType(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	u := NewUploader(th.repo)
+	s1, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s1.RootType != fs.EntryTypeDirectory || s1.RootEntryType() != fs.EntryTypeDirectory {
+		t.Errorf("unexpected root type of directory snapshot: %q", s1.RootType)
+	}
+
+	f := th.sourceDir.AddFile("f4", []byte{1, 2, 3, 4, 5, 6}, 0777)
+	s2, err := u.Upload(f, &SourceInfo{Path: "/f4"}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s2.RootType != fs.EntryTypeFile || s2.RootEntryType() != fs.EntryTypeFile {
+		t.Errorf("unexpected root type of file snapshot: %q", s2.RootType)
+	}
+
+	// Manifests written before root types were recorded have file roots only if they hold data streamed from standard input.
+	legacy := []struct {
+		source   SourceInfo
+		expected fs.EntryType
+	}{
+		{SourceInfo{Path: "/f4"}, fs.EntryTypeDirectory},
+		{StdinSourceInfo("dump.sql", "host", "user"), fs.EntryTypeFile},
+	}
+
+	for _, tc := range legacy {
+		if actual := (&Manifest{Source: tc.source}).RootEntryType(); actual != tc.expected {
+			t.Errorf("unexpected root type of legacy snapshot of %v: %q, expected %q", tc.source, actual, tc.expected)
+		}
+	}
+}
+
+func TestUpload_Parallel(t *testing.T) {
+	// Small packs are finished while other blocks are being added and looked up, the hash cache of the previous
+	// snapshot is read in many blocks during the upload.
+	th := newUploadTestHarness(func(o *repo.NewRepositoryOptions) {
+		o.Splitter = "FIXED"
+		o.MaxBlockSize = 1000
+		o.MaxPackedContentLength = 100
+		o.MaxPackFileLength = 1000
+	})
+	defer th.cleanup()
+
+	for i := 0; i < 200; i++ {
+		th.sourceDir.AddFile(fmt.Sprintf("d1/d2/p%v", i), []byte(fmt.Sprintf("parallel-%v", i)), 0777)
+		th.sourceDir.AddFile(fmt.Sprintf("d2/p%v", i), []byte(fmt.Sprintf("parallel-%v", i*i)), 0777)
+	}
+
+	u := NewUploader(th.repo)
+	s1, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	u.ParallelUploads = 8
+	s2, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if !objectIDsEqual(s2.RootObjectID, s1.RootObjectID) {
+		t.Errorf("expected s1.RootObjectID==s2.RootObjectID, got %v and %v", s1.RootObjectID.String(), s2.RootObjectID.String())
+	}
+
+	if !objectIDsEqual(s2.HashCacheID, s1.HashCacheID) {
+		t.Errorf("expected s2.HashCacheID==s1.HashCacheID, got %v and %v", s2.HashCacheID.String(), s1.HashCacheID.String())
+	}
+
+	s3, err := u.Upload(th.sourceDir, &SourceInfo{}, s2)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s3.Stats.CachedFiles != s1.Stats.NonCachedFiles || s3.Stats.NonCachedFiles != 0 {
+		t.Errorf("unexpected s3 stats: %+v, vs s1: %+v", s3.Stats, s1.Stats)
+	}
+
+	if !objectIDsEqual(s3.RootObjectID, s1.RootObjectID) {
+		t.Errorf("expected s1.RootObjectID==s3.RootObjectID, got %v and %v", s1.RootObjectID.String(), s3.RootObjectID.String())
+	}
+
+	// Changed files are uploaded by workers while the hash cache of the previous snapshot is being read.
+	for i := 0; i < 200; i += 2 {
+		th.sourceDir.Subdir("d1", "d2").Remove(fmt.Sprintf("p%v", i))
+		th.sourceDir.AddFile(fmt.Sprintf("d1/d2/p%v", i), []byte(fmt.Sprintf("changed-%v", i)), 0777)
+		th.sourceDir.Subdir("d2").Remove(fmt.Sprintf("p%v", i))
+		th.sourceDir.AddFile(fmt.Sprintf("d2/p%v", i), []byte(fmt.Sprintf("changed-%v", i*i)), 0777)
+	}
+
+	s4, err := u.Upload(th.sourceDir, &SourceInfo{}, s3)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s4.Stats.NonCachedFiles != 200 || s4.Stats.CachedFiles != s1.Stats.NonCachedFiles-200 {
+		t.Errorf("unexpected s4 stats: %+v, vs s1: %+v", s4.Stats, s1.Stats)
+	}
+
+	u.ParallelUploads = 1
+	s5, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if !objectIDsEqual(s4.RootObjectID, s5.RootObjectID) {
+		t.Errorf("expected s4.RootObjectID==s5.RootObjectID, got %v and %v", s4.RootObjectID.String(), s5.RootObjectID.String())
+	}
+}
+
+func TestUpload_UnchangedDirectories(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	u := NewUploader(th.repo)
+	s1, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s1.Stats.CachedDirectories != 0 {
+		t.Errorf("unexpected s1 stats: %+v", s1.Stats)
+	}
+
+	// Nothing has changed, all directories are reused.
+	s2, err := u.Upload(th.sourceDir, &SourceInfo{}, s1)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s2.Stats.CachedDirectories != s2.Stats.TotalDirectoryCount {
+		t.Errorf("unexpected s2 stats: %+v", s2.Stats)
+	}
+
+	if !objectIDsEqual(s2.RootObjectID, s1.RootObjectID) {
+		t.Errorf("expected s1.RootObjectID==s2.RootObjectID, got %v and %v", s1.RootObjectID.String(), s2.RootObjectID.String())
+	}
+
+	// Adding a file changes "./d2/d1", "./d2" and "./", other directories are reused.
+	th.sourceDir.AddFile("d2/d1/f4", []byte{1, 2, 3, 4, 5}, 0777)
+	s3, err := u.Upload(th.sourceDir, &SourceInfo{}, s2)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s3.Stats.CachedDirectories != s3.Stats.TotalDirectoryCount-3 {
+		t.Errorf("unexpected s3 stats: %+v", s3.Stats)
+	}
+
+	if objectIDsEqual(s3.RootObjectID, s1.RootObjectID) {
+		t.Errorf("expected s3.RootObjectID!=s1.RootObjectID, got %v", s3.RootObjectID.String())
+	}
+
+	// Excluding a file changes the directory even though nothing changed on disk.
+	u.FilesPolicy.Exclude = []string{"f4"}
+	s4, err := u.Upload(th.sourceDir, &SourceInfo{}, s3)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if !objectIDsEqual(s4.RootObjectID, s1.RootObjectID) {
+		t.Errorf("expected s4.RootObjectID==s1.RootObjectID, got %v and %v", s4.RootObjectID.String(), s1.RootObjectID.String())
+	}
+
+	// Renaming a file changes "./d1/d1", "./d1" and "./" even though its contents and other metadata are the same.
+	th.sourceDir.Subdir("d1", "d1").Remove("f2")
+	th.sourceDir.AddFile("d1/d1/f3", []byte{1, 2, 3, 4}, 0777)
+	s5, err := u.Upload(th.sourceDir, &SourceInfo{}, s4)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s5.Stats.CachedDirectories != s5.Stats.TotalDirectoryCount-3 {
+		t.Errorf("unexpected s5 stats: %+v", s5.Stats)
+	}
+
+	var names []string
+	for _, e := range readDirEntries(t, th.repo, s5.RootObjectID, "d1", "d1") {
+		names = append(names, e.Name)
+	}
+
+	if want := []string{"f1", "f3"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("unexpected entries of d1/d1: %v, want %v", names, want)
+	}
+}
+
+func TestUpload_LocalHashCache(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	sourceDir, err := ioutil.TempDir("", "kopia-source")
+	if err != nil {
+		t.Fatalf("cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(sourceDir)
+
+	os.MkdirAll(filepath.Join(sourceDir, "d1"), 0700)
+	for _, f := range []string{"f1", "f2", "d1/f1"} {
+		if err := ioutil.WriteFile(filepath.Join(sourceDir, f), []byte(f), 0600); err != nil {
+			t.Fatalf("cannot write file: %v", err)
+		}
+	}
+
+	db, err := hashcache.NewHashCacheDB(filepath.Join(th.repoDir, "hashcache.db"))
+	if err != nil {
+		t.Fatalf("cannot open hash cache database: %v", err)
+	}
+	defer db.Close()
+
+	source, err := localfs.Directory(sourceDir, nil)
+	if err != nil {
+		t.Fatalf("cannot open source directory: %v", err)
+	}
+
+	u := NewUploader(th.repo)
+	u.HashCacheMinAge = 0
+	u.LocalHashCache = db
+
+	s1, err := u.Upload(source, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s1.Stats.CachedFiles != 0 || s1.Stats.CachedDirectories != 0 {
+		t.Errorf("unexpected s1 stats: %+v", s1.Stats)
+	}
+
+	// The previous snapshot is not provided, all entries come from the local hash cache.
+	s2, err := u.Upload(source, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s2.Stats.CachedDirectories != s2.Stats.TotalDirectoryCount {
+		t.Errorf("unexpected s2 stats: %+v", s2.Stats)
+	}
+
+	if !objectIDsEqual(s2.RootObjectID, s1.RootObjectID) {
+		t.Errorf("expected s1.RootObjectID==s2.RootObjectID, got %v and %v", s1.RootObjectID.String(), s2.RootObjectID.String())
+	}
+
+	// Modified file is uploaded again, other files are found in the local hash cache.
+	if err := ioutil.WriteFile(filepath.Join(sourceDir, "d1", "f1"), []byte("changed"), 0600); err != nil {
+		t.Fatalf("cannot write file: %v", err)
+	}
+
+	s3, err := u.Upload(source, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s3.Stats.CachedFiles != 2 || s3.Stats.NonCachedFiles != 1 || s3.Stats.CachedDirectories != 0 {
+		t.Errorf("unexpected s3 stats: %+v", s3.Stats)
+	}
+
+	if objectIDsEqual(s3.RootObjectID, s1.RootObjectID) {
+		t.Errorf("expected s3.RootObjectID!=s1.RootObjectID, got %v", s3.RootObjectID.String())
+	}
+
+	// Objects of a failed upload are never stored, directories committed before the failure are not saved.
+	if err := ioutil.WriteFile(filepath.Join(sourceDir, "d1", "f1"), []byte("changed again"), 0600); err != nil {
+		t.Fatalf("cannot write file: %v", err)
+	}
+
+	u.IgnoreFileErrors = false
+	if _, err := u.Upload(&failingDirectory{source.(localDirectory), "f2"}, &SourceInfo{}, nil); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	s4, err := u.Upload(source, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s4.Stats.CachedFiles != 2 || s4.Stats.NonCachedFiles != 1 || s4.Stats.CachedDirectories != 0 {
+		t.Errorf("unexpected s4 stats: %+v", s4.Stats)
+	}
+
+	readDirEntries(t, th.repo, s4.RootObjectID, "d1")
+}
+
+type localDirectory interface {
+	fs.Directory
+	fs.LocalEntry
+}
+
+// failingDirectory is a local directory, in which the file or symlink with the specified name can't be read.
+type failingDirectory struct {
+	localDirectory
+	failing string
+}
+
+func (d *failingDirectory) Readdir() (fs.Entries, error) {
+	entries, err := d.localDirectory.Readdir()
+	for i, e := range entries {
+		if e.Metadata().Name != d.failing {
+			continue
+		}
+
+		switch e := e.(type) {
+		case fs.File:
+			entries[i] = &failingFile{e}
+		case fs.Symlink:
+			entries[i] = &failingSymlink{e}
+		}
+	}
+
+	return entries, err
+}
+
+type failingFile struct {
+	fs.File
+}
+
+func (f *failingFile) Open() (fs.Reader, error) {
+	return nil, errTest
+}
+
+type failingSymlink struct {
+	fs.Symlink
+}
+
+func (s *failingSymlink) Readlink() (string, error) {
+	return "", errTest
+}
+
+// countingUploadProgress counts files, which have been started and finished.
+type countingUploadProgress struct {
+	nullUploadProgress
+
+	mu       sync.Mutex
+	started  int
+	finished int
+}
+
+func (p *countingUploadProgress) Started(path string, length int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.started++
+}
+
+func (p *countingUploadProgress) Finished(path string, length int64, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished++
+}
+
+func TestUpload_ProgressOfFailedEntries(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	sourceDir, err := ioutil.TempDir("", "kopia-source")
+	if err != nil {
+		t.Fatalf("cannot create temp directory: %v", err)
+	}
+	defer os.RemoveAll(sourceDir)
+
+	ioutil.WriteFile(filepath.Join(sourceDir, "f1"), []byte("f1"), 0600)
+	ioutil.WriteFile(filepath.Join(sourceDir, "f2"), []byte("f2"), 0600)
+	os.Symlink("f1", filepath.Join(sourceDir, "l1"))
+
+	source, err := localfs.Directory(sourceDir, nil)
+	if err != nil {
+		t.Fatalf("cannot open source directory: %v", err)
+	}
+
+	// Every started entry is finished, even if it can't be read.
+	for _, failing := range []string{"f2", "l1"} {
+		p := &countingUploadProgress{}
+		u := NewUploader(th.repo)
+		u.IgnoreFileErrors = true
+		u.Progress = p
+
+		s, err := u.Upload(&failingDirectory{source.(localDirectory), failing}, &SourceInfo{}, nil)
+		if err != nil {
+			t.Fatalf("Upload error: %v", err)
+		}
+
+		if s.Stats.ReadErrors != 1 || p.started == 0 || p.started != p.finished {
+			t.Errorf("unexpected progress when %v fails: %v started, %v finished, stats %+v", failing, p.started, p.finished, s.Stats)
+		}
+	}
+}
+
+func TestUpload_IgnoreFiles(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	u := NewUploader(th.repo)
+	s1, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	// Ignore "f2" everywhere except "d1/d2" and the entire "d2" directory.
+	th.sourceDir.AddFile(".kopiaignore", []byte("f2\n/d2/\n"), 0777)
+	th.sourceDir.AddFile("d1/d2/.kopiaignore", []byte("!f2\n"), 0777)
+
+	s2, err := u.Upload(th.sourceDir, &SourceInfo{}, s1)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	// Excluded: "f2", "d1/f2", "d1/d1/f2" and "d2"
+	if s2.Stats.ExcludedFileCount != 4 {
+		t.Errorf("unexpected s2 stats: %+v", s2.Stats)
+	}
+
+	// Included: "f1", "f3", ".kopiaignore", "d1/d1/f1", "d1/d2/f1", "d1/d2/f2" and "d1/d2/.kopiaignore"
+	if s2.Stats.TotalFileCount != 7 {
+		t.Errorf("unexpected s2 stats: %+v", s2.Stats)
+	}
+
+	if objectIDsEqual(s2.RootObjectID, s1.RootObjectID) {
+		t.Errorf("expected s2.RootObjectID!=s1.RootObjectID, got %v", s2.RootObjectID.String())
+	}
+}
+
+func TestUpload_StreamingFile(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	data := bytes.Repeat([]byte("some data "), 10000)
+	src := StdinSourceInfo("dump.sql", "host", "user")
+
+	u := NewUploader(th.repo)
+	s1, err := u.Upload(virtualfs.StreamingFile("dump.sql", bytes.NewReader(data)), &src, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s1.Stats.TotalFileCount != 1 || s1.Stats.TotalFileSize != int64(len(data)) {
+		t.Errorf("unexpected s1 stats: %+v", s1.Stats)
+	}
+
+	r, err := th.repo.Open(s1.RootObjectID)
+	if err != nil {
+		t.Fatalf("unable to open root object: %v", err)
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unable to read root object: %v", err)
+	}
+
+	if !bytes.Equal(b, data) {
+		t.Errorf("unexpected contents of root object")
+	}
+}
+
+func TestUpload_TarArchive(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"a/f1", "a/f2", "b/c/f3"} {
+		contents := []byte("contents of " + name)
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents)), ModTime: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)})
+		tw.Write(contents)
+	}
+	tw.Close()
+
+	src := TarSourceInfo("/some/archive.tar", "host", "user")
+	u := NewUploader(th.repo)
+
+	var manifests []*Manifest
+	for i := 0; i < 2; i++ {
+		a, err := tarfs.Open(bytes.NewReader(buf.Bytes()), "archive.tar")
+		if err != nil {
+			t.Fatalf("unable to open archive: %v", err)
+		}
+
+		var previous *Manifest
+		if len(manifests) > 0 {
+			previous = manifests[len(manifests)-1]
+		}
+
+		m, err := u.Upload(a.Root(), &src, previous)
+		if err != nil {
+			t.Fatalf("Upload error: %v", err)
+		}
+
+		manifests = append(manifests, m)
+	}
+
+	s1, s2 := manifests[0], manifests[1]
+	if s1.Stats.TotalFileCount != 3 || s1.Stats.TotalDirectoryCount != 4 || s1.Stats.NonCachedFiles != 3 {
+		t.Errorf("unexpected s1 stats: %+v", s1.Stats)
+	}
+
+	// Contents of the same archive are found in the hash cache.
+	if s2.RootObjectID.String() != s1.RootObjectID.String() || s2.Stats.CachedFiles != 3 || s2.Stats.NonCachedFiles != 0 {
+		t.Errorf("unexpected s2 stats: %+v", s2.Stats)
+	}
+}
+
+func TestUpload_Checkpoints(t *testing.T) {
+	// Checkpoints read packed hash cache blocks and finish packs, to which workers are adding blocks.
+	th := newUploadTestHarness(func(o *repo.NewRepositoryOptions) {
+		o.Splitter = "FIXED"
+		o.MaxBlockSize = 1000
+		o.MaxPackedContentLength = 100
+		o.MaxPackFileLength = 1000
+	})
+	defer th.cleanup()
+
+	for i := 0; i < 50; i++ {
+		th.sourceDir.AddFile(fmt.Sprintf("d1/d1/c%v", i), []byte(fmt.Sprintf("checkpoint-%v", i)), 0777)
+	}
+
+	var checkpoints []*Manifest
+
+	u := NewUploader(th.repo)
+	u.ParallelUploads = 4
+	u.CheckpointInterval = time.Nanosecond
+	u.CheckpointFunc = func(m *Manifest) error {
+		checkpoints = append(checkpoints, m)
+		return nil
+	}
+
+	s1, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if len(checkpoints) == 0 {
+		t.Fatalf("no checkpoints have been saved")
+	}
+
+	for _, m := range checkpoints {
+		if m.IncompleteReason != IncompleteReasonCheckpoint || !m.StartTime.Equal(s1.StartTime) {
+			t.Errorf("unexpected checkpoint manifest: %+v", m)
+		}
+
+		if _, err := th.repo.Open(m.RootObjectID); err != nil {
+			t.Errorf("unable to open checkpoint root %v: %v", m.RootObjectID, err)
+		}
+	}
+
+	// Upload resumed from the last checkpoint doesn't need to hash any files.
+	u.CheckpointInterval = 0
+	s2, err := u.Upload(th.sourceDir, &SourceInfo{}, checkpoints[len(checkpoints)-1])
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s2.Stats.NonCachedFiles != 0 || s2.RootObjectID.String() != s1.RootObjectID.String() {
+		t.Errorf("unexpected s2 stats: %+v, root %v, expected %v", s2.Stats, s2.RootObjectID, s1.RootObjectID)
+	}
+
+	// Checkpoints include the hash cache of the previous snapshot for files, which haven't been reached yet.
+	checkpoints = nil
+	u.CheckpointInterval = time.Nanosecond
+	if _, err := u.Upload(th.sourceDir, &SourceInfo{}, s1); err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	u.CheckpointInterval = 0
+	s3, err := u.Upload(th.sourceDir, &SourceInfo{}, checkpoints[0])
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s3.Stats.NonCachedFiles != 0 || s3.Stats.CachedFiles != s1.Stats.NonCachedFiles {
+		t.Errorf("unexpected s3 stats: %+v", s3.Stats)
+	}
+}
+
 func TestUpload_Cancel(t *testing.T) {
 }
 
@@ -222,6 +814,383 @@ func TestUpload_SubDirectoryReadFailure(t *testing.T) {
 	}
 }
 
+func TestUpload_EntryErrors(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	th.sourceDir.Subdir("d1").FailReaddir(&os.PathError{Op: "open", Path: "d1", Err: os.ErrPermission})
+	th.sourceDir.Subdir("d2", "d1").FailReaddir(&os.PathError{Op: "open", Path: "d2/d1", Err: os.ErrNotExist})
+
+	u := NewUploader(th.repo)
+	s, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s.Stats.ReadErrors != 2 || len(s.EntryErrors) != 2 {
+		t.Fatalf("unexpected errors: %+v, %v", s.EntryErrors, s.Stats.ReadErrors)
+	}
+
+	if e := s.EntryErrors[0]; e.Path != "./d1" || e.Category != ErrorCategoryPermissionDenied {
+		t.Errorf("unexpected error: %+v", e)
+	}
+
+	if e := s.EntryErrors[1]; e.Path != "./d2/d1" || e.Category != ErrorCategoryVanished {
+		t.Errorf("unexpected error: %+v", e)
+	}
+
+	// Policy decides, which categories of errors fail the upload.
+	u.ErrorHandlingPolicy = ErrorHandlingPolicy{FailOnPermissionDenied: boolPtr(false), FailOnVanished: boolPtr(true)}
+	if _, err := u.Upload(th.sourceDir, &SourceInfo{}, nil); err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestUpload_IncompleteSubdirectories(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	f := th.sourceDir.AddFile("d1/d1/f3", []byte{1, 2, 3}, 0777)
+	f.FailOpen(&os.PathError{Op: "open", Path: "d1/d1/f3", Err: os.ErrPermission})
+
+	u := NewUploader(th.repo)
+	s1, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s1.Stats.ReadErrors != 1 {
+		t.Errorf("unexpected s1 stats: %+v", s1.Stats)
+	}
+
+	// Directories containing the skipped file, including the ones above its parent, are not reused from the hash cache.
+	f.FailOpen(nil)
+	s2, err := u.Upload(th.sourceDir, &SourceInfo{}, s1)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s2.Stats.ReadErrors != 0 || s2.Stats.CachedDirectories != s2.Stats.TotalDirectoryCount-3 {
+		t.Errorf("unexpected s2 stats: %+v", s2.Stats)
+	}
+
+	var names []string
+	for _, e := range readDirEntries(t, th.repo, s2.RootObjectID, "d1", "d1") {
+		names = append(names, e.Name)
+	}
+
+	if want := []string{"f1", "f2", "f3"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("unexpected entries of d1/d1: %v, want %v", names, want)
+	}
+}
+
+func TestUpload_ChangedFiles(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	stable := th.sourceDir.AddFile("d1/d1/stable", []byte{1, 2, 3}, 0777)
+	stable.ChangeWhileReading(2)
+	changing := th.sourceDir.AddFile("d1/d1/changing", []byte{1, 2, 3}, 0777)
+	changing.ChangeWhileReading(100)
+
+	u := NewUploader(th.repo)
+	u.ChangedFileRetries = 2
+	s1, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s1.Stats.InconsistentFiles != 1 {
+		t.Errorf("unexpected s1 stats: %+v", s1.Stats)
+	}
+
+	entries := readDirEntries(t, th.repo, s1.RootObjectID, "d1", "d1")
+	for _, e := range entries {
+		if e.Inconsistent != (e.Name == "changing") {
+			t.Errorf("unexpected inconsistent flag of %v: %v", e.Name, e.Inconsistent)
+		}
+	}
+
+	// The file, which may be inconsistent, and the directories containing it are not reused from the hash cache.
+	changing.ChangeWhileReading(0)
+	s2, err := u.Upload(th.sourceDir, &SourceInfo{}, s1)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s2.Stats.InconsistentFiles != 0 || s2.Stats.NonCachedFiles != 1 {
+		t.Errorf("unexpected s2 stats: %+v", s2.Stats)
+	}
+
+	for _, e := range readDirEntries(t, th.repo, s2.RootObjectID, "d1", "d1") {
+		if e.Inconsistent {
+			t.Errorf("unexpected inconsistent entry %v", e.Name)
+		}
+	}
+}
+
+func TestUpload_SpecialFilesAndAttributes(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	th.sourceDir.AddSpecial("d1/d1/fifo", fs.EntryTypeNamedPipe, 0600)
+	dev := th.sourceDir.AddSpecial("d1/d1/dev", fs.EntryTypeCharDevice, 0660)
+	dev.Metadata().Device = &fs.DeviceNumber{Major: 1, Minor: 3}
+
+	file := findEntry(t, th.sourceDir.Subdir("d1", "d1"), "f1")
+	file.Metadata().ExtendedAttributes = map[string][]byte{"user.a": []byte("value")}
+	file.Metadata().ACL = "user::rwx,user:1000:r--,group::rwx,mask::rwx,other::rwx"
+
+	u := NewUploader(th.repo)
+	s1, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	entries := map[string]*dir.Entry{}
+	for _, e := range readDirEntries(t, th.repo, s1.RootObjectID, "d1", "d1") {
+		entries[e.Name] = e
+	}
+
+	if e := entries["fifo"]; e == nil || e.Type != fs.EntryTypeNamedPipe || e.Permissions != 0600 {
+		t.Errorf("unexpected fifo entry: %+v", e)
+	}
+
+	if e := entries["dev"]; e == nil || e.Type != fs.EntryTypeCharDevice || e.Device == nil || *e.Device != *dev.Metadata().Device {
+		t.Errorf("unexpected device entry: %+v", e)
+	}
+
+	if e := entries["f1"]; e == nil || string(e.ExtendedAttributes["user.a"]) != "value" || e.ACL != file.Metadata().ACL {
+		t.Errorf("unexpected file entry: %+v", e)
+	}
+
+	// Changing only extended attributes changes the file entry and directories containing it.
+	file.Metadata().ExtendedAttributes["user.a"] = []byte("other")
+	s2, err := u.Upload(th.sourceDir, &SourceInfo{}, s1)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s2.Stats.CachedDirectories != s2.Stats.TotalDirectoryCount-3 {
+		t.Errorf("unexpected s2 stats: %+v", s2.Stats)
+	}
+
+	for _, e := range readDirEntries(t, th.repo, s2.RootObjectID, "d1", "d1") {
+		if e.Name == "f1" && string(e.ExtendedAttributes["user.a"]) != "other" {
+			t.Errorf("unexpected file entry: %+v", e)
+		}
+	}
+}
+
+func TestUpload_HardLinks(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	for _, name := range []string{"d1/d1/link", "d2/link"} {
+		f := th.sourceDir.AddFile(name, []byte{1, 2, 3, 4, 5, 6}, 0777)
+		f.Metadata().HardLinkID = "linked"
+	}
+
+	u := NewUploader(th.repo)
+	s1, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s1.Stats.HardLinkedFiles != 1 || s1.Stats.NonCachedFiles != 11 || s1.Stats.TotalFileCount != 12 {
+		t.Errorf("unexpected s1 stats: %+v", s1.Stats)
+	}
+
+	var oids []string
+	for _, path := range [][]string{{"d1", "d1"}, {"d2"}} {
+		for _, e := range readDirEntries(t, th.repo, s1.RootObjectID, path...) {
+			if e.Name == "link" {
+				if e.HardLinkID != "linked" || e.FileSize != 6 {
+					t.Errorf("unexpected hard link entry: %+v", e)
+				}
+				oids = append(oids, e.ObjectID.String())
+			}
+		}
+	}
+
+	if len(oids) != 2 || oids[0] != oids[1] {
+		t.Errorf("unexpected object IDs of hard links: %v", oids)
+	}
+
+	// The first link is found in the hash cache and the other one still refers to it.
+	s2, err := u.Upload(th.sourceDir, &SourceInfo{}, s1)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s2.Stats.HardLinkedFiles != 1 || s2.Stats.NonCachedFiles != 0 || !objectIDsEqual(s2.RootObjectID, s1.RootObjectID) {
+		t.Errorf("unexpected s2 stats: %+v", s2.Stats)
+	}
+
+	// Directories containing recently modified links aren't reused, including the one with the second link.
+	for _, path := range [][]string{{"d1", "d1"}, {"d2"}} {
+		findEntry(t, th.sourceDir.Subdir(path...), "link").Metadata().ModTime = time.Now()
+	}
+
+	u.HashCacheMinAge = time.Hour
+	s3, err := u.Upload(th.sourceDir, &SourceInfo{}, s2)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	s4, err := u.Upload(th.sourceDir, &SourceInfo{}, s3)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s4.Stats.CachedDirectories != s4.Stats.TotalDirectoryCount-4 {
+		t.Errorf("unexpected s4 stats: %+v", s4.Stats)
+	}
+}
+
+func TestUpload_SparseFiles(t *testing.T) {
+	th := newUploadTestHarness()
+	defer th.cleanup()
+
+	contents := make([]byte, 300)
+	copy(contents[100:], []byte{1, 2, 3})
+	copy(contents[200:], []byte{4, 5, 6})
+
+	holes := []fs.Hole{{Offset: 0, Length: 100}, {Offset: 103, Length: 97}, {Offset: 203, Length: 97}}
+	f := th.sourceDir.AddFile("d1/sparse", contents, 0777)
+	f.Metadata().Holes = holes
+
+	u := NewUploader(th.repo)
+	s1, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	var de *dir.Entry
+	for _, e := range readDirEntries(t, th.repo, s1.RootObjectID, "d1") {
+		if e.Name == "sparse" {
+			de = e
+		}
+	}
+
+	if de == nil || de.FileSize != 300 || fmt.Sprint(de.Holes) != fmt.Sprint(holes) {
+		t.Fatalf("unexpected sparse file entry: %+v", de)
+	}
+
+	// Only data is stored in the object.
+	r, err := th.repo.Open(de.ObjectID)
+	if err != nil {
+		t.Fatalf("unable to open object: %v", err)
+	}
+	defer r.Close()
+
+	if data, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(data, []byte{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("unexpected object contents: %v %v", data, err)
+	}
+
+	// Filling holes changes the entry even if the size and modification time are the same.
+	f.Metadata().Holes = holes[0:1]
+	s2, err := u.Upload(th.sourceDir, &SourceInfo{}, s1)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	if s2.Stats.NonCachedFiles != 1 {
+		t.Errorf("unexpected s2 stats: %+v", s2.Stats)
+	}
+
+	// Data written into a hole while the file is being read is stored by the next attempt.
+	contents[150] = 7
+	md := *f.Metadata()
+	md.ModTime = md.ModTime.Add(time.Second)
+	md.Holes = []fs.Hole{{Offset: 0, Length: 100}, {Offset: 203, Length: 97}}
+	f.Metadata().Holes = holes
+	f.ChangeMetadataWhileReading(&md)
+
+	u.ChangedFileRetries = 1
+	s3, err := u.Upload(th.sourceDir, &SourceInfo{}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	de = nil
+	for _, e := range readDirEntries(t, th.repo, s3.RootObjectID, "d1") {
+		if e.Name == "sparse" {
+			de = e
+		}
+	}
+
+	if de == nil || de.Inconsistent || fmt.Sprint(de.Holes) != fmt.Sprint(md.Holes) {
+		t.Fatalf("unexpected sparse file entry: %+v", de)
+	}
+
+	verifyObjectContents(t, th.repo, de.ObjectID, contents[100:203])
+
+	// Holes of root files aren't recorded anywhere, so they are stored as zeros.
+	s4, err := u.Upload(f, &SourceInfo{Path: "/sparse"}, nil)
+	if err != nil {
+		t.Fatalf("Upload error: %v", err)
+	}
+
+	verifyObjectContents(t, th.repo, s4.RootObjectID, contents)
+}
+
+func verifyObjectContents(t *testing.T, r *repo.Repository, oid repo.ObjectID, expected []byte) {
+	rd, err := r.Open(oid)
+	if err != nil {
+		t.Fatalf("unable to open object: %v", err)
+	}
+	defer rd.Close()
+
+	if data, err := ioutil.ReadAll(rd); err != nil || !bytes.Equal(data, expected) {
+		t.Errorf("unexpected contents of %v: %v %v, expected %v", oid.String(), data, err, expected)
+	}
+}
+
+func findEntry(t *testing.T, d fs.Directory, name string) fs.Entry {
+	entries, err := d.Readdir()
+	if err != nil {
+		t.Fatalf("unable to read directory: %v", err)
+	}
+
+	e := entries.FindByName(name)
+	if e == nil {
+		t.Fatalf("entry %v not found", name)
+	}
+
+	return e
+}
+
+// readDirEntries returns entries of the directory with the specified path in the snapshot.
+func readDirEntries(t *testing.T, r *repo.Repository, oid repo.ObjectID, path ...string) []*dir.Entry {
+	for _, p := range path {
+		var found bool
+		for _, e := range readDirEntries(t, r, oid) {
+			if e.Name == p {
+				oid = e.ObjectID
+				found = true
+			}
+		}
+
+		if !found {
+			t.Fatalf("directory %v not found", p)
+		}
+	}
+
+	rd, err := r.Open(oid)
+	if err != nil {
+		t.Fatalf("unable to open directory: %v", err)
+	}
+	defer rd.Close()
+
+	entries, err := dir.ReadEntries(rd)
+	if err != nil {
+		t.Fatalf("unable to read directory: %v", err)
+	}
+
+	return entries
+}
+
 func objectIDsEqual(o1 repo.ObjectID, o2 repo.ObjectID) bool {
 	return reflect.DeepEqual(o1, o2)
 }